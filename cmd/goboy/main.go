@@ -96,10 +96,7 @@ func startGBLoop(gameboy *gb.Gameboy, monitor gb.IOBinding) {
 	start := time.Now()
 	frames := 0
 
-	var cartName string
-	if gameboy.IsGameLoaded() {
-		cartName = gameboy.Memory.Cart.GetName()
-	}
+	cartName := gameboy.Title()
 
 	for range ticker.C {
 		if !monitor.IsRunning() {
@@ -1,24 +1,35 @@
-//go:build !noAudio
-
 package apu
 
 import (
 	"fmt"
 	"math"
-	"time"
-
-	"log"
-
-	"github.com/hajimehoshi/oto"
+	"sync"
+	"sync/atomic"
 )
 
+// SampleRate is the default number of sound samples produced each second.
+const SampleRate = 44100
+
 const (
-	sampleRate = 44100
-	twoPi      = 2 * math.Pi
-	perSample  = 1 / float64(sampleRate)
+	twoPi = 2 * math.Pi
+
+	// Number of CPU cycles each second at normal speed.
+	clockSpeed = 4194304
 
-	cpuTicksPerSample    = float64(4194304) / sampleRate
 	maxFrameBufferLength = 5000
+	bufferSeconds        = 120
+
+	// Maximum amount the sample rate is changed by the dynamic rate control,
+	// which is small enough that the change in pitch is not heard.
+	maxRateDelta = 0.005
+)
+
+// Charge factors of the capacitor in the high-pass filter on the sound output
+// for each CPU cycle. The filter removes the DC offset from the output, and
+// charges at a different rate on the MGB and CGB.
+const (
+	ChargeFactorDMG = 0.999958
+	ChargeFactorCGB = 0.998943
 )
 
 // APU is the GameBoy's audio processing unit. Audio comprises four
@@ -28,23 +39,72 @@ const (
 // waveform channel which can be set in RAM, and channel 4 outputs noise.
 type APU struct {
 	playing bool
+	muted   bool
+	powered bool
 
 	memory      [52]byte
 	waveformRam []byte
 
-	player                 *oto.Player
+	// Number of samples produced each second.
+	sampleRate int
+
 	chn1, chn2, chn3, chn4 *Channel
-	tickCounter            float64
-	lVol, rVol             float64
+	// Counter of CPU ticks multiplied by the sample rate, so that a sample is
+	// produced each time it passes the clock speed without any rounding.
+	tickCounter float64
+	lVol, rVol  float64
+
+	// If the sample rate is adjusted to keep the output buffer level steady.
+	dynamicRate bool
+
+	// Charge factor of the high-pass filter for each sample, or 0 if the
+	// filter is disabled, and the charge of the capacitor for each side.
+	chargeFactor           float64
+	capacitorL, capacitorR float64
+
+	frameSequencerStep byte
+
+	// Generators for channels 3 and 4 which are set when the channel is
+	// triggered.
+	waveformGenerator WaveGenerator
+	noiseGenerator    WaveGenerator
+	noise             *lfsr
 
 	audioBuffer chan [2]byte
+
+	// Buffer of samples for ReadSamples, which are only kept once it has
+	// been called.
+	sourceEnabled atomic.Bool
+	sourceMu      sync.Mutex
+	sourceBuffer  []float32
 }
 
-// Init the sound emulation for a Gameboy.
-func (a *APU) Init(sound bool) {
-	a.playing = sound
-	a.waveformRam = make([]byte, 0x20)
+// Init the sound emulation for a Gameboy, producing sampleRate samples for
+// each second of emulation. The output device is opened at the same rate. If
+// the sampleRate is 0 then SampleRate is used.
+func (a *APU) Init(sound bool, sampleRate int) {
+	if sampleRate <= 0 {
+		sampleRate = SampleRate
+	}
+	a.sampleRate = sampleRate
 	a.audioBuffer = make(chan [2]byte, maxFrameBufferLength)
+	a.Reset()
+
+	if sound {
+		a.playing = a.startPlayer(bufferSeconds)
+	}
+}
+
+// Reset the state of the sound emulation to the initial values. This will
+// keep the sound output device if one has been started.
+func (a *APU) Reset() {
+	a.powered = true
+	a.memory = [52]byte{}
+	a.tickCounter = 0
+	a.lVol, a.rVol = 0, 0
+	a.capacitorL, a.capacitorR = 0, 0
+	a.frameSequencerStep = 0
+	a.waveformRam = make([]byte, 0x20)
 
 	// Sets waveform ram to:
 	// 00 FF 00 FF  00 FF 00 FF  00 FF 00 FF  00 FF 00 FF
@@ -57,61 +117,105 @@ func (a *APU) Init(sound bool) {
 	}
 
 	// Create the channels with their sounds
-	a.chn1 = NewChannel()
-	a.chn2 = NewChannel()
-	a.chn3 = NewChannel()
-	a.chn4 = NewChannel()
+	a.chn1 = NewChannel(a.sampleRate)
+	a.chn2 = NewChannel(a.sampleRate)
+	a.chn3 = NewChannel(a.sampleRate)
+	a.chn4 = NewChannel(a.sampleRate)
+	a.waveformGenerator = Waveform(func(i int) byte { return a.waveformRam[i] })
+	a.noise = newLFSR()
+	a.noiseGenerator = a.noise.generate
+	a.clearSourceSamples()
+}
 
-	const bufferSeconds = 120
+// SetHighPassFilter sets the charge factor of the high-pass filter on the sound
+// output for each CPU cycle, such as ChargeFactorDMG or ChargeFactorCGB. The
+// filter removes the DC offset, so the output is centred instead of silence
+// being at the lowest value. A factor of 0 disables the filter.
+func (a *APU) SetHighPassFilter(chargeFactor float64) {
+	a.chargeFactor = 0
+	if chargeFactor != 0 {
+		a.chargeFactor = math.Pow(chargeFactor, float64(clockSpeed)/float64(a.sampleRate))
+	}
+	a.capacitorL, a.capacitorR = 0, 0
+}
 
-	if sound {
-		otoCtx, err := oto.NewContext(sampleRate, 2, 1, sampleRate/bufferSeconds)
-		if err != nil {
-			log.Printf("error creating oto context: %v", err)
-		}
+// Filter a pair of output values with the high-pass filter, returning the
+// values centred on 128. The capacitor only charges while one of the DACs is
+// on, otherwise the output is silent.
+func (a *APU) highPass(left, right float64) (float64, float64) {
+	if !a.chn1.dacEnabled && !a.chn2.dacEnabled && !a.chn3.dacEnabled && !a.chn4.dacEnabled {
+		return 128, 128
+	}
+	outL := left - a.capacitorL
+	outR := right - a.capacitorR
+	a.capacitorL = left - outL*a.chargeFactor
+	a.capacitorR = right - outR*a.chargeFactor
+	return clampSample(outL + 128), clampSample(outR + 128)
+}
+
+// Clamp an output value to the range of a sample.
+func clampSample(value float64) float64 {
+	return max(0, min(255, value))
+}
+
+// SetDynamicRateControl enables or disables the dynamic rate control. When
+// enabled, the rate samples are produced at is adjusted by up to 0.5% based on
+// how full the buffer of samples waiting to be played by the output device is,
+// so that it stays near the number of samples the device plays at a time. This
+// stops the sound crackling when the emulator is run at a rate slightly
+// different to real time, such as when a frame is run on each refresh of a
+// display which is not exactly 60Hz. This has no effect on the samples read
+// with ReadSamples when there is no output device.
+func (a *APU) SetDynamicRateControl(enabled bool) {
+	a.dynamicRate = enabled
+}
 
-		a.player = otoCtx.NewPlayer()
-		a.playSound(bufferSeconds)
+// Get the ratio the number of CPU ticks for each sample is multiplied by for
+// the dynamic rate control. This is a simple proportional controller, which
+// produces fewer samples as the output buffer fills past its target level
+// and more as it empties.
+func (a *APU) rateRatio() float64 {
+	if !a.dynamicRate || !a.playing {
+		return 1
 	}
+	target := a.sampleRate / bufferSeconds
+	fill := min(float64(len(a.audioBuffer))/float64(2*target), 1)
+	return 1 + maxRateDelta*(2*fill-1)
 }
 
-// Starts a goroutine which plays the sound
-func (a *APU) playSound(bufferSeconds int) {
-	frameTime := time.Second / time.Duration(bufferSeconds)
-	ticker := time.NewTicker(frameTime)
-	targetSamples := sampleRate / bufferSeconds
-	go func() {
-		var reading [2]byte
-		var buffer []byte
-		for range ticker.C {
-			fbLen := len(a.audioBuffer)
-			if fbLen >= targetSamples/2 {
-				newBuffer := make([]byte, fbLen*2)
-				for i := 0; i < fbLen*2; i += 2 {
-					reading = <-a.audioBuffer
-					newBuffer[i], newBuffer[i+1] = reading[0], reading[1]
-				}
-				buffer = newBuffer
-			}
+// SampleRate returns the number of samples produced each second.
+func (a *APU) SampleRate() int {
+	return a.sampleRate
+}
 
-			_, err := a.player.Write(buffer)
-			// log.Printf("sound buffer len: %v", len(buffer))
-			if err != nil {
-				log.Printf("error sampling: %v", err)
-			}
-		}
-	}()
+// SetOutputEnabled enables or disables the sound output at runtime. While
+// disabled the channels continue to be sampled, but silence is written to the
+// output device, so that the sound stays in sync when it is enabled again.
+// Only the output device is muted, the samples read with ReadSamples are
+// unaffected so that the sound can still be recorded. The output device is
+// started if it was not already.
+func (a *APU) SetOutputEnabled(enabled bool) {
+	if enabled && !a.playing {
+		a.playing = a.startPlayer(bufferSeconds)
+	}
+	a.muted = !enabled
 }
 
+// Buffer samples the channels into the audio buffer if sound is playing, or
+// samples are being read with ReadSamples. The cpuTicks are divided by the
+// current CPU speed, so that samples are produced at the same rate when the CGB
+// is running in double speed mode.
 func (a *APU) Buffer(cpuTicks int, speed int) {
-	if !a.playing {
+	pulling := a.sourceEnabled.Load()
+	if !a.playing && !pulling {
 		return
 	}
-	a.tickCounter += float64(cpuTicks) / float64(speed)
-	if a.tickCounter < cpuTicksPerSample {
+	a.tickCounter += float64(cpuTicks * a.sampleRate)
+	ticksPerSample := float64(clockSpeed*speed) * a.rateRatio()
+	if a.tickCounter < ticksPerSample {
 		return
 	}
-	a.tickCounter -= cpuTicksPerSample
+	a.tickCounter -= ticksPerSample
 
 	chn1l, chn1r := a.chn1.Sample()
 	chn2l, chn2r := a.chn2.Sample()
@@ -121,7 +225,24 @@ func (a *APU) Buffer(cpuTicks int, speed int) {
 	valL := (chn1l + chn2l + chn3l + chn4l) / 4
 	valR := (chn1r + chn2r + chn3r + chn4r) / 4
 
-	a.audioBuffer <- [2]byte{byte(float64(valL) * a.lVol), byte(float64(valR) * a.rVol)}
+	left, right := float64(valL)*a.lVol, float64(valR)*a.rVol
+	var silence float64
+	if a.chargeFactor != 0 {
+		left, right = a.highPass(left, right)
+		silence = 128
+	}
+
+	sample := [2]byte{byte(left), byte(right)}
+	if a.playing {
+		if a.muted {
+			a.audioBuffer <- [2]byte{byte(silence), byte(silence)}
+		} else {
+			a.audioBuffer <- sample
+		}
+	}
+	if pulling {
+		a.pushSourceSample(sample)
+	}
 }
 
 var soundMask = []byte{
@@ -134,11 +255,13 @@ var soundMask = []byte{
 
 var channel3Volume = map[byte]float64{0: 0, 1: 1, 2: 0.5, 3: 0.25}
 
-var squareLimits = map[byte]float64{
-	0: -0.25, // 12.5% ( _-------_-------_------- )
-	1: -0.5,  // 25%   ( __------__------__------ )
-	2: 0,     // 50%   ( ____----____----____---- ) (normal)
-	3: 0.5,   // 75%   ( ______--______--______-- )
+// Square wave generators for each duty. These are created once so that
+// the generator of a channel can be changed without any allocations.
+var squareGenerators = [4]WaveGenerator{
+	Square(-0.25), // 12.5% ( _-------_-------_------- )
+	Square(-0.5),  // 25%   ( __------__------__------ )
+	Square(0),     // 50%   ( ____----____----____---- ) (normal)
+	Square(0.5),   // 75%   ( ______--______--______-- )
 }
 
 // Read returns a value from the APU.
@@ -146,52 +269,57 @@ func (a *APU) Read(address uint16) byte {
 	if address >= 0xFF30 {
 		return a.waveformRam[address-0xFF30]
 	}
+	if address == 0xFF26 {
+		// P--- 4321 Power and channel status
+		status := byte(0x70)
+		if a.powered {
+			status |= 0x80
+		}
+		for i, chn := range []*Channel{a.chn1, a.chn2, a.chn3, a.chn4} {
+			if chn.enabled {
+				status |= 1 << i
+			}
+		}
+		return status
+	}
 	// TODO: we should modify the sound memory as we're sampling
 	return a.memory[address-0xFF00] & soundMask[address-0xFF10]
 }
 
 // Write a value to the APU registers.
 func (a *APU) Write(address uint16, value byte) {
+	// While powered off, writes to all registers except NR52 are ignored.
+	if !a.powered && address != 0xFF26 {
+		return
+	}
 	a.memory[address-0xFF00] = value
 
 	switch address {
 	// Channel 1
 	case 0xFF10:
 		// -PPP NSSS Sweep period, negate, shift
-		a.chn1.sweepStepLen = (a.memory[0x10] & 0b111_0000) >> 4
-		a.chn1.sweepSteps = a.memory[0x10] & 0b111
-		a.chn1.sweepIncrease = a.memory[0x10]&0b1000 == 0 // 1 = decrease
+		a.chn1.sweepPeriod = (value & 0b111_0000) >> 4
+		a.chn1.sweepNegate = value&0b1000 != 0 // 1 = decrease
+		a.chn1.sweepShift = value & 0b111
 	case 0xFF11:
 		// DDLL LLLL Duty, Length load
 		duty := (value & 0b1100_0000) >> 6
-		a.chn1.generator = Square(squareLimits[duty])
-		a.chn1.length = int(value & 0b0011_1111)
+		a.chn1.generator = squareGenerators[duty]
+		a.chn1.lengthCounter = 64 - int(value&0b0011_1111)
 	case 0xFF12:
 		// VVVV APPP - Starting volume, Envelop add mode, period
-		envVolume, envDirection, envSweep := a.extractEnvelope(value)
-		a.chn1.envelopeVolume = int(envVolume)
-		a.chn1.envelopeSamples = int(envSweep) * sampleRate / 64
-		a.chn1.envelopeIncreasing = envDirection == 1
+		a.chn1.setEnvelope(a.extractEnvelope(value))
 	case 0xFF13:
 		// FFFF FFFF Frequency LSB
 		frequencyValue := uint16(a.memory[0x14]&0b111)<<8 | uint16(value)
-		a.chn1.frequency = 131072 / (2048 - float64(frequencyValue))
+		a.chn1.setSquareFrequency(frequencyValue)
 	case 0xFF14:
 		// TL-- -FFF Trigger, Length Enable, Frequencu MSB
 		frequencyValue := uint16(value&0b111)<<8 | uint16(a.memory[0x13])
-		a.chn1.frequency = 131072 / (2048 - float64(frequencyValue))
-		if value&0b1000_0000 != 0 {
-			if a.chn1.length == 0 {
-				a.chn1.length = 64
-			}
-			duration := -1
-			if value&0b100_0000 != 0 { // 1 = use length
-				duration = int(float64(a.chn1.length)*(1/64)) * sampleRate
-			}
-			a.chn1.Reset(duration)
-			a.chn1.envelopeSteps = a.chn1.envelopeVolume
-			a.chn1.envelopeStepsInit = a.chn1.envelopeVolume
-			// TODO: Square 1's sweep does several things (see frequency sweep).
+		a.chn1.setSquareFrequency(frequencyValue)
+		if a.writeLengthControl(a.chn1, value, 64) {
+			a.chn1.Reset()
+			a.chn1.triggerSweep()
 		}
 
 	// Channel 2
@@ -200,42 +328,33 @@ func (a *APU) Write(address uint16, value byte) {
 	case 0xFF16:
 		// DDLL LLLL Duty, Length load (64-L)
 		pattern := (value & 0b1100_0000) >> 6
-		a.chn2.generator = Square(squareLimits[pattern])
-		a.chn2.length = int(value & 0b11_1111)
+		a.chn2.generator = squareGenerators[pattern]
+		a.chn2.lengthCounter = 64 - int(value&0b11_1111)
 	case 0xFF17:
 		// VVVV APPP Starting volume, Envelope add mode, period
-		envVolume, envDirection, envSweep := a.extractEnvelope(value)
-		a.chn2.envelopeVolume = int(envVolume)
-		a.chn2.envelopeSamples = int(envSweep) * sampleRate / 64
-		a.chn2.envelopeIncreasing = envDirection == 1
+		a.chn2.setEnvelope(a.extractEnvelope(value))
 	case 0xFF18:
 		// FFFF FFFF Frequency LSB
 		frequencyValue := uint16(a.memory[0x19]&0b111)<<8 | uint16(value)
-		a.chn2.frequency = 131072 / (2048 - float64(frequencyValue))
+		a.chn2.setSquareFrequency(frequencyValue)
 	case 0xFF19:
 		// TL-- -FFF Trigger, Length enable, Frequency MSB
-		if value&0b1000_0000 != 0 {
-			if a.chn2.length == 0 {
-				a.chn2.length = 64
-			}
-			duration := -1
-			if value&0b100_0000 != 0 {
-				duration = int(float64(a.chn2.length)*(1/64)) * sampleRate
-			}
-			a.chn2.Reset(duration)
-			a.chn2.envelopeSteps = a.chn2.envelopeVolume
-			a.chn2.envelopeStepsInit = a.chn2.envelopeVolume
+		if a.writeLengthControl(a.chn2, value, 64) {
+			a.chn2.Reset()
 		}
 		frequencyValue := uint16(value&0b111)<<8 | uint16(a.memory[0x18])
-		a.chn2.frequency = 131072 / (2048 - float64(frequencyValue))
+		a.chn2.setSquareFrequency(frequencyValue)
 
 	// Channel 3
 	case 0xFF1A:
 		// E--- ---- DAC power
-		a.chn3.envelopeStepsInit = int((value & 0b1000_0000) >> 7)
+		a.chn3.dacEnabled = value&0b1000_0000 != 0
+		if !a.chn3.dacEnabled {
+			a.chn3.enabled = false
+		}
 	case 0xFF1B:
 		// LLLL LLLL Length load
-		a.chn3.length = int(value)
+		a.chn3.lengthCounter = 256 - int(value)
 	case 0xFF1C:
 		// -VV- ---- Volume code
 		selection := (value & 0b110_0000) >> 5
@@ -246,16 +365,9 @@ func (a *APU) Write(address uint16, value byte) {
 		a.chn3.frequency = 65536 / (2048 - float64(frequencyValue))
 	case 0xFF1E:
 		// TL-- -FFF Trigger, Length enable, Frequency MSB
-		if value&0b1000_0000 != 0 {
-			if a.chn3.length == 0 {
-				a.chn3.length = 256
-			}
-			duration := -1
-			if value&0b100_0000 != 0 { // 1 = use length
-				duration = int((256-float64(a.chn3.length))*(1/256)) * sampleRate
-			}
-			a.chn3.generator = Waveform(func(i int) byte { return a.waveformRam[i] })
-			a.chn3.duration = duration
+		if a.writeLengthControl(a.chn3, value, 256) {
+			a.chn3.generator = a.waveformGenerator
+			a.chn3.enabled = a.chn3.dacEnabled
 		}
 		frequencyValue := uint16(value&0b111)<<8 | uint16(a.memory[0x1D])
 		a.chn3.frequency = 65536 / (2048 - float64(frequencyValue))
@@ -265,33 +377,20 @@ func (a *APU) Write(address uint16, value byte) {
 		// ---- ---- Not used
 	case 0xFF20:
 		// --LL LLLL Length load
-		a.chn4.length = int(value & 0b11_1111)
+		a.chn4.lengthCounter = 64 - int(value&0b11_1111)
 	case 0xFF21:
 		// VVVV APPP Starting volume, Envelope add mode, period
-		envVolume, envDirection, envSweep := a.extractEnvelope(value)
-		a.chn4.envelopeVolume = int(envVolume)
-		a.chn4.envelopeSamples = int(envSweep) * sampleRate / 64
-		a.chn4.envelopeIncreasing = envDirection == 1
+		a.chn4.setEnvelope(a.extractEnvelope(value))
 	case 0xFF22:
 		// SSSS WDDD Clock shift, Width mode of LFSR, Divisor code
-		shiftClock := float64((value & 0b1111_0000) >> 4)
-		// TODO: counter step width
-		divRatio := float64(value & 0b111)
-		if divRatio == 0 {
-			divRatio = 0.5
-		}
-		a.chn4.frequency = 524288 / divRatio / math.Pow(2, shiftClock+1)
+		a.noise.short = value&0b1000 != 0
+		a.chn4.frequency = noiseFrequency(value)
 	case 0xFF23:
 		// TL-- ---- Trigger, Length enable
-		if value&0x80 == 0x80 {
-			duration := -1
-			if value&0b100_0000 != 0 { // 1 = use length
-				duration = int(float64(61-a.chn4.length)*(1/256)) * sampleRate
-			}
-			a.chn4.generator = Noise()
-			a.chn4.Reset(duration)
-			a.chn4.envelopeSteps = a.chn4.envelopeVolume
-			a.chn4.envelopeStepsInit = a.chn4.envelopeVolume
+		if a.writeLengthControl(a.chn4, value, 64) {
+			a.chn4.generator = a.noiseGenerator
+			a.noise.reset(a.chn4.time)
+			a.chn4.Reset()
 		}
 
 	case 0xFF24:
@@ -309,8 +408,111 @@ func (a *APU) Write(address uint16, value byte) {
 		a.chn2.onL = value&0x20 != 0
 		a.chn3.onL = value&0x40 != 0
 		a.chn4.onL = value&0x80 != 0
+
+	case 0xFF26:
+		// P--- ---- Power control
+		a.setPower(value&0x80 != 0)
 	}
-	// TODO: if writing to FF26 bit 7 destroy all contents (also cannot access)
+}
+
+// Get the frequency the noise LFSR is clocked at from the value of NR43. The
+// divisor code r and clock shift s give a frequency of 262144 / (r * 2^s) Hz,
+// where a divisor code of 0 is treated as 0.5. With a clock shift of 14 or 15
+// the LFSR is not clocked.
+func noiseFrequency(nr43 byte) float64 {
+	shiftClock := nr43 >> 4
+	if shiftClock >= 14 {
+		return 0
+	}
+	divRatio := float64(nr43 & 0b111)
+	if divRatio == 0 {
+		divRatio = 0.5
+	}
+	return 262144 / divRatio / float64(uint(1)<<shiftClock)
+}
+
+// Power the APU on or off. Powering off clears all of the sound registers,
+// which also turns off all of the channels. Powering back on resets the
+// frame sequencer so the next step is step 0.
+func (a *APU) setPower(on bool) {
+	if on == a.powered {
+		return
+	}
+	if !on {
+		for address := uint16(0xFF10); address < 0xFF26; address++ {
+			a.Write(address, 0)
+		}
+	} else {
+		a.frameSequencerStep = 0
+	}
+	a.powered = on
+}
+
+// StepFrameSequencer advances the 512Hz frame sequencer by a single step. This
+// should be called on the falling edge of bit 4 of the DIV register (bit 5 in
+// CGB double speed mode).
+//
+// The frame sequencer clocks the length counters on steps 0, 2, 4 and 6, the
+// sweep unit on steps 2 and 6, and the envelope units on step 7.
+func (a *APU) StepFrameSequencer() {
+	switch a.frameSequencerStep {
+	case 0, 4:
+		a.clockLengths()
+	case 2, 6:
+		a.clockLengths()
+		if a.chn1.clockSweep() {
+			a.writeSweepFrequency()
+		}
+	case 7:
+		a.chn1.clockEnvelope()
+		a.chn2.clockEnvelope()
+		a.chn4.clockEnvelope()
+	}
+	a.frameSequencerStep = (a.frameSequencerStep + 1) & 7
+}
+
+// Clock the length counters of all of the channels.
+func (a *APU) clockLengths() {
+	a.chn1.clockLength()
+	a.chn2.clockLength()
+	a.chn3.clockLength()
+	a.chn4.clockLength()
+}
+
+// Update the length enable of a channel from a write to its NRx4 register.
+// Returns true if the write should trigger the channel.
+//
+// If the next step of the frame sequencer will not clock the length counter,
+// then enabling the length will clock it once immediately, and triggering the
+// channel with the length enabled will reload the counter with one less than
+// its maximum value.
+func (a *APU) writeLengthControl(chn *Channel, value byte, maxLength int) bool {
+	wasEnabled := chn.lengthEnabled
+	chn.lengthEnabled = value&0b100_0000 != 0
+	trigger := value&0b1000_0000 != 0
+	extraClock := a.frameSequencerStep&1 == 1
+
+	if extraClock && !wasEnabled && chn.lengthEnabled && chn.lengthCounter > 0 {
+		chn.lengthCounter--
+		if chn.lengthCounter == 0 && !trigger {
+			chn.enabled = false
+		}
+	}
+
+	if trigger && chn.lengthCounter == 0 {
+		chn.lengthCounter = maxLength
+		if extraClock && chn.lengthEnabled {
+			chn.lengthCounter--
+		}
+	}
+	return trigger
+}
+
+// Write the frequency calculated by the channel 1 sweep back into
+// the NR13 and NR14 registers, as the hardware does.
+func (a *APU) writeSweepFrequency() {
+	a.memory[0x13] = byte(a.chn1.frequencyValue)
+	a.memory[0x14] = a.memory[0x14]&0b1111_1000 | byte(a.chn1.frequencyValue>>8)&0b111
 }
 
 // WriteWaveform writes a value to the waveform ram.
@@ -332,16 +534,22 @@ func (a *APU) ToggleSoundChannel(channel int) {
 	case 4:
 		a.chn4.debugOff = !a.chn4.debugOff
 	}
-	log.Printf("Toggle Channel %v mute", channel)
 }
 
+// SoundState returns a string of the state of the sound registers for
+// debugging.
+func (a *APU) SoundState() string {
+	return "Channel 3\n" +
+		fmt.Sprintf("  0xFF1A E--- ---- = %08b\n", a.memory[0x1A]) +
+		fmt.Sprintf("  0xFF1B LLLL LLLL = %08b\n", a.memory[0x1B]) +
+		fmt.Sprintf("  0xFF1C -VV- ---- = %08b\n", a.memory[0x1C]) +
+		fmt.Sprintf("  0xFF1D FFFF FFFF = %08b\n", a.memory[0x1D]) +
+		fmt.Sprintf("  0xFF1E TL-- -FFF = %08b\n", a.memory[0x1E])
+}
+
+// LogSoundState prints the state of the sound registers to stdout.
 func (a *APU) LogSoundState() {
-	fmt.Println("Channel 3")
-	fmt.Printf("  0xFF1A E--- ---- = %08b\n", a.memory[0x1A])
-	fmt.Printf("  0xFF1B LLLL LLLL = %08b\n", a.memory[0x1B])
-	fmt.Printf("  0xFF1C -VV- ---- = %08b\n", a.memory[0x1C])
-	fmt.Printf("  0xFF1D FFFF FFFF = %08b\n", a.memory[0x1D])
-	fmt.Printf("  0xFF1E TL-- -FFF = %08b\n", a.memory[0x1E])
+	fmt.Print(a.SoundState())
 }
 
 // Extract some envelope variables from a byte.
@@ -0,0 +1,691 @@
+package apu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAPU() *APU {
+	a := &APU{}
+	a.Init(false, SampleRate)
+	return a
+}
+
+// Trigger channel 1 with an 11 bit frequency value.
+func triggerChannel1(a *APU, frequency uint16) {
+	a.Write(0xFF13, byte(frequency))
+	a.Write(0xFF14, 0x80|byte(frequency>>8))
+}
+
+func TestChannel1_SweepIncrease(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF10, 0x12) // Period 1, increase, shift 2
+	a.Write(0xFF12, 0xF0)
+	triggerChannel1(a, 0x400)
+	assert.True(t, a.chn1.enabled)
+
+	// Each sweep clock adds (frequency >> 2) to the frequency. The second
+	// calculation after 2000 would overflow and should disable the channel.
+	for _, expected := range []uint16{1280, 1600, 2000} {
+		assert.True(t, a.chn1.clockSweep())
+		assert.Equal(t, expected, a.chn1.frequencyValue)
+	}
+	assert.False(t, a.chn1.enabled, "channel should be disabled by the overflow check")
+}
+
+func TestChannel1_SweepNegate(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF10, 0x29) // Period 2, decrease, shift 1
+	a.Write(0xFF12, 0xF0)
+	triggerChannel1(a, 0x400)
+
+	// The sweep should only update on every second clock.
+	for _, expected := range []uint16{1024, 512, 512, 256, 256, 128} {
+		a.chn1.clockSweep()
+		assert.Equal(t, expected, a.chn1.frequencyValue)
+	}
+	assert.True(t, a.chn1.enabled)
+
+	// Frequency should also be written back to NR13 and NR14.
+	a.writeSweepFrequency()
+	assert.Equal(t, byte(128), a.memory[0x13])
+	assert.Equal(t, byte(0x80), a.memory[0x14])
+}
+
+func TestChannel1_SweepOverflowOnTrigger(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF10, 0x11) // Period 1, increase, shift 1
+	a.Write(0xFF12, 0xF0)
+	triggerChannel1(a, 0x700)
+	assert.False(t, a.chn1.enabled, "trigger overflow check should disable the channel")
+}
+
+func TestChannel1_SweepZeroShift(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF10, 0x10) // Period 1, increase, shift 0
+	a.Write(0xFF12, 0xF0)
+	triggerChannel1(a, 0x200)
+
+	// With a shift of 0 the frequency is never updated.
+	for i := 0; i < 4; i++ {
+		assert.False(t, a.chn1.clockSweep())
+		assert.Equal(t, uint16(0x200), a.chn1.frequencyValue)
+	}
+	assert.True(t, a.chn1.enabled)
+
+	// However the overflow check is still performed, so a frequency which
+	// would double past 2047 disables the channel.
+	triggerChannel1(a, 0x400)
+	a.chn1.clockSweep()
+	assert.False(t, a.chn1.enabled)
+}
+
+func TestChannel_EnvelopeDecrease(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF12, 0xF2) // Volume 15, decrease, period 2
+	triggerChannel1(a, 0x400)
+	assert.Equal(t, byte(15), a.chn1.envelopeVolume)
+
+	for _, expected := range []byte{15, 14, 14, 13, 13, 12} {
+		a.chn1.clockEnvelope()
+		assert.Equal(t, expected, a.chn1.envelopeVolume)
+	}
+	assert.InDelta(t, 12.0/15, a.chn1.amplitude, 0.0001)
+}
+
+func TestChannel_EnvelopeIncrease(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF17, 0xD9) // Volume 13, increase, period 1
+	a.Write(0xFF19, 0x80)
+
+	// Volume should stop increasing at 15.
+	for _, expected := range []byte{14, 15, 15} {
+		a.chn2.clockEnvelope()
+		assert.Equal(t, expected, a.chn2.envelopeVolume)
+	}
+}
+
+func TestChannel_EnvelopeZeroPeriod(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF12, 0x80) // Volume 8, decrease, period 0
+	triggerChannel1(a, 0x400)
+
+	for i := 0; i < 16; i++ {
+		a.chn1.clockEnvelope()
+	}
+	assert.Equal(t, byte(8), a.chn1.envelopeVolume)
+}
+
+func TestChannel_DACDisabled(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF12, 0xF0)
+	triggerChannel1(a, 0x400)
+	assert.True(t, a.chn1.enabled)
+
+	// Writing zero to the upper 5 bits of NRx2 turns off the DAC.
+	a.Write(0xFF12, 0x07)
+	assert.False(t, a.chn1.enabled)
+}
+
+// Step the frame sequencer a number of times.
+func stepFrameSequencer(a *APU, steps int) {
+	for i := 0; i < steps; i++ {
+		a.StepFrameSequencer()
+	}
+}
+
+func TestChannel4_LFSRWidth(t *testing.T) {
+	tests := []struct {
+		name   string
+		nr43   byte
+		period int
+	}{
+		{name: "15 bit", nr43: 0x00, period: 0x7FFF},
+		{name: "7 bit", nr43: 0x08, period: 0x7F},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := newTestAPU()
+			a.Write(0xFF21, 0xF0)
+			a.Write(0xFF22, test.nr43)
+			a.Write(0xFF23, 0x80)
+			assert.Equal(t, uint16(lfsrInitial), a.noise.value)
+
+			// Step once so the register is in its repeating sequence, as in
+			// the 7 bit mode the upper bits only settle after the first step.
+			a.noise.step()
+			start := a.noise.value
+			period := 0
+			for {
+				a.noise.step()
+				period++
+				if a.noise.value == start || period > 0x8000 {
+					break
+				}
+			}
+			assert.Equal(t, test.period, period)
+		})
+	}
+}
+
+func TestChannel4_Frequency(t *testing.T) {
+	tests := []struct {
+		nr43      byte
+		frequency float64
+	}{
+		{nr43: 0x00, frequency: 524288},
+		{nr43: 0x01, frequency: 262144},
+		{nr43: 0x07, frequency: 262144.0 / 7},
+		{nr43: 0x10, frequency: 262144},
+		{nr43: 0x32, frequency: 262144.0 / 2 / 8},
+		{nr43: 0x3A, frequency: 262144.0 / 2 / 8},
+		{nr43: 0xD0, frequency: 524288.0 / (1 << 13)},
+		{nr43: 0xE0, frequency: 0},
+		{nr43: 0xF7, frequency: 0},
+	}
+	a := newTestAPU()
+	for _, test := range tests {
+		a.Write(0xFF22, test.nr43)
+		assert.Equal(t, test.frequency, a.chn4.frequency, "incorrect frequency for NR43 %#02x", test.nr43)
+		assert.Equal(t, test.nr43&0x08 != 0, a.noise.short, "incorrect width for NR43 %#02x", test.nr43)
+	}
+}
+
+func TestAPU_FrameSequencerEnvelope(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF12, 0xF1) // Volume 15, decrease, period 1
+	triggerChannel1(a, 0x400)
+
+	// Envelope is clocked on step 7, so after 7 steps nothing has changed.
+	stepFrameSequencer(a, 7)
+	assert.Equal(t, byte(15), a.chn1.envelopeVolume)
+
+	stepFrameSequencer(a, 1)
+	assert.Equal(t, byte(14), a.chn1.envelopeVolume)
+
+	stepFrameSequencer(a, 8)
+	assert.Equal(t, byte(13), a.chn1.envelopeVolume)
+}
+
+func TestAPU_FrameSequencerSweep(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF10, 0x11) // Period 1, increase, shift 1
+	a.Write(0xFF12, 0xF0)
+	triggerChannel1(a, 0x100)
+
+	// Sweep is clocked on steps 2 and 6.
+	for _, expected := range []uint16{0x100, 0x100, 0x180, 0x180, 0x180, 0x180, 0x240, 0x240} {
+		stepFrameSequencer(a, 1)
+		assert.Equal(t, expected, a.chn1.frequencyValue)
+	}
+}
+
+func TestAPU_LengthCounter(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF12, 0xF0)
+	a.Write(0xFF11, 60) // Length of 64-60 = 4
+	a.Write(0xFF14, 0xC0)
+	assert.Equal(t, 4, a.chn1.lengthCounter)
+
+	// Length is clocked on steps 0, 2, 4 and 6, so the channel is
+	// disabled on the 4th clock at step 6.
+	stepFrameSequencer(a, 6)
+	assert.True(t, a.chn1.enabled)
+	assert.Equal(t, 1, a.chn1.lengthCounter)
+	stepFrameSequencer(a, 1)
+	assert.False(t, a.chn1.enabled)
+	assert.Equal(t, 0, a.chn1.lengthCounter)
+}
+
+func TestAPU_LengthCounterDisabled(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF17, 0xF0)
+	a.Write(0xFF16, 63)
+	a.Write(0xFF19, 0x80) // Trigger without length enabled
+
+	stepFrameSequencer(a, 16)
+	assert.True(t, a.chn2.enabled)
+	assert.Equal(t, 1, a.chn2.lengthCounter)
+}
+
+func TestAPU_LengthCounterWave(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF1A, 0x80)
+	a.Write(0xFF1B, 0) // Length of 256
+	a.Write(0xFF1E, 0xC0)
+	assert.Equal(t, 256, a.chn3.lengthCounter)
+
+	stepFrameSequencer(a, 255*2)
+	assert.True(t, a.chn3.enabled)
+	stepFrameSequencer(a, 1)
+	assert.False(t, a.chn3.enabled)
+}
+
+func TestAPU_LengthExtraClock(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF21, 0xF0)
+	a.Write(0xFF20, 60)
+	a.Write(0xFF23, 0x80) // Trigger without length enabled
+
+	// Move the frame sequencer so the next step does not clock length.
+	stepFrameSequencer(a, 1)
+
+	// Enabling the length should clock the counter once.
+	a.Write(0xFF23, 0x40)
+	assert.Equal(t, 3, a.chn4.lengthCounter)
+	assert.True(t, a.chn4.enabled)
+
+	// Already enabled, so no extra clock.
+	a.Write(0xFF23, 0x40)
+	assert.Equal(t, 3, a.chn4.lengthCounter)
+}
+
+func TestAPU_LengthExtraClockDisables(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF12, 0xF0)
+	a.Write(0xFF11, 63) // Length of 1
+	a.Write(0xFF14, 0x80)
+	stepFrameSequencer(a, 1)
+
+	a.Write(0xFF14, 0x40)
+	assert.Equal(t, 0, a.chn1.lengthCounter)
+	assert.False(t, a.chn1.enabled)
+}
+
+func TestAPU_LengthTriggerReload(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF12, 0xF0)
+
+	// Triggering with a zero length reloads the counter with 64.
+	a.Write(0xFF11, 63)
+	a.Write(0xFF14, 0xC0)
+	stepFrameSequencer(a, 1)
+	assert.Equal(t, 0, a.chn1.lengthCounter)
+	a.Write(0xFF14, 0xC0)
+	assert.Equal(t, 63, a.chn1.lengthCounter, "should be 63 as next step does not clock length")
+
+	// When the next step clocks length the counter is reloaded with 64.
+	stepFrameSequencer(a, 1)
+	a.Write(0xFF11, 63)
+	stepFrameSequencer(a, 2)
+	assert.Equal(t, 0, a.chn1.lengthCounter)
+	a.Write(0xFF14, 0xC0)
+	assert.Equal(t, 64, a.chn1.lengthCounter)
+}
+
+func TestAPU_PowerOff(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF24, 0x77)
+	a.Write(0xFF12, 0xF0)
+	triggerChannel1(a, 0x400)
+	assert.Equal(t, byte(0xF1), a.Read(0xFF26))
+
+	// Powering off should clear the registers and stop the channels.
+	a.Write(0xFF26, 0x00)
+	assert.Equal(t, byte(0x70), a.Read(0xFF26))
+	assert.Equal(t, byte(0x00), a.Read(0xFF24))
+	assert.Equal(t, byte(0x00), a.Read(0xFF12))
+	assert.False(t, a.chn1.enabled)
+
+	// Writes are ignored while powered off.
+	a.Write(0xFF24, 0x77)
+	assert.Equal(t, byte(0x00), a.Read(0xFF24))
+
+	a.Write(0xFF26, 0x80)
+	assert.Equal(t, byte(0xF0), a.Read(0xFF26))
+	a.Write(0xFF24, 0x77)
+	assert.Equal(t, byte(0x77), a.Read(0xFF24))
+}
+
+func TestAPU_PowerOnResetsFrameSequencer(t *testing.T) {
+	a := newTestAPU()
+	stepFrameSequencer(a, 3)
+	a.Write(0xFF26, 0x00)
+	a.Write(0xFF26, 0x80)
+	assert.Equal(t, byte(0), a.frameSequencerStep)
+}
+
+func TestAPU_SetOutputEnabled(t *testing.T) {
+	a := newTestAPU()
+	a.playing = true
+	a.Write(0xFF24, 0x77)
+	a.Write(0xFF25, 0xFF)
+	a.Write(0xFF11, 0x80)
+	a.Write(0xFF12, 0xF0)
+	triggerChannel1(a, 0x400)
+
+	// Take a number of samples and return the largest left output.
+	sample := func() byte {
+		var max byte
+		for i := 0; i < 1000; i++ {
+			for len(a.audioBuffer) == 0 {
+				a.Buffer(4, 1)
+			}
+			if s := <-a.audioBuffer; s[0] > max {
+				max = s[0]
+			}
+		}
+		return max
+	}
+	assert.NotZero(t, sample())
+
+	a.SetOutputEnabled(false)
+	assert.Zero(t, sample())
+	assert.True(t, a.chn1.enabled, "channel should keep running while muted")
+
+	a.SetOutputEnabled(true)
+	assert.NotZero(t, sample())
+}
+
+func TestAPU_SetOutputEnabledReadSamples(t *testing.T) {
+	a := newTestAPU()
+	a.playing = true
+	a.ReadSamples(nil)
+	a.Write(0xFF24, 0x77)
+	a.Write(0xFF25, 0xFF)
+	a.Write(0xFF11, 0x80)
+	a.Write(0xFF12, 0xF0)
+	triggerChannel1(a, 0x400)
+	a.SetOutputEnabled(false)
+
+	for i := 0; i < 1000; i++ {
+		a.Buffer(4, 1)
+	}
+	var played byte
+	for len(a.audioBuffer) > 0 {
+		played = max(played, (<-a.audioBuffer)[0])
+	}
+	assert.Zero(t, played, "output device should be muted")
+
+	buf := make([]float32, 1000)
+	n := a.ReadSamples(buf)
+	require.NotZero(t, n)
+	var read float32
+	for _, s := range buf[:n] {
+		read = max(read, s)
+	}
+	assert.Greater(t, read, float32(-1), "read samples should not be muted")
+}
+
+func TestAPU_WriteAllocations(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF1A, 0x80)
+	allocs := testing.AllocsPerRun(100, func() {
+		a.Write(0xFF11, 0x80)
+		a.Write(0xFF12, 0xF0)
+		a.Write(0xFF14, 0x80)
+		a.Write(0xFF16, 0x40)
+		a.Write(0xFF17, 0xF0)
+		a.Write(0xFF19, 0x80)
+		a.Write(0xFF1E, 0x80)
+		a.Write(0xFF21, 0xF0)
+		a.Write(0xFF23, 0x80)
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestAPU_BufferDoubleSpeed(t *testing.T) {
+	// Count the samples produced for a second of emulation. In double speed
+	// the CPU runs twice as many cycles in the same time.
+	countSamples := func(speed int) int {
+		a := newTestAPU()
+		a.playing = true
+		samples := 0
+		for cycles := 0; cycles < 4194304*speed; cycles += 4 {
+			a.Buffer(4, speed)
+			for len(a.audioBuffer) > 0 {
+				<-a.audioBuffer
+				samples++
+			}
+		}
+		return samples
+	}
+	assert.InDelta(t, SampleRate, countSamples(1), 1)
+	assert.InDelta(t, SampleRate, countSamples(2), 1)
+}
+
+func TestAPU_DynamicRateControl(t *testing.T) {
+	// Count the samples produced for a second of emulation while the output
+	// buffer is kept at a level.
+	countSamples := func(dynamic bool, level int) int {
+		a := newTestAPU()
+		a.playing = true
+		a.SetDynamicRateControl(dynamic)
+		for i := 0; i < level; i++ {
+			a.audioBuffer <- [2]byte{}
+		}
+		samples := 0
+		for cycles := 0; cycles < clockSpeed; cycles += 4 {
+			a.Buffer(4, 1)
+			for len(a.audioBuffer) > level {
+				<-a.audioBuffer
+				samples++
+			}
+		}
+		return samples
+	}
+	target := SampleRate / bufferSeconds
+
+	assert.Equal(t, SampleRate, countSamples(false, 0))
+	assert.InDelta(t, SampleRate, countSamples(true, target), 1, "should be unchanged at the target level")
+	assert.InDelta(t, SampleRate/(1-maxRateDelta), countSamples(true, 0), 1, "more samples should be produced when empty")
+	assert.InDelta(t, SampleRate/(1+maxRateDelta), countSamples(true, 4*target), 1, "fewer samples should be produced when full")
+}
+
+func TestAPU_SampleRate(t *testing.T) {
+	for _, rate := range []int{SampleRate, 48000, 22050, 32768, 96000} {
+		for _, speed := range []int{1, 2} {
+			a := &APU{}
+			a.Init(false, rate)
+			a.playing = true
+			samples := 0
+			for cycles := 0; cycles < clockSpeed*speed; cycles += 4 {
+				a.Buffer(4, speed)
+				for len(a.audioBuffer) > 0 {
+					<-a.audioBuffer
+					samples++
+				}
+			}
+			assert.Equal(t, rate, samples, "rate %v at speed %v", rate, speed)
+		}
+	}
+
+	a := &APU{}
+	a.Init(false, 0)
+	assert.Equal(t, SampleRate, a.SampleRate())
+}
+
+func TestAPU_ReadSamples(t *testing.T) {
+	a := newTestAPU()
+	a.Write(0xFF24, 0x77)
+	a.Write(0xFF25, 0xFF)
+	a.Write(0xFF11, 0x80)
+	a.Write(0xFF12, 0xF0)
+	triggerChannel1(a, 0x400)
+
+	// Samples are not kept until the source has been read from
+	a.Buffer(4000, 1)
+	buf := make([]float32, 2000)
+	assert.Equal(t, 0, a.ReadSamples(buf))
+
+	for cycles := 0; cycles < 4194304/10; cycles += 4 {
+		a.Buffer(4, 1)
+	}
+	n := a.ReadSamples(buf)
+	assert.Equal(t, len(buf), n)
+
+	var max float32 = -1
+	for _, s := range buf[:n] {
+		assert.True(t, s >= -1 && s <= 1, "sample out of range: %v", s)
+		if s > max {
+			max = s
+		}
+	}
+	assert.Greater(t, max, float32(-1))
+
+	// The rest of the samples from a tenth of a second should be available,
+	// and only whole stereo samples should be read.
+	odd := make([]float32, 10001)
+	assert.Equal(t, SampleRate/10*2-len(buf), a.ReadSamples(odd))
+	assert.Equal(t, 0, a.ReadSamples(odd))
+}
+
+func TestAPU_ReadSamplesMono(t *testing.T) {
+	a := newTestAPU()
+	assert.Equal(t, 0, a.ReadSamplesMono(nil))
+	for i := 0; i < 100; i++ {
+		a.pushSourceSample([2]byte{byte(i), 200})
+	}
+
+	// The stereo and mono reads share the same samples.
+	stereo := make([]float32, 20)
+	require.Equal(t, 20, a.ReadSamples(stereo))
+	mono := make([]float32, 100)
+	require.Equal(t, 90, a.ReadSamplesMono(mono))
+	for i, s := range mono[:90] {
+		left, right := float32(i+10-128)/128, float32(200-128)/128
+		assert.Equal(t, (left+right)/2, s, "incorrect mono sample %v", i)
+	}
+	assert.Equal(t, 0, a.ReadSamplesMono(mono))
+}
+
+func TestAPU_ReadSamplesLimit(t *testing.T) {
+	a := newTestAPU()
+	a.ReadSamples(nil)
+	for cycles := 0; cycles < 4194304*2; cycles += 4 {
+		a.Buffer(4, 1)
+	}
+	buf := make([]float32, a.maxSourceBufferLength()*2)
+	assert.Equal(t, a.maxSourceBufferLength(), a.ReadSamples(buf))
+}
+
+func TestAPU_State(t *testing.T) {
+	a := newTestAPU()
+	a.SetHighPassFilter(ChargeFactorDMG)
+	a.sourceEnabled.Store(true)
+	a.Write(0xFF24, 0x77)
+	a.Write(0xFF25, 0xFF)
+	a.Write(0xFF11, 0x80)
+	a.Write(0xFF12, 0xF3) // Decreasing envelope
+	triggerChannel1(a, 0x600)
+	a.Write(0xFF1A, 0x80)
+	a.Write(0xFF1C, 0x20)
+	a.Write(0xFF1E, 0x80|0x04)
+	a.Write(0xFF21, 0xF0)
+	a.Write(0xFF22, 0x21) // 7 bit width
+	a.Write(0xFF23, 0x80)
+
+	// Run part way through the note and the envelope.
+	for i := 0; i < 21; i++ {
+		a.StepFrameSequencer()
+		a.Buffer(1000, 1)
+	}
+
+	var state bytes.Buffer
+	require.NoError(t, a.SaveState(&state))
+	loaded := newTestAPU()
+	loaded.SetHighPassFilter(ChargeFactorDMG)
+	loaded.sourceEnabled.Store(true)
+	require.NoError(t, loaded.LoadState(&state))
+
+	// Both should continue with exactly the same samples.
+	a.clearSourceSamples()
+	for _, apu := range []*APU{a, loaded} {
+		for i := 0; i < 100; i++ {
+			if i%10 == 0 {
+				apu.StepFrameSequencer()
+			}
+			apu.Buffer(1000, 1)
+		}
+	}
+	expected := make([]float32, 200)
+	actual := make([]float32, 200)
+	require.Equal(t, 200, a.ReadSamples(expected))
+	require.Equal(t, 200, loaded.ReadSamples(actual))
+	assert.Equal(t, expected, actual)
+	assert.True(t, loaded.chn1.enabled, "note should still be playing")
+	assert.Equal(t, a.chn1.envelopeVolume, loaded.chn1.envelopeVolume)
+	assert.Equal(t, a.Read(0xFF26), loaded.Read(0xFF26))
+}
+
+func TestAPU_PackState(t *testing.T) {
+	// The packed state does not depend on the sample rate.
+	a := newTestAPU()
+	other := &APU{}
+	other.Init(false, SampleRate/2)
+	for _, apu := range []*APU{a, other} {
+		apu.sourceEnabled.Store(true)
+		apu.Write(0xFF24, 0x77)
+		apu.Write(0xFF12, 0xF3)
+		triggerChannel1(apu, 0x600)
+		for i := 0; i < 21; i++ {
+			apu.StepFrameSequencer()
+			apu.Buffer(1000, 1)
+		}
+	}
+
+	expected := make([]byte, PackedStateSize)
+	actual := make([]byte, PackedStateSize)
+	a.PackState(expected)
+	other.PackState(actual)
+	assert.Equal(t, expected, actual)
+
+	other.Write(0xFF24, 0x33)
+	other.PackState(actual)
+	assert.NotEqual(t, expected, actual)
+}
+
+func TestAPU_HighPassFilter(t *testing.T) {
+	// Get the output after a constant input for a number of samples.
+	decay := func(chargeFactor float64, samples int) float64 {
+		a := newTestAPU()
+		a.SetHighPassFilter(chargeFactor)
+		a.chn1.dacEnabled = true
+		var out float64
+		for i := 0; i < samples; i++ {
+			out, _ = a.highPass(100, 100)
+		}
+		return out
+	}
+	assert.Equal(t, 228.0, decay(ChargeFactorDMG, 1), "first sample should not be filtered")
+	assert.InDelta(t, 128, decay(ChargeFactorDMG, SampleRate), 0.01, "DC offset should be removed")
+	assert.Greater(t, decay(ChargeFactorDMG, 100), decay(ChargeFactorCGB, 100), "CGB should charge faster")
+
+	// With the DACs off the output is silent
+	a := newTestAPU()
+	a.SetHighPassFilter(ChargeFactorDMG)
+	left, right := a.highPass(100, 100)
+	assert.Equal(t, 128.0, left)
+	assert.Equal(t, 128.0, right)
+}
+
+func TestAPU_HighPassFilterSquare(t *testing.T) {
+	a := newTestAPU()
+	a.SetHighPassFilter(ChargeFactorCGB)
+	a.ReadSamples(nil)
+	a.Write(0xFF24, 0x77)
+	a.Write(0xFF25, 0xFF)
+	a.Write(0xFF11, 0x80)
+	a.Write(0xFF12, 0xF0)
+	triggerChannel1(a, 0x400)
+	for cycles := 0; cycles < 4194304/2; cycles += 4 {
+		a.Buffer(4, 1)
+	}
+
+	// The square wave should be centred around the middle of the output
+	buf := make([]float32, 2000)
+	n := a.ReadSamples(buf)
+	require.Equal(t, len(buf), n)
+	var low, high, sum float32 = 1, -1, 0
+	for i := 0; i < n; i += 2 {
+		low = min(low, buf[i])
+		high = max(high, buf[i])
+		sum += buf[i]
+	}
+	assert.Less(t, low, float32(0))
+	assert.Greater(t, high, float32(0))
+	assert.InDelta(t, 0, sum/float32(n/2), 0.05)
+}
@@ -1,10 +1,9 @@
 package apu
 
-import "math"
-
-// NewChannel returns a new sound channel using a sampling function.
-func NewChannel() *Channel {
-	return &Channel{}
+// NewChannel returns a new sound channel which is sampled sampleRate times
+// each second.
+func NewChannel(sampleRate int) *Channel {
+	return &Channel{sampleRate: float64(sampleRate)}
 }
 
 // Channel represents one of four Gameboy sound channels.
@@ -14,22 +13,34 @@ type Channel struct {
 	time      float64
 	amplitude float64
 
-	// Duration in samples
-	duration int
-	length   int
+	// Number of times the channel is sampled each second.
+	sampleRate float64
+
+	// Frequency register value (0-2047) used by the sweep unit.
+	frequencyValue uint16
+
+	// If the channel is currently on. This is set on trigger and can be
+	// cleared by the sweep overflow check or by turning off the DAC.
+	enabled    bool
+	dacEnabled bool
 
-	envelopeVolume     int
-	envelopeTime       int
-	envelopeSteps      int
-	envelopeStepsInit  int
-	envelopeSamples    int
-	envelopeIncreasing bool
+	// Length counter which disables the channel when it is clocked to zero
+	// while the length is enabled.
+	lengthCounter int
+	lengthEnabled bool
 
-	sweepTime     float64
-	sweepStepLen  byte
-	sweepSteps    byte
-	sweepStep     byte
-	sweepIncrease bool
+	envelopeInitial  byte
+	envelopeVolume   byte
+	envelopePeriod   byte
+	envelopeTimer    byte
+	envelopeIncrease bool
+
+	sweepPeriod  byte
+	sweepShift   byte
+	sweepNegate  bool
+	sweepTimer   byte
+	sweepEnabled bool
+	sweepShadow  uint16
 
 	onL bool
 	onR bool
@@ -38,22 +49,17 @@ type Channel struct {
 }
 
 // Sample returns a single sample for streaming the sound output. Each sample
-// will increase the internal timer based on the global sample rate.
+// will increase the internal timer based on the sample rate of the channel.
 func (chn *Channel) Sample() (outputL, outputR uint16) {
 	var output uint16
-	step := chn.frequency * twoPi / float64(sampleRate)
+	step := chn.frequency * twoPi / chn.sampleRate
 	chn.time += step
 	if chn.shouldPlay() {
 		// Take the sample value from the generator
 		if !chn.debugOff {
 			output = uint16(float64(chn.generator(chn.time)) * chn.amplitude)
 		}
-		if chn.duration > 0 {
-			chn.duration--
-		}
 	}
-	chn.updateEnvelope()
-	chn.updateSweep()
 	if chn.onL {
 		outputL = output
 	}
@@ -63,64 +69,134 @@ func (chn *Channel) Sample() (outputL, outputR uint16) {
 	return
 }
 
-// Reset the channel to some default variables for the sweep, amplitude,
-// envelope and duration.
-func (chn *Channel) Reset(duration int) {
-	chn.amplitude = 1
-	chn.envelopeTime = 0
-	chn.sweepTime = 0
-	chn.sweepStep = 0
-	chn.duration = duration
+// Reset the channel to some default variables for the amplitude and
+// envelope. This is called when the channel is triggered.
+func (chn *Channel) Reset() {
+	chn.enabled = chn.dacEnabled
+	chn.envelopeVolume = chn.envelopeInitial
+	chn.envelopeTimer = chn.envelopePeriod
+	chn.amplitude = float64(chn.envelopeVolume) / 15
 }
 
 // Returns if the channel should be playing or not.
 func (chn *Channel) shouldPlay() bool {
-	return (chn.duration == -1 || chn.duration > 0) &&
-		chn.generator != nil && chn.envelopeStepsInit > 0
+	return chn.generator != nil && chn.enabled
 }
 
-// Update the state of the channels envelope.
-func (chn *Channel) updateEnvelope() {
-	if chn.envelopeSamples > 0 {
-		chn.envelopeTime += 1
-		if chn.envelopeSteps > 0 && chn.envelopeTime >= chn.envelopeSamples {
-			chn.envelopeTime -= chn.envelopeSamples
-			chn.envelopeSteps--
-			if chn.envelopeSteps == 0 {
-				chn.amplitude = 0
-			} else if chn.envelopeIncreasing {
-				chn.amplitude = 1 - float64(chn.envelopeSteps)/float64(chn.envelopeStepsInit)
-			} else {
-				chn.amplitude = float64(chn.envelopeSteps) / float64(chn.envelopeStepsInit)
-			}
-		}
+// Clock the length counter, which happens at 256Hz. If the length is enabled
+// the counter is decremented, and when it reaches zero the channel is disabled.
+func (chn *Channel) clockLength() {
+	if !chn.lengthEnabled || chn.lengthCounter == 0 {
+		return
+	}
+	chn.lengthCounter--
+	if chn.lengthCounter == 0 {
+		chn.enabled = false
 	}
 }
 
-var sweepTimes = map[byte]float64{
-	1: 7.8 / 1000,
-	2: 15.6 / 1000,
-	3: 23.4 / 1000,
-	4: 31.3 / 1000,
-	5: 39.1 / 1000,
-	6: 46.9 / 1000,
-	7: 54.7 / 1000,
+// Set the frequency of a square channel from an 11 bit register value.
+func (chn *Channel) setSquareFrequency(value uint16) {
+	chn.frequencyValue = value
+	chn.frequency = 131072 / (2048 - float64(value))
 }
 
-// Update the state of the channels sweep.
-func (chn *Channel) updateSweep() {
-	if chn.sweepStep < chn.sweepSteps {
-		t := sweepTimes[chn.sweepStepLen]
-		chn.sweepTime += perSample
-		if chn.sweepTime > t {
-			chn.sweepTime -= t
-			chn.sweepStep += 1
-
-			if chn.sweepIncrease {
-				chn.frequency += chn.frequency / math.Pow(2, float64(chn.sweepStep))
-			} else {
-				chn.frequency -= chn.frequency / math.Pow(2, float64(chn.sweepStep))
-			}
-		}
+// Set the envelope of the channel from the values in a NRx2 register. The
+// DAC of the channel is powered off if the upper 5 bits are all zero.
+func (chn *Channel) setEnvelope(volume, direction, period byte) {
+	chn.envelopeInitial = volume
+	chn.envelopeIncrease = direction == 1
+	chn.envelopePeriod = period
+
+	chn.dacEnabled = volume != 0 || direction != 0
+	if !chn.dacEnabled {
+		chn.enabled = false
+	}
+}
+
+// Clock the volume envelope, which happens at 64Hz. On each clock the
+// envelope timer is decremented and when it reaches zero the volume is
+// stepped up or down, stopping at the bounds of 0 and 15.
+func (chn *Channel) clockEnvelope() {
+	if chn.envelopePeriod == 0 {
+		return
+	}
+	if chn.envelopeTimer > 0 {
+		chn.envelopeTimer--
+	}
+	if chn.envelopeTimer != 0 {
+		return
+	}
+	chn.envelopeTimer = chn.envelopePeriod
+
+	if chn.envelopeIncrease && chn.envelopeVolume < 15 {
+		chn.envelopeVolume++
+	} else if !chn.envelopeIncrease && chn.envelopeVolume > 0 {
+		chn.envelopeVolume--
+	}
+	chn.amplitude = float64(chn.envelopeVolume) / 15
+}
+
+// Get the period of the sweep timer. A period of 0 is treated as 8.
+func (chn *Channel) sweepTimerPeriod() byte {
+	if chn.sweepPeriod == 0 {
+		return 8
+	}
+	return chn.sweepPeriod
+}
+
+// Trigger the frequency sweep. The current frequency is copied to the
+// shadow register and if the shift is non-zero the overflow check is
+// performed immediately, which may disable the channel.
+func (chn *Channel) triggerSweep() {
+	chn.sweepShadow = chn.frequencyValue
+	chn.sweepTimer = chn.sweepTimerPeriod()
+	chn.sweepEnabled = chn.sweepPeriod != 0 || chn.sweepShift != 0
+	if chn.sweepShift != 0 {
+		chn.calculateSweep()
+	}
+}
+
+// Calculate the next sweep frequency from the shadow register. If the new
+// frequency overflows 11 bits then the channel is disabled.
+func (chn *Channel) calculateSweep() uint16 {
+	delta := chn.sweepShadow >> chn.sweepShift
+	newFrequency := chn.sweepShadow + delta
+	if chn.sweepNegate {
+		newFrequency = chn.sweepShadow - delta
+	}
+	if newFrequency > 2047 {
+		chn.enabled = false
+	}
+	return newFrequency
+}
+
+// Clock the frequency sweep, which happens at 128Hz. Returns true if the
+// frequency of the channel was updated.
+//
+// When the sweep timer expires a new frequency is calculated and, if it does
+// not overflow and the shift is non-zero, written back to the shadow register
+// and the channel frequency. The overflow check is then run a second time with
+// the new value, without writing it back, which can disable the channel.
+func (chn *Channel) clockSweep() bool {
+	if chn.sweepTimer > 0 {
+		chn.sweepTimer--
+	}
+	if chn.sweepTimer != 0 {
+		return false
+	}
+	chn.sweepTimer = chn.sweepTimerPeriod()
+
+	if !chn.sweepEnabled || chn.sweepPeriod == 0 {
+		return false
+	}
+
+	newFrequency := chn.calculateSweep()
+	if newFrequency > 2047 || chn.sweepShift == 0 {
+		return false
 	}
+	chn.sweepShadow = newFrequency
+	chn.setSquareFrequency(newFrequency)
+	chn.calculateSweep()
+	return true
 }
@@ -0,0 +1,48 @@
+//go:build !noAudio
+
+package apu
+
+import (
+	"log"
+	"time"
+
+	"github.com/hajimehoshi/oto"
+)
+
+// Start the sound output device and a goroutine which plays the sound
+// from the audio buffer. Returns false if the device could not be opened.
+func (a *APU) startPlayer(bufferSeconds int) bool {
+	otoCtx, err := oto.NewContext(a.sampleRate, 2, 1, a.sampleRate/bufferSeconds)
+	if err != nil {
+		log.Printf("error creating oto context: %v", err)
+		return false
+	}
+
+	player := otoCtx.NewPlayer()
+
+	frameTime := time.Second / time.Duration(bufferSeconds)
+	ticker := time.NewTicker(frameTime)
+	targetSamples := a.sampleRate / bufferSeconds
+	go func() {
+		var reading [2]byte
+		var buffer []byte
+		for range ticker.C {
+			fbLen := len(a.audioBuffer)
+			if fbLen >= targetSamples/2 {
+				newBuffer := make([]byte, fbLen*2)
+				for i := 0; i < fbLen*2; i += 2 {
+					reading = <-a.audioBuffer
+					newBuffer[i], newBuffer[i+1] = reading[0], reading[1]
+				}
+				buffer = newBuffer
+			}
+
+			_, err := player.Write(buffer)
+			// log.Printf("sound buffer len: %v", len(buffer))
+			if err != nil {
+				log.Printf("error sampling: %v", err)
+			}
+		}
+	}()
+	return true
+}
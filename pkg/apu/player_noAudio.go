@@ -0,0 +1,9 @@
+//go:build noAudio
+
+package apu
+
+// Start the sound output. When built without audio support there is no
+// device to write to, so the APU will never buffer any samples.
+func (a *APU) startPlayer(_ int) bool {
+	return false
+}
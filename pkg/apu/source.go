@@ -0,0 +1,65 @@
+package apu
+
+// Maximum number of values kept in the sample source buffer, which is one
+// second of stereo samples.
+func (a *APU) maxSourceBufferLength() int {
+	return a.sampleRate * 2
+}
+
+// ReadSamples reads the samples generated since the last call into buf, as
+// interleaved left and right samples in the range [-1, 1], and returns the
+// number of values written. The samples are produced at the sample rate given
+// to Init. This can be used to pull the sound output into any audio library
+// instead of using the built in output device, and is safe to call from a
+// different goroutine to the one running the emulator.
+//
+// Samples are only kept after the first call to ReadSamples, and if they are
+// not read then at most one second of samples is kept.
+func (a *APU) ReadSamples(buf []float32) int {
+	a.sourceMu.Lock()
+	defer a.sourceMu.Unlock()
+	a.sourceEnabled.Store(true)
+
+	// Only read whole stereo samples
+	n := copy(buf[:len(buf)&^1], a.sourceBuffer)
+	a.sourceBuffer = a.sourceBuffer[:copy(a.sourceBuffer, a.sourceBuffer[n:])]
+	return n
+}
+
+// ReadSamplesMono is the same as ReadSamples, but the left and right samples
+// are averaged into a single mono sample, so each value written to buf is one
+// sample. This reads from the same samples as ReadSamples, so the two can be
+// used together but each sample is only read once.
+func (a *APU) ReadSamplesMono(buf []float32) int {
+	a.sourceMu.Lock()
+	defer a.sourceMu.Unlock()
+	a.sourceEnabled.Store(true)
+
+	n := min(len(buf), len(a.sourceBuffer)/2)
+	for i := range buf[:n] {
+		buf[i] = (a.sourceBuffer[i*2] + a.sourceBuffer[i*2+1]) / 2
+	}
+	a.sourceBuffer = a.sourceBuffer[:copy(a.sourceBuffer, a.sourceBuffer[n*2:])]
+	return n
+}
+
+// Add a sample to the sample source buffer. The sample is dropped if the
+// buffer is full.
+func (a *APU) pushSourceSample(sample [2]byte) {
+	a.sourceMu.Lock()
+	defer a.sourceMu.Unlock()
+	if len(a.sourceBuffer)+2 > a.maxSourceBufferLength() {
+		return
+	}
+	a.sourceBuffer = append(a.sourceBuffer,
+		(float32(sample[0])-128)/128,
+		(float32(sample[1])-128)/128,
+	)
+}
+
+// Remove all of the samples in the sample source buffer.
+func (a *APU) clearSourceSamples() {
+	a.sourceMu.Lock()
+	defer a.sourceMu.Unlock()
+	a.sourceBuffer = a.sourceBuffer[:0]
+}
@@ -0,0 +1,219 @@
+package apu
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// State of a channel which is saved in save states. The fields are fixed size
+// so that they can be written with encoding/binary.
+type channelState struct {
+	Frequency      float64
+	Time           float64
+	Amplitude      float64
+	FrequencyValue uint16
+
+	Enabled    bool
+	DACEnabled bool
+
+	LengthCounter int32
+	LengthEnabled bool
+
+	EnvelopeInitial  byte
+	EnvelopeVolume   byte
+	EnvelopePeriod   byte
+	EnvelopeTimer    byte
+	EnvelopeIncrease bool
+
+	SweepPeriod  byte
+	SweepShift   byte
+	SweepNegate  bool
+	SweepTimer   byte
+	SweepEnabled bool
+	SweepShadow  uint16
+
+	OnL bool
+	OnR bool
+
+	// If the channel has a generator, which is restored from the registers
+	// as the generators are functions.
+	HasGenerator bool
+}
+
+// State of the APU which is saved in save states.
+type apuState struct {
+	Powered            bool
+	Memory             [52]byte
+	WaveformRAM        [0x20]byte
+	TickCounter        float64
+	LVol, RVol         float64
+	FrameSequencerStep byte
+	Channels           [4]channelState
+	NoiseLFSR          uint16
+	NoiseTime          float64
+	// Charge of the high-pass filter capacitor for each side.
+	CapacitorL, CapacitorR float64
+}
+
+// SaveState saves the internal state of the sound channels, including the
+// timers of the envelopes, lengths and sweep and the position of the frame
+// sequencer, so that sound continues seamlessly when the state is loaded.
+func (a *APU) SaveState(writer io.Writer) error {
+	state := apuState{
+		Powered:            a.powered,
+		Memory:             a.memory,
+		TickCounter:        a.tickCounter,
+		LVol:               a.lVol,
+		RVol:               a.rVol,
+		FrameSequencerStep: a.frameSequencerStep,
+		NoiseLFSR:          a.noise.value,
+		NoiseTime:          a.noise.last,
+		CapacitorL:         a.capacitorL,
+		CapacitorR:         a.capacitorR,
+	}
+	copy(state.WaveformRAM[:], a.waveformRam)
+	for i, chn := range a.channels() {
+		state.Channels[i] = chn.saveState()
+	}
+	return binary.Write(writer, binary.LittleEndian, &state)
+}
+
+// LoadState loads the state of the sound channels saved with SaveState.
+func (a *APU) LoadState(reader io.Reader) error {
+	var state apuState
+	if err := binary.Read(reader, binary.LittleEndian, &state); err != nil {
+		return err
+	}
+	a.powered = state.Powered
+	a.memory = state.Memory
+	copy(a.waveformRam, state.WaveformRAM[:])
+	a.tickCounter = state.TickCounter
+	a.lVol, a.rVol = state.LVol, state.RVol
+	a.frameSequencerStep = state.FrameSequencerStep
+
+	generators := [4]WaveGenerator{
+		squareGenerators[a.memory[0x11]>>6],
+		squareGenerators[a.memory[0x16]>>6],
+		a.waveformGenerator,
+		a.noiseGenerator,
+	}
+	for i, chn := range a.channels() {
+		chn.loadState(state.Channels[i], generators[i])
+	}
+	a.noise.value = state.NoiseLFSR
+	a.noise.short = a.memory[0x22]&0b1000 != 0
+	a.noise.last = state.NoiseTime
+	a.capacitorL, a.capacitorR = state.CapacitorL, state.CapacitorR
+	return nil
+}
+
+// PackedStateSize is the size of the state packed by PackState.
+const PackedStateSize = 86 + 4*channelPackedSize
+
+// Size of the state of a channel packed by packState.
+const channelPackedSize = 14
+
+// PackState packs the state of the sound registers and of the length,
+// envelope and sweep units into state, which must be at least PackedStateSize
+// bytes. Unlike SaveState the position of the channel waveforms is not
+// included, as it depends on the sample rate and if sound is being output, so
+// two emulators run with the same inputs pack the same state. This does not
+// allocate, so can be used to hash the state on every frame.
+func (a *APU) PackState(state []byte) {
+	state[0] = boolByte(a.powered)
+	copy(state[1:53], a.memory[:])
+	copy(state[53:85], a.waveformRam)
+	state[85] = a.frameSequencerStep
+	for i, chn := range a.channels() {
+		chn.packState(state[86+i*channelPackedSize:])
+	}
+}
+
+// Pack the state of the channel which is not affected by sampling.
+func (chn *Channel) packState(state []byte) {
+	binary.LittleEndian.PutUint16(state[0:], chn.frequencyValue)
+	binary.LittleEndian.PutUint16(state[2:], uint16(chn.lengthCounter))
+	binary.LittleEndian.PutUint16(state[4:], chn.sweepShadow)
+	state[6] = chn.envelopeInitial
+	state[7] = chn.envelopeVolume
+	state[8] = chn.envelopePeriod
+	state[9] = chn.envelopeTimer
+	state[10] = chn.sweepPeriod
+	state[11] = chn.sweepShift
+	state[12] = chn.sweepTimer
+	state[13] = boolByte(chn.enabled) | boolByte(chn.dacEnabled)<<1 |
+		boolByte(chn.lengthEnabled)<<2 | boolByte(chn.envelopeIncrease)<<3 |
+		boolByte(chn.sweepNegate)<<4 | boolByte(chn.sweepEnabled)<<5 |
+		boolByte(chn.onL)<<6 | boolByte(chn.onR)<<7
+}
+
+// Convert a bool to a byte of 1 or 0.
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Get the four sound channels.
+func (a *APU) channels() [4]*Channel {
+	return [4]*Channel{a.chn1, a.chn2, a.chn3, a.chn4}
+}
+
+// Get the state of the channel to save.
+func (chn *Channel) saveState() channelState {
+	return channelState{
+		Frequency:        chn.frequency,
+		Time:             chn.time,
+		Amplitude:        chn.amplitude,
+		FrequencyValue:   chn.frequencyValue,
+		Enabled:          chn.enabled,
+		DACEnabled:       chn.dacEnabled,
+		LengthCounter:    int32(chn.lengthCounter),
+		LengthEnabled:    chn.lengthEnabled,
+		EnvelopeInitial:  chn.envelopeInitial,
+		EnvelopeVolume:   chn.envelopeVolume,
+		EnvelopePeriod:   chn.envelopePeriod,
+		EnvelopeTimer:    chn.envelopeTimer,
+		EnvelopeIncrease: chn.envelopeIncrease,
+		SweepPeriod:      chn.sweepPeriod,
+		SweepShift:       chn.sweepShift,
+		SweepNegate:      chn.sweepNegate,
+		SweepTimer:       chn.sweepTimer,
+		SweepEnabled:     chn.sweepEnabled,
+		SweepShadow:      chn.sweepShadow,
+		OnL:              chn.onL,
+		OnR:              chn.onR,
+		HasGenerator:     chn.generator != nil,
+	}
+}
+
+// Load a saved state into the channel, using the generator if the channel had
+// one when it was saved.
+func (chn *Channel) loadState(state channelState, generator WaveGenerator) {
+	chn.frequency = state.Frequency
+	chn.time = state.Time
+	chn.amplitude = state.Amplitude
+	chn.frequencyValue = state.FrequencyValue
+	chn.enabled = state.Enabled
+	chn.dacEnabled = state.DACEnabled
+	chn.lengthCounter = int(state.LengthCounter)
+	chn.lengthEnabled = state.LengthEnabled
+	chn.envelopeInitial = state.EnvelopeInitial
+	chn.envelopeVolume = state.EnvelopeVolume
+	chn.envelopePeriod = state.EnvelopePeriod
+	chn.envelopeTimer = state.EnvelopeTimer
+	chn.envelopeIncrease = state.EnvelopeIncrease
+	chn.sweepPeriod = state.SweepPeriod
+	chn.sweepShift = state.SweepShift
+	chn.sweepNegate = state.SweepNegate
+	chn.sweepTimer = state.SweepTimer
+	chn.sweepEnabled = state.SweepEnabled
+	chn.sweepShadow = state.SweepShadow
+	chn.onL = state.OnL
+	chn.onR = state.OnR
+	chn.generator = nil
+	if state.HasGenerator {
+		chn.generator = generator
+	}
+}
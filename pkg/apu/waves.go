@@ -2,7 +2,6 @@ package apu
 
 import (
 	"math"
-	"math/rand"
 )
 
 // WaveGenerator is a function which can be used for generating waveform
@@ -29,16 +28,68 @@ func Waveform(ram func(i int) byte) WaveGenerator {
 	}
 }
 
-// Noise returns a wave generator for a noise channel. This is used by
-// channel 4.
+// Noise returns a wave generator for a noise channel using a 15 bit linear
+// feedback shift register. This is used by channel 4.
 func Noise() WaveGenerator {
-	var last float64
-	var val byte
-	return func(t float64) byte {
-		if t-last > twoPi {
-			last = t
-			val = byte(rand.Intn(2)) * 0xFF
-		}
-		return val
+	return newLFSR().generate
+}
+
+// Initial value of the noise LFSR when the channel is triggered.
+const lfsrInitial = 0x7FFF
+
+// lfsr is the linear feedback shift register which produces the pseudo-random
+// noise of channel 4. It is clocked once for each period of the channel
+// frequency.
+type lfsr struct {
+	value uint16
+	// If the register is in the 7 bit width mode, set by bit 3 of NR43. This
+	// gives a much shorter, more metallic sounding, period.
+	short bool
+	// Time the register was last clocked at.
+	last float64
+}
+
+// Create a new LFSR in the 15 bit width mode.
+func newLFSR() *lfsr {
+	return &lfsr{value: lfsrInitial}
+}
+
+// Reset the register to its initial value, which happens when the channel
+// is triggered at time t.
+func (l *lfsr) reset(t float64) {
+	l.value = lfsrInitial
+	l.last = t
+}
+
+// Clock the register once. The XOR of the lowest two bits is shifted into
+// bit 14, and in the 7 bit width mode also into bit 6.
+func (l *lfsr) step() {
+	xor := (l.value ^ l.value>>1) & 1
+	l.value = l.value>>1 | xor<<14
+	if l.short {
+		l.value = l.value&^(1<<6) | xor<<6
+	}
+}
+
+// Get the output of the register, which is the inverse of bit 0.
+func (l *lfsr) output() byte {
+	if l.value&1 == 0 {
+		return 0xFF
+	}
+	return 0
+}
+
+// Clock the register for each period of the channel frequency which has
+// passed since it was last clocked and return the output.
+func (l *lfsr) generate(t float64) byte {
+	if t < l.last {
+		l.last = t
+	}
+	steps := int((t - l.last) / twoPi)
+	l.last += float64(steps) * twoPi
+	// After a long gap there is no need to clock more than a full period.
+	for i := 0; i < min(steps, lfsrInitial); i++ {
+		l.step()
 	}
+	return l.output()
 }
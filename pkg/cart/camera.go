@@ -0,0 +1,246 @@
+package cart
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	// Size of the image captured by the camera sensor.
+	cameraWidth  = 128
+	cameraHeight = 112
+
+	// Number of camera registers mapped to 0xA000-0xA035.
+	cameraRegisterCount = 0x36
+
+	// Offset in RAM bank 0 that the captured image is written to.
+	cameraImageOffset = 0x100
+)
+
+// NewCamera returns a new Pocket Camera (MAC-GBD) memory controller.
+func NewCamera(data []byte) BankingController {
+	return &Camera{
+		BaseMBC: BaseMBC{
+			Rom:     data,
+			RomBank: 1,
+			Ram:     make([]byte, 0x20000),
+		},
+	}
+}
+
+// Camera is the memory controller of the Gameboy Camera, which supports rom
+// and ram banking similar to an MBC3, and has a set of registers to control
+// the camera sensor which are mapped over the RAM.
+//
+// Taking a picture is simplified compared to the real hardware. The picture is
+// taken immediately from the image source, which is scaled to the size of the
+// sensor and dithered using the matrix in the camera registers. The exposure
+// and edge enhancement settings are not emulated.
+type Camera struct {
+	BaseMBC
+	RamBank uint32
+
+	// If the camera registers are mapped instead of the RAM.
+	CameraMode bool
+	Registers  [cameraRegisterCount]byte
+
+	source func() image.Image
+}
+
+// SetSource sets the function which provides the image seen by the camera
+// when a picture is taken. If there is no source then the camera will see a
+// blank white image.
+func (r *Camera) SetSource(source func() image.Image) {
+	r.source = source
+}
+
+// Read returns a value at a memory address in the ROM, RAM or the camera
+// registers.
+func (r *Camera) Read(address uint16) byte {
+	switch {
+	case address < 0x4000:
+		return r.Rom[address] // Bank 0 is fixed
+	case address < 0x8000:
+		offset := r.RomBank * 0x4000 % uint32(len(r.Rom))
+		return r.Rom[offset+uint32(address-0x4000)] // Use selected rom bank
+	default:
+		if r.CameraMode {
+			// Only the first register can be read, the rest read as 0
+			if address&0x7F == 0 {
+				return r.Registers[0]
+			}
+			return 0x00
+		}
+		if !r.RamEnabled {
+			return 0xFF // RAM is disabled
+		}
+		return r.Ram[(0x2000*r.RamBank)+uint32(address-0xA000)] // Use selected ram bank
+	}
+}
+
+// WriteROM attempts to switch the ROM or RAM bank.
+func (r *Camera) WriteROM(address uint16, value byte) {
+	switch {
+	case address < 0x2000:
+		// RAM enable
+		r.setRAMEnabled(value&0xF == 0xA)
+	case address < 0x4000:
+		// ROM bank number, where bank 0 can be selected
+		r.RomBank = uint32(value & 0x3F)
+	case address < 0x6000:
+		// RAM bank number, or the camera registers if bit 4 is set
+		r.CameraMode = value&0x10 != 0
+		r.RamBank = uint32(value & 0xF)
+	}
+}
+
+// WriteRAM writes data to the ram if it is enabled, or to the camera registers.
+// Writing to bit 0 of the first register takes a picture.
+func (r *Camera) WriteRAM(address uint16, value byte) {
+	if r.CameraMode {
+		reg := address & 0x7F
+		if reg >= cameraRegisterCount {
+			return
+		}
+		r.Registers[reg] = value
+		if reg == 0 {
+			r.Registers[0] &= 0x7
+			if value&0x1 != 0 {
+				r.capture()
+			}
+		}
+		return
+	}
+	if r.RamEnabled {
+		r.Ram[(0x2000*r.RamBank)+uint32(address-0xA000)] = value
+		r.dirty = true
+	}
+}
+
+// Take a picture from the image source and write it to RAM bank 0 as 2bpp
+// tile data, in rows of 16 tiles.
+func (r *Camera) capture() {
+	var img image.Image
+	if r.source != nil {
+		img = r.source()
+	}
+
+	for y := 0; y < cameraHeight; y++ {
+		for x := 0; x < cameraWidth; x++ {
+			colour := r.ditherPixel(x, y, sensorValue(img, x, y))
+
+			tile := (y/8)*(cameraWidth/8) + x/8
+			offset := cameraImageOffset + tile*16 + (y%8)*2
+			bit := byte(0x80) >> (x % 8)
+			r.Ram[offset] &^= bit
+			r.Ram[offset+1] &^= bit
+			if colour&0x1 != 0 {
+				r.Ram[offset] |= bit
+			}
+			if colour&0x2 != 0 {
+				r.Ram[offset+1] |= bit
+			}
+		}
+	}
+	r.dirty = true
+
+	// The capture is finished immediately
+	r.Registers[0] &^= 0x1
+}
+
+// Get the brightness of a pixel seen by the camera sensor, by scaling the image
+// to the size of the sensor. Returns white if there is no image.
+func sensorValue(img image.Image, x, y int) byte {
+	if img == nil {
+		return 0xFF
+	}
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return 0xFF
+	}
+	px := bounds.Min.X + x*bounds.Dx()/cameraWidth
+	py := bounds.Min.Y + y*bounds.Dy()/cameraHeight
+	return color.GrayModel.Convert(img.At(px, py)).(color.Gray).Y
+}
+
+// Get the colour of a pixel by comparing it to the 3 thresholds for its
+// position in the 4x4 dither matrix in registers 0x06-0x35.
+func (r *Camera) ditherPixel(x, y int, value byte) byte {
+	thresholds := r.Registers[0x06+((y%4)*4+(x%4))*3:]
+	switch {
+	case value < thresholds[0]:
+		return 3
+	case value < thresholds[1]:
+		return 2
+	case value < thresholds[2]:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// BankState returns the selected banks and if the camera registers are mapped.
+func (r *Camera) BankState() BankState {
+	return BankState{
+		RomBank:    r.RomBank,
+		RamBank:    r.RamBank,
+		RamEnabled: r.RamEnabled,
+		CameraMode: r.CameraMode,
+	}
+}
+
+// GetSaveData returns the save data for this banking controller, which holds
+// the pictures that have been saved.
+func (r *Camera) GetSaveData() []byte {
+	data := make([]byte, len(r.Ram))
+	copy(data, r.Ram)
+	return data
+}
+
+// LoadSaveData loads the save data into the cartridge. An error is returned
+// if the data is not the same size as the cartridge RAM.
+func (r *Camera) LoadSaveData(data []byte) error {
+	return r.loadRAM(data)
+}
+
+// SaveState saves the state of the banking controller.
+func (r *Camera) SaveState(writer io.Writer) error {
+	// Write BaseMBC
+	if err := r.BaseMBC.SaveState(writer); err != nil {
+		return err
+	}
+
+	// Write rambank and camera mode
+	mode := byte(0)
+	if r.CameraMode {
+		mode = 1
+	}
+	if _, err := writer.Write([]byte{byte(r.RamBank), mode}); err != nil {
+		return err
+	}
+
+	// Write camera registers
+	_, err := writer.Write(r.Registers[:])
+	return err
+}
+
+// LoadState loads the state of the banking controller.
+func (r *Camera) LoadState(reader io.Reader) error {
+	// Read BaseMBC
+	if err := r.BaseMBC.LoadState(reader); err != nil {
+		return err
+	}
+
+	// Read rambank and camera mode
+	var tmp [2]byte
+	if err := binary.Read(reader, binary.LittleEndian, &tmp); err != nil {
+		return err
+	}
+	r.RamBank = uint32(tmp[0])
+	r.CameraMode = tmp[1] == 1
+
+	// Read camera registers
+	return binary.Read(reader, binary.LittleEndian, &r.Registers)
+}
@@ -0,0 +1,88 @@
+package cart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCamera_NewCart(t *testing.T) {
+	c := NewCart(appendBytes(
+		bytes.Repeat([]byte{0}, 0x147),
+		[]byte{0xFC},
+	), "test", nil)
+	_, ok := c.BankingController.(*Camera)
+	assert.True(t, ok, "expected camera controller but got %T", c.BankingController)
+	assert.True(t, c.HasBattery())
+}
+
+func TestCamera_Banking(t *testing.T) {
+	cam := NewCamera(bankedROM(64))
+	assert.Equal(t, byte(1), cam.Read(0x4000))
+	cam.WriteROM(0x2000, 0x00)
+	assert.Equal(t, byte(0), cam.Read(0x4000), "bank 0 should be selectable")
+	cam.WriteROM(0x2000, 0x3F)
+	assert.Equal(t, byte(0x3F), cam.Read(0x4000))
+
+	cam.WriteROM(0x0000, 0x0A)
+	cam.WriteROM(0x4000, 0x0F)
+	cam.WriteRAM(0xA000, 0x12)
+	assert.Equal(t, byte(0x12), cam.Read(0xA000))
+	assert.Equal(t, byte(0x12), cam.GetSaveData()[0xF*0x2000])
+
+	// Selecting the camera registers should not write to RAM
+	cam.WriteROM(0x4000, 0x10)
+	cam.WriteRAM(0xA001, 0x34)
+	assert.Equal(t, byte(0x00), cam.Read(0xA001), "only register 0 can be read")
+	cam.WriteROM(0x4000, 0x0F)
+	assert.Equal(t, byte(0x12), cam.Read(0xA000))
+}
+
+func TestCamera_Capture(t *testing.T) {
+	// An image where the left half is black and the right half is white
+	img := image.NewGray(image.Rect(0, 0, 256, 224))
+	for y := 0; y < 224; y++ {
+		for x := 128; x < 256; x++ {
+			img.SetGray(x, y, color.Gray{Y: 0xFF})
+		}
+	}
+	cam := NewCamera(bankedROM(64)).(*Camera)
+	cam.SetSource(func() image.Image { return img })
+
+	// Set every dither threshold to the same values
+	cam.WriteROM(0x4000, 0x10)
+	for i := uint16(0); i < 16; i++ {
+		cam.WriteRAM(0xA006+i*3, 0x40)
+		cam.WriteRAM(0xA007+i*3, 0x80)
+		cam.WriteRAM(0xA008+i*3, 0xC0)
+	}
+	cam.WriteRAM(0xA000, 0x03)
+	assert.Equal(t, byte(0x02), cam.Read(0xA000), "capture should be finished")
+
+	data := cam.GetSaveData()
+	// First tile is black and is written to 0xA100
+	assert.Equal(t, bytes.Repeat([]byte{0xFF}, 16), data[0x100:0x110])
+	// Tile 8 on the first row is white
+	assert.Equal(t, make([]byte, 16), data[0x180:0x190])
+	// Last tile is white
+	assert.Equal(t, make([]byte, 16), data[0x100+223*16:0x100+224*16])
+	assert.True(t, cam.IsDirty())
+}
+
+func TestCamera_State(t *testing.T) {
+	cam := NewCamera(bankedROM(64))
+	cam.WriteROM(0x4000, 0x10)
+	cam.WriteRAM(0xA006, 0x55)
+
+	var buf bytes.Buffer
+	require.NoError(t, cam.SaveState(&buf))
+
+	loaded := NewCamera(bankedROM(64)).(*Camera)
+	require.NoError(t, loaded.LoadState(&buf))
+	assert.True(t, loaded.CameraMode)
+	assert.Equal(t, byte(0x55), loaded.Registers[0x06])
+}
@@ -1,8 +1,10 @@
 package cart
 
 import (
-	"bytes"
+	"crypto/sha1"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -42,15 +44,95 @@ type BankingController interface {
 	// choose to store this data in their own format.
 	GetSaveData() []byte
 
+	// RAM returns the cartridge RAM without copying it, so it can be read
+	// cheaply, for example to hash the state of the emulator. The returned
+	// slice must not be modified.
+	RAM() []byte
+
 	// LoadSaveData loads some save data into the cartridge. The banking
 	// controller implementation can decide how this data should be loaded.
-	LoadSaveData(data []byte)
+	// An error is returned if the data does not fit the cartridge, for
+	// example when it is a save for a different game.
+	LoadSaveData(data []byte) error
 
 	// SaveState saves the state of the banking controller.
 	SaveState(io.Writer) error
 
 	// LoadState loads the state of the banking controller.
 	LoadState(io.Reader) error
+
+	// GetRTC returns the current value of the cartridge real time clock. The
+	// second return value will be false if the controller does not have a
+	// real time clock.
+	GetRTC() (RTC, bool)
+
+	// SetRTC sets the value of the cartridge real time clock. This is a noop
+	// if the controller does not have a real time clock.
+	SetRTC(RTC)
+
+	// IsDirty returns true if the cartridge RAM has been written to since
+	// the dirty flag was last cleared.
+	IsDirty() bool
+
+	// ClearDirty clears the dirty flag of the cartridge RAM. This should be
+	// called when the RAM has been saved.
+	ClearDirty()
+
+	// SetRAMDisableCallback sets a function which is called when the game
+	// disables the cartridge RAM after writing to it. Games disable the RAM
+	// once they have finished writing their save data, so this is when the
+	// save data should be saved.
+	SetRAMDisableCallback(func())
+
+	// BankState returns the current banking state of the controller, such as
+	// which banks are selected.
+	BankState() BankState
+}
+
+// BankState is the banking state of a memory controller. This can be compared
+// before saving and after loading a save state to check the controller state
+// was restored. Fields which are not used by a controller are left as zero.
+type BankState struct {
+	// RomBank0 is the ROM bank mapped at 0x0000, which is bank 0 except on
+	// the MBC1 in mode 1.
+	RomBank0 uint32
+	// RomBank is the selected ROM bank, which is mapped at 0x4000.
+	RomBank uint32
+	// RamBank is the RAM bank mapped at 0xA000. On the MBC3 this may select
+	// one of the real time clock registers instead.
+	RamBank uint32
+	// RamEnabled is true if the RAM can be read and written.
+	RamEnabled bool
+
+	// BankingMode is the banking mode of the MBC1, which is 1 when the RAM
+	// bank register selects the RAM bank instead of the upper ROM bank bits.
+	BankingMode byte
+	// RTCLatched is true when the MBC3 real time clock is latched.
+	RTCLatched bool
+	// CameraMode is true when the Gameboy Camera registers are mapped over
+	// the RAM.
+	CameraMode bool
+}
+
+// RTC is the value of the real time clock registers on a cartridge.
+type RTC struct {
+	Seconds byte
+	Minutes byte
+	Hours   byte
+	// Days is the 9 bit day counter.
+	Days uint16
+	// Halted is true if the clock has been stopped.
+	Halted bool
+	// DayCarry is set when the day counter overflows.
+	DayCarry bool
+}
+
+// ErrSaveSizeMismatch is returned when loading save data which is not the
+// same size as the cartridge RAM.
+var ErrSaveSizeMismatch = errors.New("save data size does not match cartridge RAM")
+
+func saveSizeError(expected, actual int) error {
+	return fmt.Errorf("%w: expected %d bytes but got %d", ErrSaveSizeMismatch, expected, actual)
 }
 
 type BaseMBC struct {
@@ -60,6 +142,13 @@ type BaseMBC struct {
 
 	Ram        []byte
 	RamEnabled bool
+
+	// Set when the RAM is written to, so that it is only saved if it has
+	// changed.
+	dirty bool
+
+	// Called when the RAM is disabled while it is dirty
+	onRAMDisable func()
 }
 
 // SaveState saves the state of the banking controller.
@@ -85,6 +174,66 @@ func (r *BaseMBC) SaveState(writer io.Writer) error {
 	return err
 }
 
+// RAM returns the RAM of the controller without copying it.
+func (r *BaseMBC) RAM() []byte {
+	return r.Ram
+}
+
+// Load save data into the RAM of the controller. The data must be the same
+// size as the RAM.
+func (r *BaseMBC) loadRAM(data []byte) error {
+	if len(data) != len(r.Ram) {
+		return saveSizeError(len(r.Ram), len(data))
+	}
+	copy(r.Ram, data)
+	return nil
+}
+
+// GetRTC returns the value of the real time clock. The base controller
+// does not have a clock, so this will return false.
+func (r *BaseMBC) GetRTC() (RTC, bool) {
+	return RTC{}, false
+}
+
+// SetRTC sets the value of the real time clock. The base controller does
+// not have a clock, so this is a noop.
+func (r *BaseMBC) SetRTC(RTC) {}
+
+// IsDirty returns true if the RAM has been written to since the dirty flag
+// was last cleared.
+func (r *BaseMBC) IsDirty() bool {
+	return r.dirty
+}
+
+// ClearDirty clears the dirty flag of the RAM.
+func (r *BaseMBC) ClearDirty() {
+	r.dirty = false
+}
+
+// BankState returns the selected ROM bank and if the RAM is enabled.
+func (r *BaseMBC) BankState() BankState {
+	return BankState{
+		RomBank:    r.RomBank,
+		RamEnabled: r.RamEnabled,
+	}
+}
+
+// SetRAMDisableCallback sets a function which is called when the RAM is
+// disabled after it has been written to.
+func (r *BaseMBC) SetRAMDisableCallback(callback func()) {
+	r.onRAMDisable = callback
+}
+
+// Set if the RAM is enabled. If the RAM is being disabled and has been written
+// to since the dirty flag was last cleared, the RAM disable callback is called.
+func (r *BaseMBC) setRAMEnabled(enabled bool) {
+	disabling := r.RamEnabled && !enabled
+	r.RamEnabled = enabled
+	if disabling && r.dirty && r.onRAMDisable != nil {
+		r.onRAMDisable()
+	}
+}
+
 // LoadState loads the state of the banking controller.
 func (r *BaseMBC) LoadState(reader io.Reader) error {
 	// Read rombank
@@ -102,7 +251,7 @@ func (r *BaseMBC) LoadState(reader io.Reader) error {
 	r.RamEnabled = en == 1
 
 	// Read ram
-	_, err := reader.Read(r.Ram)
+	_, err := io.ReadFull(reader, r.Ram)
 	return err
 }
 
@@ -117,7 +266,16 @@ type Cart struct {
 	title    string
 	filename string
 	mode     Mode
-	saver    io.ReadWriter
+	cartType byte
+
+	// ROM the cartridge was loaded from, used to create the controller again
+	// when the power is cycled, and its SHA-1 hash.
+	rom     []byte
+	romHash [sha1.Size]byte
+
+	// Locations the battery backed save data is loaded from and saved to
+	loader io.Reader
+	saver  io.Writer
 }
 
 // GetName returns the name of the cartridge. This is retrieved from the memory location
@@ -137,6 +295,13 @@ func (c *Cart) GetName() string {
 	return c.title
 }
 
+// ROMHash returns the SHA-1 hash of the ROM the cartridge was loaded from,
+// before it was padded. This is the same hash used by ROM databases such as
+// No-Intro, so can be used to identify the game.
+func (c *Cart) ROMHash() [sha1.Size]byte {
+	return c.romHash
+}
+
 // GetSaveFilename returns the name of the file that the game should be saved to. This is
 // used for saving and loading save data to the cartridge.
 // TODO: do something better here
@@ -144,40 +309,140 @@ func (c *Cart) GetSaveFilename() string {
 	return c.filename + ".sav"
 }
 
+// GetType returns the cartridge type from the header, which determines the
+// banking controller of the cartridge.
+func (c *Cart) GetType() byte {
+	return c.cartType
+}
+
 // GetMode returns the modes that this cart can run in.
 func (c *Cart) GetMode() Mode {
 	return c.mode
 }
 
+// SetSRAMStorage sets the locations that the battery backed save data is loaded
+// from and saved to, which may be nil. If the cartridge has a battery then the
+// save data is loaded from the loader immediately.
+func (c *Cart) SetSRAMStorage(loader io.Reader, saver io.Writer) {
+	c.loader = loader
+	c.saver = saver
+	if c.HasBattery() {
+		c.initGameSaves()
+	}
+}
+
+// HasBattery returns if the cartridge type has a battery to keep the RAM between
+// sessions, which is how games save. Only these cartridges load and save the
+// save data.
+func (c *Cart) HasBattery() bool {
+	switch c.cartType {
+	case 0x3, 0x6, 0x9, 0xD, 0xF, 0x10, 0x13, 0x17, 0x1B, 0x1E, 0xFC, 0xFD, 0xFF:
+		return true
+	}
+	return false
+}
+
 // Attempt to load a save game from the expected location.
 func (c *Cart) initGameSaves() {
-	if c.saver == nil {
+	if c.loader == nil {
 		return
 	}
 
-	saveData, err := io.ReadAll(c.saver)
-	if err == nil {
-		c.LoadSaveData(saveData)
+	saveData, err := io.ReadAll(c.loader)
+	if err != nil || len(saveData) == 0 {
+		return
+	}
+	if err := c.ImportSRAM(saveData, true); err != nil {
+		log.Printf("failed to load save data: %v", err)
 	}
 }
 
-// Save dumps the carts RAM to the save location.
+// Save dumps the carts RAM to the save location. This clears the dirty flag
+// of the cartridge RAM. If the save location is an io.WriterAt, such as a
+// file, the save data replaces its contents. Otherwise the save data is
+// written to it with a single Write.
 func (c *Cart) Save() {
 	if c.saver == nil {
 		return
 	}
 
-	data := c.BankingController.GetSaveData()
-	io.Copy(c.saver, bytes.NewReader(data))
+	if err := writeSave(c.saver, c.ExportSRAM()); err != nil {
+		log.Printf("failed to save data: %v", err)
+	}
 }
 
-// NewCartFromFile loads a cartridge ROM from a file.
+// Write save data to the start of the save location, so that each save
+// replaces the last one instead of being appended to it.
+func writeSave(saver io.Writer, data []byte) error {
+	writerAt, ok := saver.(io.WriterAt)
+	if !ok {
+		_, err := saver.Write(data)
+		return err
+	}
+	if _, err := writerAt.WriteAt(data, 0); err != nil {
+		return err
+	}
+	// Remove the end of any longer save data which was there before
+	if truncater, ok := saver.(interface{ Truncate(int64) error }); ok {
+		return truncater.Truncate(int64(len(data)))
+	}
+	return nil
+}
+
+// PowerCycle returns the cartridge as it would be after the power is turned off
+// and on again. The save data is flushed to the save location, then the banking
+// controller is created again from the ROM, so the selected banks and any RAM
+// without a battery are lost, while the battery backed save data and real time
+// clock are kept. The new cartridge saves to the same location as this one.
+func (c *Cart) PowerCycle() *Cart {
+	c.Save()
+
+	// The save data is copied from this cartridge rather than read back from
+	// the loader. The loader has already been read when the game was loaded,
+	// and may be a different location to the saver, while the data which was
+	// just saved is the same as the data copied here. This also matches the
+	// hardware, where the battery keeps the RAM while the power is off.
+	cartridge := NewCart(c.rom, c.filename, nil)
+	cartridge.saver = c.saver
+	if c.HasBattery() {
+		if err := cartridge.ImportSRAM(c.ExportSRAM(), false); err != nil {
+			log.Printf("failed to keep save data: %v", err)
+		}
+	}
+	return cartridge
+}
+
+// Size of a single bank of cartridge ROM.
+const romBankSize = 0x4000
+
+// Pad a ROM with 0xFF up to the next full bank, so that reading from any
+// address in a bank is always within the ROM. The ROM is padded to at least
+// two banks, which is the size of the address space for cartridge ROM.
+func padROM(rom []byte) []byte {
+	size := (len(rom) + romBankSize - 1) / romBankSize * romBankSize
+	if size < 2*romBankSize {
+		size = 2 * romBankSize
+	}
+	if size == len(rom) {
+		return rom
+	}
+	log.Printf("Padding ROM from %#x to %#x bytes", len(rom), size)
+	padded := make([]byte, size)
+	copy(padded, rom)
+	for i := len(rom); i < size; i++ {
+		padded[i] = 0xFF
+	}
+	return padded
+}
+
+// NewCartFromFile loads a cartridge ROM from a file. The ROM is checked with
+// CheckROM before it is loaded.
 func NewCartFromFile(filename string, saver io.ReadWriter) (*Cart, error) {
 	rom, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	return NewCart(rom, filename, saver), nil
+	return LoadCart(rom, filename, saver)
 }
 
 // NewCart loads a cartridge ROM from a byte array and returns a new cartridge with
@@ -187,7 +452,9 @@ func NewCartFromFile(filename string, saver io.ReadWriter) (*Cart, error) {
 //
 // The function will use the following list to determine which MBC to use. Not
 // all of the controllers are supported, and the function will only start the
-// save loop for controllers which support RAM+BATTERY.
+// save loop for controllers which support RAM+BATTERY. Unsupported controllers
+// fall back to MBC1; use LoadCart to return an error for them instead. The
+// supported types are listed by SupportedTypes.
 //
 //	0x00  ROM ONLY
 //	0x01  MBC1
@@ -221,7 +488,10 @@ func NewCartFromFile(filename string, saver io.ReadWriter) (*Cart, error) {
 func NewCart(rom []byte, filename string, saver io.ReadWriter) *Cart {
 	cartridge := Cart{
 		filename: filename,
+		rom:      rom,
+		romHash:  sha1.Sum(rom),
 	}
+	rom = padROM(rom)
 
 	// Check for GB mode
 	switch rom[0x0143] {
@@ -235,11 +505,18 @@ func NewCart(rom []byte, filename string, saver io.ReadWriter) *Cart {
 
 	// Determine cartridge type
 	mbcFlag := rom[0x147]
+	cartridge.cartType = mbcFlag
 	cartType := "Unknown"
 	switch mbcFlag {
 	case 0x00, 0x08, 0x09, 0x0B, 0x0C, 0x0D:
 		cartType = "ROM"
 		cartridge.BankingController = NewROM(rom)
+	case 0xFC:
+		cartType = "POCKET CAMERA"
+		cartridge.BankingController = NewCamera(rom)
+	case 0xFD:
+		cartType = "TAMA5"
+		cartridge.BankingController = NewTAMA5(rom)
 	default:
 		switch {
 		case mbcFlag <= 0x03:
@@ -265,9 +542,6 @@ func NewCart(rom []byte, filename string, saver io.ReadWriter) *Cart {
 	}
 	log.Printf("Cart type: %#02x (%v)", mbcFlag, cartType)
 
-	switch mbcFlag {
-	case 0x3, 0x6, 0x9, 0xD, 0xF, 0x10, 0x13, 0x17, 0x1B, 0x1E, 0xFF:
-		cartridge.initGameSaves()
-	}
+	cartridge.SetSRAMStorage(saver, saver)
 	return &cartridge
 }
@@ -2,9 +2,13 @@ package cart
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func appendBytes(by ...[]byte) (out []byte) {
@@ -26,6 +30,27 @@ func TestCart_GetName(t *testing.T) {
 	assert.Equal(t, "CartridgeName!", rom.GetName())
 }
 
+func TestCart_HasBattery(t *testing.T) {
+	for cartType, expected := range map[byte]bool{
+		0x00: false, 0x01: false, 0x03: true, 0x06: true, 0x09: true, 0x10: true,
+		0x12: false, 0x13: true, 0x1A: false, 0x1B: true, 0x1E: true, 0xFC: true,
+	} {
+		c := NewCart(appendBytes(
+			bytes.Repeat([]byte{0}, 0x147),
+			[]byte{cartType},
+		), "test", nil)
+		assert.Equal(t, expected, c.HasBattery(), "incorrect battery for type %#x", cartType)
+	}
+}
+
+func TestCart_ROMHash(t *testing.T) {
+	// The hash is of the rom before it is padded
+	rom := appendBytes(bytes.Repeat([]byte{0}, 0x147), []byte{0x00})
+	c := NewCart(rom, "test", nil)
+	assert.Equal(t, sha1.Sum(rom), c.ROMHash())
+	assert.Equal(t, c.ROMHash(), c.PowerCycle().ROMHash())
+}
+
 func TestCart_GetMode(t *testing.T) {
 	modeRom := func(val byte) []byte {
 		return appendBytes(
@@ -53,3 +78,206 @@ func TestCart_GetMode(t *testing.T) {
 		assert.Equal(t, rom.GetMode(), DMG)
 	})
 }
+
+func TestCart_PadROM(t *testing.T) {
+	tests := []struct {
+		size     int
+		expected int
+	}{
+		{0x150, 0x8000},
+		{0x8000, 0x8000},
+		{0x8001, 0xC000},
+		{0x13000, 0x14000},
+	}
+	for _, test := range tests {
+		rom := padROM(bytes.Repeat([]byte{1}, test.size))
+		assert.Len(t, rom, test.expected)
+		assert.Equal(t, byte(1), rom[test.size-1])
+		if test.size != test.expected {
+			assert.Equal(t, byte(0xFF), rom[test.size])
+			assert.Equal(t, byte(0xFF), rom[test.expected-1])
+		}
+	}
+}
+
+func TestCart_ShortROM(t *testing.T) {
+	romData := appendBytes(
+		bytes.Repeat([]byte{0}, 0x147),
+		[]byte{0x01}, // MBC1
+		bytes.Repeat([]byte{0}, 0x4000),
+	)
+	c := NewCart(romData, "test", nil)
+	// Reading from the end of the first switchable bank should not panic.
+	assert.Equal(t, byte(0xFF), c.Read(0x7FFF))
+}
+
+func TestCart_ReadDisabledRAM(t *testing.T) {
+	controllers := map[string]BankingController{
+		"MBC1": NewMBC1(make([]byte, 0x8000)),
+		"MBC3": NewMBC3(mbc3ROM(0x03)),
+		"MBC5": NewMBC5(make([]byte, 0x8000)),
+	}
+	for name, mbc := range controllers {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, byte(0xFF), mbc.Read(0xA000), "disabled RAM should read 0xFF")
+
+			mbc.WriteROM(0x0000, 0x0A)
+			mbc.WriteRAM(0xA000, 0x12)
+			assert.Equal(t, byte(0x12), mbc.Read(0xA000))
+
+			mbc.WriteROM(0x0000, 0x00)
+			assert.Equal(t, byte(0xFF), mbc.Read(0xA000), "disabled RAM should read 0xFF")
+		})
+	}
+}
+
+func TestCart_RAMDisableCallback(t *testing.T) {
+	controllers := map[string]BankingController{
+		"MBC1":   NewMBC1(make([]byte, 0x8000)),
+		"MBC2":   NewMBC2(make([]byte, 0x8000)),
+		"MBC3":   NewMBC3(mbc3ROM(0x03)),
+		"MBC5":   NewMBC5(make([]byte, 0x8000)),
+		"Camera": NewCamera(make([]byte, 0x8000)),
+	}
+	for name, mbc := range controllers {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			mbc.SetRAMDisableCallback(func() {
+				calls++
+			})
+
+			// Disabling the RAM without writing to it is not a save.
+			mbc.WriteROM(0x0000, 0x0A)
+			mbc.WriteROM(0x0000, 0x00)
+			assert.Equal(t, 0, calls)
+
+			mbc.WriteROM(0x0000, 0x0A)
+			mbc.WriteRAM(0xA000, 0x12)
+			assert.Equal(t, 0, calls, "callback should wait for the RAM to be disabled")
+			mbc.WriteROM(0x0000, 0x00)
+			assert.Equal(t, 1, calls)
+
+			// Disabling the RAM again is not a transition.
+			mbc.WriteROM(0x0000, 0x00)
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
+
+func TestCart_SaveFile(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "test.sav"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	c := NewCart(appendBytes(
+		bytes.Repeat([]byte{0}, 0x147),
+		[]byte{0x03},
+	), "test", nil)
+	c.SetSRAMStorage(nil, file)
+	c.WriteROM(0x0000, 0x0A)
+	for _, value := range []byte{0x12, 0x34} {
+		c.WriteRAM(0xA000, value)
+		c.Save()
+	}
+
+	// Each save should replace the last instead of being appended to it.
+	data, err := os.ReadFile(file.Name())
+	require.NoError(t, err)
+	assert.Equal(t, len(c.GetSaveData()), len(data))
+	assert.Equal(t, byte(0x34), data[0])
+}
+
+func TestCart_SetSRAMStorage(t *testing.T) {
+	battery := NewCart(appendBytes(
+		bytes.Repeat([]byte{0}, 0x147),
+		[]byte{0x03},
+	), "test", nil)
+	battery.SetSRAMStorage(bytes.NewReader(bytes.Repeat([]byte{0x12}, 0x8000)), nil)
+	assert.Equal(t, byte(0x12), battery.GetSaveData()[0])
+
+	saver := &bytes.Buffer{}
+	battery.SetSRAMStorage(nil, saver)
+	battery.Save()
+	assert.Equal(t, 0x8000, saver.Len())
+
+	noBattery := NewCart(appendBytes(
+		bytes.Repeat([]byte{0}, 0x147),
+		[]byte{0x01},
+	), "test", nil)
+	noBattery.SetSRAMStorage(bytes.NewReader(bytes.Repeat([]byte{0x12}, 0x8000)), nil)
+	assert.Equal(t, byte(0x00), noBattery.GetSaveData()[0], "save data should only be loaded with a battery")
+}
+
+func TestCart_PowerCycle(t *testing.T) {
+	newCart := func(cartType byte) *Cart {
+		rom := bankedROM(64)
+		rom[0x147] = cartType
+		rom[0x149] = 0x03
+		return NewCart(rom, "test", nil)
+	}
+
+	saver := &bytes.Buffer{}
+	battery := newCart(0x1B)
+	battery.SetSRAMStorage(nil, saver)
+	battery.WriteROM(0x0000, 0x0A)
+	battery.WriteROM(0x2000, 0x21)
+	battery.WriteRAM(0xA000, 0x42)
+
+	cycled := battery.PowerCycle()
+	assert.Equal(t, len(battery.GetSaveData()), saver.Len(), "save data should be flushed")
+	saver.Reset()
+	assert.Equal(t, BankState{RomBank: 1}, cycled.BankState(), "banks should be reset")
+	assert.Equal(t, byte(0x42), cycled.GetSaveData()[0], "battery backed RAM should be kept")
+	assert.False(t, cycled.IsDirty())
+	assert.Equal(t, battery.GetName(), cycled.GetName())
+	cycled.Save()
+	assert.Equal(t, len(cycled.GetSaveData()), saver.Len(), "should save to the same location")
+
+	noBattery := newCart(0x1A)
+	noBattery.WriteROM(0x0000, 0x0A)
+	noBattery.WriteRAM(0xA000, 0x42)
+	assert.Equal(t, byte(0x00), noBattery.PowerCycle().GetSaveData()[0], "RAM without a battery should be lost")
+}
+
+func TestCart_BankState(t *testing.T) {
+	tests := []struct {
+		name     string
+		mbc      BankingController
+		writes   [][2]uint16
+		expected BankState
+	}{
+		{"ROM", NewROM(bankedROM(2)), [][2]uint16{{0x2000, 0x03}}, BankState{RomBank: 1}},
+		{"MBC1", NewMBC1(bankedROM(64)), [][2]uint16{{0x0000, 0x0A}, {0x2000, 0x05}, {0x4000, 0x02}},
+			BankState{RomBank: 0x45, RamEnabled: true}},
+		{"MBC1Mode1", NewMBC1(bankedROM(64)), [][2]uint16{{0x0000, 0x0A}, {0x2000, 0x05}, {0x4000, 0x02}, {0x6000, 0x01}},
+			BankState{RomBank0: 0x40, RomBank: 0x45, RamBank: 2, RamEnabled: true, BankingMode: 1}},
+		{"MBC2", NewMBC2(bankedROM(16)), [][2]uint16{{0x2100, 0x07}}, BankState{RomBank: 7}},
+		{"MBC3", NewMBC3(mbc3ROM(0x03)), [][2]uint16{{0x0000, 0x0A}, {0x2000, 0x01}, {0x4000, 0x08}, {0x6000, 0x00}},
+			BankState{RomBank: 1, RamBank: 8, RamEnabled: true, RTCLatched: true}},
+		{"MBC5", NewMBC5(bankedROM(64)), [][2]uint16{{0x0000, 0x0A}, {0x2000, 0x21}, {0x4000, 0x0C}},
+			BankState{RomBank: 0x21, RamBank: 0x0C, RamEnabled: true}},
+		{"Camera", NewCamera(bankedROM(64)), [][2]uint16{{0x2000, 0x10}, {0x4000, 0x13}},
+			BankState{RomBank: 0x10, RamBank: 3, CameraMode: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, write := range test.writes {
+				test.mbc.WriteROM(write[0], byte(write[1]))
+			}
+			assert.Equal(t, test.expected, test.mbc.BankState())
+		})
+	}
+}
+
+func TestCart_BankStateSaveState(t *testing.T) {
+	mbc := NewMBC3(mbc3ROM(0x03))
+	mbc.WriteROM(0x0000, 0x0A)
+	mbc.WriteROM(0x2000, 0x01)
+	mbc.WriteROM(0x4000, 0x02)
+
+	var buf bytes.Buffer
+	require.NoError(t, mbc.SaveState(&buf))
+	loaded := NewMBC3(mbc3ROM(0x03))
+	require.NoError(t, loaded.LoadState(&buf))
+	assert.Equal(t, mbc.BankState(), loaded.BankState())
+}
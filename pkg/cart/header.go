@@ -0,0 +1,117 @@
+package cart
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+var (
+	// ErrShortROM is returned when a ROM is too small to contain a cartridge
+	// header.
+	ErrShortROM = errors.New("rom is too small to contain a cartridge header")
+
+	// ErrBadHeader is returned when the header checksum of a ROM does not
+	// match the header, which means the ROM is corrupt or is not a Gameboy
+	// game. The Gameboy boot ROM will not start a game with a bad header.
+	ErrBadHeader = errors.New("rom has an invalid cartridge header")
+
+	// ErrUnsupportedMapper is returned when a ROM uses a cartridge type which
+	// is not supported by the emulator.
+	ErrUnsupportedMapper = errors.New("cartridge type is not supported")
+)
+
+// Size of the ROM up to the end of the cartridge header.
+const headerEnd = 0x150
+
+// CheckROM checks that a ROM has a valid cartridge header which uses a
+// supported cartridge type. The errors returned can be checked for with
+// errors.Is, and will be one of ErrShortROM, ErrBadHeader or
+// ErrUnsupportedMapper.
+func CheckROM(rom []byte) error {
+	if len(rom) < headerEnd {
+		return fmt.Errorf("%w: %d bytes", ErrShortROM, len(rom))
+	}
+	if checksum := headerChecksum(rom); checksum != rom[0x14D] {
+		return fmt.Errorf("%w: checksum is %#02x but expected %#02x", ErrBadHeader, rom[0x14D], checksum)
+	}
+	if !isSupportedType(rom[0x147]) {
+		return fmt.Errorf("%w: %#02x", ErrUnsupportedMapper, rom[0x147])
+	}
+	return nil
+}
+
+// Calculate the checksum of the header in 0x134-0x14C, which is stored
+// in 0x14D.
+func headerChecksum(rom []byte) byte {
+	var checksum byte
+	for _, b := range rom[0x134:0x14D] {
+		checksum = checksum - b - 1
+	}
+	return checksum
+}
+
+// Get the size of the cartridge RAM from the header. ROMs which are too small
+// to contain the RAM size, or have an unknown RAM size, have no RAM.
+func ramSize(rom []byte) int {
+	if len(rom) <= 0x149 {
+		return 0
+	}
+	switch rom[0x149] {
+	case 0x01:
+		return 0x800
+	case 0x02:
+		return 0x2000
+	case 0x03:
+		return 0x8000
+	case 0x04:
+		return 0x20000
+	case 0x05:
+		return 0x10000
+	}
+	return 0
+}
+
+// Check if the cartridge type in the header has a real time clock. Only the
+// MBC3+TIMER types have a clock.
+func hasRTC(rom []byte) bool {
+	if len(rom) <= 0x147 {
+		return false
+	}
+	return rom[0x147] == 0x0F || rom[0x147] == 0x10
+}
+
+// Cartridge types from the header which are supported, in order. These must
+// match the controllers chosen by NewCart.
+var supportedTypes = []byte{
+	0x00, 0x01, 0x02, 0x03, // ROM, MBC1
+	0x05, 0x06, // MBC2
+	0x08, 0x09, // ROM+RAM
+	0x0F, 0x10, 0x11, 0x12, 0x13, // MBC3
+	0x19, 0x1A, 0x1B, 0x1C, 0x1D, 0x1E, // MBC5
+	0xFC, // Pocket Camera
+	0xFD, // TAMA5
+}
+
+// SupportedTypes returns the cartridge types, from byte 0x147 of the header,
+// which are supported by the emulator. The list of types is documented on
+// NewCart. ROMs with any other type are rejected by CheckROM.
+func SupportedTypes() []byte {
+	return slices.Clone(supportedTypes)
+}
+
+// Returns if a cartridge type from the header is supported.
+func isSupportedType(cartType byte) bool {
+	return slices.Contains(supportedTypes, cartType)
+}
+
+// LoadCart checks the header of a ROM with CheckROM and then loads it with
+// NewCart. An error is returned if the ROM is not valid, instead of falling
+// back to a different cartridge type.
+func LoadCart(rom []byte, filename string, saver io.ReadWriter) (*Cart, error) {
+	if err := CheckROM(rom); err != nil {
+		return nil, err
+	}
+	return NewCart(rom, filename, saver), nil
+}
@@ -0,0 +1,66 @@
+package cart
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Create an empty ROM with a valid header for a cartridge type.
+func headerROM(cartType byte) []byte {
+	rom := make([]byte, 0x8000)
+	rom[0x147] = cartType
+	rom[0x14D] = headerChecksum(rom)
+	return rom
+}
+
+func TestCheckROM(t *testing.T) {
+	for _, cartType := range []byte{0x00, 0x01, 0x03, 0x06, 0x09, 0x10, 0x13, 0x1B, 0x1E, 0xFC, 0xFD} {
+		assert.NoError(t, CheckROM(headerROM(cartType)), "type %#02x should be supported", cartType)
+	}
+	for _, cartType := range []byte{0x04, 0x0B, 0x15, 0x20, 0x22, 0xFE, 0xFF} {
+		assert.True(t, errors.Is(CheckROM(headerROM(cartType)), ErrUnsupportedMapper), "type %#02x", cartType)
+	}
+
+	assert.True(t, errors.Is(CheckROM(make([]byte, 0x14F)), ErrShortROM))
+
+	bad := headerROM(0x00)
+	bad[0x134] = 'A'
+	assert.True(t, errors.Is(CheckROM(bad), ErrBadHeader))
+}
+
+func TestSupportedTypes(t *testing.T) {
+	types := SupportedTypes()
+	assert.True(t, slices.IsSorted(types))
+	for cartType := 0; cartType <= 0xFF; cartType++ {
+		err := CheckROM(headerROM(byte(cartType)))
+		if slices.Contains(types, byte(cartType)) {
+			assert.NoError(t, err, "type %#02x should be supported", cartType)
+		} else {
+			assert.True(t, errors.Is(err, ErrUnsupportedMapper), "type %#02x", cartType)
+		}
+	}
+
+	// The list can not be changed by the caller.
+	types[0] = 0xFF
+	assert.Equal(t, byte(0x00), SupportedTypes()[0])
+}
+
+func TestLoadCart(t *testing.T) {
+	c, err := LoadCart(headerROM(0x13), "test", nil)
+	require.NoError(t, err)
+	_, ok := c.BankingController.(*MBC3)
+	assert.True(t, ok)
+
+	_, err = LoadCart(headerROM(0x16), "test", nil)
+	assert.True(t, errors.Is(err, ErrUnsupportedMapper))
+
+	// NewCart falls back to MBC1 for an unsupported type
+	c = NewCart(appendBytes(bytes.Repeat([]byte{0}, 0x147), []byte{0x16}), "test", nil)
+	_, ok = c.BankingController.(*MBC1)
+	assert.True(t, ok)
+}
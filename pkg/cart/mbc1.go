@@ -13,13 +13,17 @@ func NewMBC1(data []byte) BankingController {
 			RomBank: 1,
 			Ram:     make([]byte, 0x8000),
 		},
+		RomBanking: true,
 	}
 }
 
 // MBC1 is a GameBoy cartridge that supports rom and ram banking.
 type MBC1 struct {
 	BaseMBC
-	RamBank    uint32
+	// RamBank is the 2 bit register which selects the RAM bank in mode 1,
+	// and the upper bits of the ROM bank.
+	RamBank uint32
+	// RomBanking is true when the controller is in mode 0.
 	RomBanking bool
 }
 
@@ -27,12 +31,39 @@ type MBC1 struct {
 func (r *MBC1) Read(address uint16) byte {
 	switch {
 	case address < 0x4000:
-		return r.Rom[address] // Bank 0 is fixed
+		return r.Rom[r.romOffset(r.lowerRomBank())+uint32(address)]
 	case address < 0x8000:
-		return r.Rom[uint32(address-0x4000)+(r.RomBank*0x4000)] // Use selected rom bank
+		return r.Rom[r.romOffset(r.RomBank)+uint32(address-0x4000)] // Use selected rom bank
 	default:
-		return r.Ram[(0x2000*r.RamBank)+uint32(address-0xA000)] // Use selected ram bank
+		if !r.RamEnabled {
+			return 0xFF // RAM is disabled
+		}
+		return r.Ram[(0x2000*r.ramBank())+uint32(address-0xA000)] // Use selected ram bank
+	}
+}
+
+// Get the ROM bank mapped to 0x0000-0x3FFF. This is bank 0 in mode 0, but in
+// mode 1 the upper bank bits also apply to this region, which remaps it to
+// bank 0x20, 0x40 or 0x60 on carts with at least 1MB of ROM.
+func (r *MBC1) lowerRomBank() uint32 {
+	if r.RomBanking {
+		return 0
 	}
+	return r.RamBank << 5
+}
+
+// Get the selected RAM bank. The RAM bank can only be switched in mode 1.
+func (r *MBC1) ramBank() uint32 {
+	if r.RomBanking {
+		return 0
+	}
+	return r.RamBank
+}
+
+// Get the offset of a ROM bank. The bank number wraps around the number of
+// banks in the ROM, as the cart only uses as many bank bits as it needs.
+func (r *MBC1) romOffset(bank uint32) uint32 {
+	return bank % uint32(len(r.Rom)/0x4000) * 0x4000
 }
 
 // WriteROM attempts to switch the ROM or RAM bank.
@@ -41,44 +72,47 @@ func (r *MBC1) WriteROM(address uint16, value byte) {
 	case address < 0x2000:
 		// RAM enable
 		if value&0xF == 0xA {
-			r.RamEnabled = true
+			r.setRAMEnabled(true)
 		} else if value&0xF == 0x0 {
-			r.RamEnabled = false
+			r.setRAMEnabled(false)
 		}
 	case address < 0x4000:
-		// ROM bank number (lower 5)
-		r.RomBank = (r.RomBank & 0xe0) | uint32(value&0x1f)
-		r.updateRomBankIfZero()
-	case address < 0x6000:
-		// ROM/RAM banking
-		if r.RomBanking {
-			r.RomBank = (r.RomBank & 0x1F) | uint32(value&0xe0)
-			r.updateRomBankIfZero()
-		} else {
-			r.RamBank = uint32(value & 0x3)
+		// ROM bank number (lower 5), where bank 0 is treated as bank 1
+		bank := uint32(value & 0x1F)
+		if bank == 0 {
+			bank = 1
 		}
+		r.RomBank = (r.RomBank & 0x60) | bank
+	case address < 0x6000:
+		// RAM bank number or ROM bank number (upper 2)
+		r.RamBank = uint32(value & 0x3)
+		r.RomBank = (r.RomBank & 0x1F) | r.RamBank<<5
 	case address < 0x8000:
 		// ROM/RAM select mode
 		r.RomBanking = value&0x1 == 0x00
-		if r.RomBanking {
-			r.RamBank = 0
-		} else {
-			r.RomBank = r.RomBank & 0x1F
-		}
-	}
-}
-
-// Update the romBank if it is on a value which cannot be used.
-func (r *MBC1) updateRomBankIfZero() {
-	if r.RomBank == 0x00 || r.RomBank == 0x20 || r.RomBank == 0x40 || r.RomBank == 0x60 {
-		r.RomBank++
 	}
 }
 
 // WriteRAM writes data to the ram if it is enabled.
 func (r *MBC1) WriteRAM(address uint16, value byte) {
 	if r.RamEnabled {
-		r.Ram[(0x2000*r.RamBank)+uint32(address-0xA000)] = value
+		r.Ram[(0x2000*r.ramBank())+uint32(address-0xA000)] = value
+		r.dirty = true
+	}
+}
+
+// BankState returns the mapped banks and the banking mode.
+func (r *MBC1) BankState() BankState {
+	var mode byte
+	if !r.RomBanking {
+		mode = 1
+	}
+	return BankState{
+		RomBank0:    r.lowerRomBank(),
+		RomBank:     r.RomBank,
+		RamBank:     r.ramBank(),
+		RamEnabled:  r.RamEnabled,
+		BankingMode: mode,
 	}
 }
 
@@ -89,9 +123,10 @@ func (r *MBC1) GetSaveData() []byte {
 	return data
 }
 
-// LoadSaveData loads the save data into the cartridge.
-func (r *MBC1) LoadSaveData(data []byte) {
-	r.Ram = data
+// LoadSaveData loads the save data into the cartridge. An error is returned
+// if the data is not the same size as the cartridge RAM.
+func (r *MBC1) LoadSaveData(data []byte) error {
+	return r.loadRAM(data)
 }
 
 // SaveState saves the state of the banking controller.
@@ -0,0 +1,71 @@
+package cart
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Create a ROM where the first byte of each bank is the bank number.
+func bankedROM(banks int) []byte {
+	rom := make([]byte, banks*0x4000)
+	for i := 0; i < banks; i++ {
+		rom[i*0x4000] = byte(i)
+	}
+	return rom
+}
+
+func TestMBC1_RomBank(t *testing.T) {
+	mbc := NewMBC1(bankedROM(128))
+	assert.Equal(t, byte(0x00), mbc.Read(0x0000))
+	assert.Equal(t, byte(0x01), mbc.Read(0x4000))
+
+	mbc.WriteROM(0x2000, 0x00)
+	assert.Equal(t, byte(0x01), mbc.Read(0x4000), "bank 0 should select bank 1")
+
+	mbc.WriteROM(0x2000, 0x05)
+	mbc.WriteROM(0x4000, 0x03)
+	assert.Equal(t, byte(0x65), mbc.Read(0x4000))
+	assert.Equal(t, byte(0x00), mbc.Read(0x0000), "bank 0 should be fixed in mode 0")
+
+	mbc.WriteROM(0x2000, 0x00)
+	assert.Equal(t, byte(0x61), mbc.Read(0x4000))
+}
+
+func TestMBC1_Mode1(t *testing.T) {
+	mbc := NewMBC1(bankedROM(128))
+	mbc.WriteROM(0x6000, 0x01)
+
+	for upper, expected := range []byte{0x00, 0x20, 0x40, 0x60} {
+		mbc.WriteROM(0x4000, byte(upper))
+		assert.Equal(t, expected, mbc.Read(0x0000), "unexpected bank 0 for upper bits %v", upper)
+		assert.Equal(t, expected+1, mbc.Read(0x4000))
+	}
+
+	mbc.WriteROM(0x6000, 0x00)
+	assert.Equal(t, byte(0x00), mbc.Read(0x0000))
+}
+
+func TestMBC1_Mode1SmallROM(t *testing.T) {
+	mbc := NewMBC1(bankedROM(32))
+	mbc.WriteROM(0x6000, 0x01)
+	mbc.WriteROM(0x4000, 0x01)
+	assert.Equal(t, byte(0x00), mbc.Read(0x0000), "bank 0 should not be remapped on a 512KB ROM")
+	assert.Equal(t, byte(0x01), mbc.Read(0x4000))
+}
+
+func TestMBC1_RamBank(t *testing.T) {
+	mbc := NewMBC1(bankedROM(32))
+	mbc.WriteROM(0x0000, 0x0A)
+	mbc.WriteRAM(0xA000, 0x10)
+
+	// The RAM bank can only be switched in mode 1
+	mbc.WriteROM(0x4000, 0x02)
+	assert.Equal(t, byte(0x10), mbc.Read(0xA000))
+
+	mbc.WriteROM(0x6000, 0x01)
+	mbc.WriteRAM(0xA000, 0x12)
+	assert.Equal(t, byte(0x12), mbc.Read(0xA000))
+	assert.Equal(t, byte(0x12), mbc.GetSaveData()[0x4000])
+	assert.Equal(t, byte(0x10), mbc.GetSaveData()[0x0000])
+}
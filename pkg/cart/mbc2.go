@@ -35,9 +35,9 @@ func (r *MBC2) WriteROM(address uint16, value byte) {
 		// RAM enable
 		if address&0x100 == 0 {
 			if value&0xF == 0xA {
-				r.RamEnabled = true
+				r.setRAMEnabled(true)
 			} else if value&0xF == 0x0 {
-				r.RamEnabled = false
+				r.setRAMEnabled(false)
 			}
 		}
 		return
@@ -57,6 +57,7 @@ func (r *MBC2) WriteROM(address uint16, value byte) {
 func (r *MBC2) WriteRAM(address uint16, value byte) {
 	if r.RamEnabled {
 		r.Ram[address-0xA000] = value & 0xF
+		r.dirty = true
 	}
 }
 
@@ -67,7 +68,8 @@ func (r *MBC2) GetSaveData() []byte {
 	return data
 }
 
-// LoadSaveData loads the save data into the cartridge.
-func (r *MBC2) LoadSaveData(data []byte) {
-	r.Ram = data
+// LoadSaveData loads the save data into the cartridge. An error is returned
+// if the data is not the same size as the cartridge RAM.
+func (r *MBC2) LoadSaveData(data []byte) error {
+	return r.loadRAM(data)
 }
@@ -5,16 +5,18 @@ import (
 	"io"
 )
 
-// NewMBC3 returns a new MBC3 memory controller.
+// NewMBC3 returns a new MBC3 memory controller. The size of the RAM and if
+// the cartridge has a real time clock are read from the cartridge header.
 func NewMBC3(data []byte) BankingController {
 	return &MBC3{
 		BaseMBC: BaseMBC{
 			Rom:     data,
 			RomBank: 1,
-			Ram:     make([]byte, 0x8000),
+			Ram:     make([]byte, ramSize(data)),
 		},
 		Rtc:        make([]byte, 0x10),
 		LatchedRtc: make([]byte, 0x10),
+		hasRTC:     hasRTC(data),
 	}
 }
 
@@ -27,6 +29,8 @@ type MBC3 struct {
 	Rtc        []byte
 	LatchedRtc []byte
 	Latched    bool
+
+	hasRTC bool
 }
 
 // Read returns a value at a memory address in the ROM.
@@ -37,14 +41,31 @@ func (r *MBC3) Read(address uint16) byte {
 	case address < 0x8000:
 		return r.Rom[uint32(address-0x4000)+(r.RomBank*0x4000)] // Use selected rom bank
 	default:
+		if !r.RamEnabled {
+			return 0xFF // RAM and RTC are disabled
+		}
 		if r.RamBank >= 0x4 {
 			if r.Latched {
 				return r.LatchedRtc[r.RamBank]
 			}
 			return r.Rtc[r.RamBank]
 		}
-		return r.Ram[(0x2000*r.RamBank)+uint32(address-0xA000)] // Use selected ram bank
+		offset, ok := r.ramOffset(address)
+		if !ok {
+			return 0xFF // No RAM on the cartridge
+		}
+		return r.Ram[offset] // Use selected ram bank
+	}
+}
+
+// Get the offset in the RAM of an address in the selected RAM bank. Banks past
+// the end of the RAM wrap around, as the upper bank bits are not connected.
+// Returns false if the cartridge has no RAM.
+func (r *MBC3) ramOffset(address uint16) (uint32, bool) {
+	if len(r.Ram) == 0 {
+		return 0, false
 	}
+	return ((0x2000 * r.RamBank) + uint32(address-0xA000)) % uint32(len(r.Ram)), true
 }
 
 // WriteROM attempts to switch the ROM or RAM bank.
@@ -52,7 +73,7 @@ func (r *MBC3) WriteROM(address uint16, value byte) {
 	switch {
 	case address < 0x2000:
 		// RAM enable
-		r.RamEnabled = (value & 0xA) != 0
+		r.setRAMEnabled((value & 0xA) != 0)
 	case address < 0x4000:
 		// ROM bank number (lower 5)
 		r.RomBank = uint32(value & 0x7F)
@@ -60,7 +81,8 @@ func (r *MBC3) WriteROM(address uint16, value byte) {
 			r.RomBank++
 		}
 	case address < 0x6000:
-		r.RamBank = uint32(value)
+		// RAM bank 0x0-0x3 or RTC register 0x8-0xC
+		r.RamBank = uint32(value & 0xF)
 	case address < 0x8000:
 		if value == 0x1 {
 			r.Latched = false
@@ -76,9 +98,22 @@ func (r *MBC3) WriteRAM(address uint16, value byte) {
 	if r.RamEnabled {
 		if r.RamBank >= 0x4 {
 			r.Rtc[r.RamBank] = value
+		} else if offset, ok := r.ramOffset(address); ok {
+			r.Ram[offset] = value
 		} else {
-			r.Ram[(0x2000*r.RamBank)+uint32(address-0xA000)] = value
+			return
 		}
+		r.dirty = true
+	}
+}
+
+// BankState returns the selected banks and if the real time clock is latched.
+func (r *MBC3) BankState() BankState {
+	return BankState{
+		RomBank:    r.RomBank,
+		RamBank:    r.RamBank,
+		RamEnabled: r.RamEnabled,
+		RTCLatched: r.Latched,
 	}
 }
 
@@ -89,9 +124,36 @@ func (r *MBC3) GetSaveData() []byte {
 	return data
 }
 
-// LoadSaveData loads the save data into the cartridge.
-func (r *MBC3) LoadSaveData(data []byte) {
-	r.Ram = data
+// LoadSaveData loads the save data into the cartridge. An error is returned
+// if the data is not the same size as the cartridge RAM.
+func (r *MBC3) LoadSaveData(data []byte) error {
+	return r.loadRAM(data)
+}
+
+// GetRTC returns the current value of the real time clock registers. If
+// the cartridge does not have a clock this will return false.
+func (r *MBC3) GetRTC() (RTC, bool) {
+	if !r.hasRTC {
+		return RTC{}, false
+	}
+	return RTC{
+		Seconds:  r.Rtc[0x08],
+		Minutes:  r.Rtc[0x09],
+		Hours:    r.Rtc[0x0A],
+		Days:     uint16(r.Rtc[0x0B]) | uint16(r.Rtc[0x0C]&0x1)<<8,
+		Halted:   r.Rtc[0x0C]&0x40 != 0,
+		DayCarry: r.Rtc[0x0C]&0x80 != 0,
+	}, true
+}
+
+// SetRTC sets the value of the real time clock registers. If the cartridge
+// does not have a clock this is a noop.
+func (r *MBC3) SetRTC(rtc RTC) {
+	if !r.hasRTC {
+		return
+	}
+	registers := rtcRegisters(rtc)
+	copy(r.Rtc[0x08:0x0D], registers[:])
 }
 
 // SaveState saves the state of the banking controller.
@@ -143,13 +205,13 @@ func (r *MBC3) LoadState(reader io.Reader) error {
 	r.RamBank = uint32(tmp)
 
 	// Read rtc
-	_, err := reader.Read(r.Rtc)
+	_, err := io.ReadFull(reader, r.Rtc)
 	if err != nil {
 		return err
 	}
 
 	// Read latched rtc
-	_, err = reader.Read(r.LatchedRtc)
+	_, err = io.ReadFull(reader, r.LatchedRtc)
 	if err != nil {
 		return err
 	}
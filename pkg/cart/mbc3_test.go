@@ -0,0 +1,91 @@
+package cart
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMBC3_RTC(t *testing.T) {
+	mbc := NewMBC3(mbc3ROM(0x03))
+	expected := RTC{
+		Seconds:  12,
+		Minutes:  34,
+		Hours:    5,
+		Days:     300,
+		Halted:   true,
+		DayCarry: true,
+	}
+	mbc.SetRTC(expected)
+
+	rtc, ok := mbc.GetRTC()
+	assert.True(t, ok)
+	assert.Equal(t, expected, rtc)
+
+	// The values should be readable through the RTC register banks.
+	mbc.WriteROM(0x0000, 0x0A)
+	for bank, value := range map[byte]byte{0x08: 12, 0x09: 34, 0x0A: 5, 0x0B: 44, 0x0C: 0xC1} {
+		mbc.WriteROM(0x4000, bank)
+		assert.Equal(t, value, mbc.Read(0xA000), "unexpected value in RTC register %#x", bank)
+	}
+}
+
+// Create an MBC3+TIMER ROM with a RAM size in the header.
+func mbc3ROM(ramSize byte) []byte {
+	rom := make([]byte, 0x8000)
+	rom[0x147] = 0x10
+	rom[0x149] = ramSize
+	return rom
+}
+
+func TestMBC3_RAMSize(t *testing.T) {
+	for ramSize, expected := range map[byte]int{0x00: 0, 0x01: 0x800, 0x02: 0x2000, 0x03: 0x8000} {
+		mbc := NewMBC3(mbc3ROM(ramSize))
+		assert.Len(t, mbc.GetSaveData(), expected, "incorrect size for RAM size %#x", ramSize)
+	}
+}
+
+func TestMBC3_RAMBanks(t *testing.T) {
+	mbc := NewMBC3(mbc3ROM(0x02))
+	mbc.WriteROM(0x0000, 0x0A)
+	mbc.WriteRAM(0xA010, 0x12)
+
+	// Banks past the end of 8KB of RAM wrap around to the first bank.
+	mbc.WriteROM(0x4000, 0x03)
+	assert.Equal(t, byte(0x12), mbc.Read(0xA010))
+	mbc.WriteRAM(0xA010, 0x34)
+	mbc.WriteROM(0x4000, 0x00)
+	assert.Equal(t, byte(0x34), mbc.Read(0xA010))
+
+	// Out of range bank selects should not index past the registers.
+	mbc.WriteROM(0x4000, 0xFF)
+	assert.NotPanics(t, func() {
+		mbc.Read(0xA000)
+		mbc.WriteRAM(0xA000, 0x56)
+	})
+}
+
+func TestMBC3_NoRAM(t *testing.T) {
+	mbc := NewMBC3(mbc3ROM(0x00))
+	mbc.WriteROM(0x0000, 0x0A)
+	mbc.WriteRAM(0xA000, 0x12)
+	assert.Equal(t, byte(0xFF), mbc.Read(0xA000))
+	assert.False(t, mbc.IsDirty())
+}
+
+func TestMBC3_NoRTC(t *testing.T) {
+	rom := mbc3ROM(0x03)
+	rom[0x147] = 0x13
+	c := &Cart{BankingController: NewMBC3(rom)}
+	c.SetRTC(RTC{Seconds: 10})
+	_, ok := c.GetRTC()
+	assert.False(t, ok)
+	assert.Len(t, c.ExportSRAM(), 0x8000, "save should not have a clock footer")
+}
+
+func TestROM_RTC(t *testing.T) {
+	rom := NewROM([]byte{})
+	rom.SetRTC(RTC{Seconds: 10})
+	_, ok := rom.GetRTC()
+	assert.False(t, ok)
+}
@@ -25,6 +25,9 @@ func (r *MBC5) Read(address uint16) byte {
 	case address < 0x8000:
 		return r.Rom[uint32(address-0x4000)+(r.RomBank*0x4000)] // Use selected rom bank
 	default:
+		if !r.RamEnabled {
+			return 0xFF // RAM is disabled
+		}
 		return r.Ram[(0x2000*r.RamBank)+uint32(address-0xA000)] // Use selected ram bank
 	}
 }
@@ -35,9 +38,9 @@ func (r *MBC5) WriteROM(address uint16, value byte) {
 	case address < 0x2000:
 		// RAM enable
 		if value&0xF == 0xA {
-			r.RamEnabled = true
+			r.setRAMEnabled(true)
 		} else if value&0xF == 0x0 {
-			r.RamEnabled = false
+			r.setRAMEnabled(false)
 		}
 	case address < 0x3000:
 		// ROM bank number
@@ -54,6 +57,16 @@ func (r *MBC5) WriteROM(address uint16, value byte) {
 func (r *MBC5) WriteRAM(address uint16, value byte) {
 	if r.RamEnabled {
 		r.Ram[(0x2000*r.RamBank)+uint32(address-0xA000)] = value
+		r.dirty = true
+	}
+}
+
+// BankState returns the selected banks.
+func (r *MBC5) BankState() BankState {
+	return BankState{
+		RomBank:    r.RomBank,
+		RamBank:    r.RamBank,
+		RamEnabled: r.RamEnabled,
 	}
 }
 
@@ -64,7 +77,8 @@ func (r *MBC5) GetSaveData() []byte {
 	return data
 }
 
-// LoadSaveData loads the save data into the cartridge.
-func (r *MBC5) LoadSaveData(data []byte) {
-	r.Ram = data
+// LoadSaveData loads the save data into the cartridge. An error is returned
+// if the data is not the same size as the cartridge RAM.
+func (r *MBC5) LoadSaveData(data []byte) error {
+	return r.loadRAM(data)
 }
@@ -34,9 +34,20 @@ func (r *ROM) GetSaveData() []byte {
 	return []byte{}
 }
 
+// RAM returns the cartridge RAM. As RAM is not supported on this memory
+// controller, this returns nil.
+func (r *ROM) RAM() []byte {
+	return nil
+}
+
 // LoadSaveData loads the save data into the cartridge. As RAM is not supported
-// on this memory controller, this is a noop.
-func (r *ROM) LoadSaveData([]byte) {}
+// on this memory controller, an error is returned if there is any data.
+func (r *ROM) LoadSaveData(data []byte) error {
+	if len(data) != 0 {
+		return saveSizeError(0, len(data))
+	}
+	return nil
+}
 
 // SaveState saves the state of the banking controller. As RAM is not supported
 // on this memory controller, this is a noop.
@@ -49,3 +60,34 @@ func (r *ROM) SaveState(io.Writer) error {
 func (r *ROM) LoadState(io.Reader) error {
 	return nil
 }
+
+// GetRTC returns the value of the real time clock. As a clock is not supported
+// on this memory controller, this will return false.
+func (r *ROM) GetRTC() (RTC, bool) {
+	return RTC{}, false
+}
+
+// SetRTC sets the value of the real time clock. As a clock is not supported
+// on this memory controller, this is a noop.
+func (r *ROM) SetRTC(RTC) {}
+
+// IsDirty returns if the RAM has been written to. As RAM is not supported on
+// this memory controller, this will always return false.
+func (r *ROM) IsDirty() bool {
+	return false
+}
+
+// ClearDirty clears the dirty flag of the RAM. As RAM is not supported on this
+// memory controller, this is a noop.
+func (r *ROM) ClearDirty() {}
+
+// SetRAMDisableCallback sets a function which is called when the RAM is
+// disabled. As RAM is not supported on this memory controller, it is never
+// called.
+func (r *ROM) SetRAMDisableCallback(func()) {}
+
+// BankState returns the banking state of the controller. As banking is not
+// supported on this memory controller, bank 1 is always mapped.
+func (r *ROM) BankState() BankState {
+	return BankState{RomBank: 1}
+}
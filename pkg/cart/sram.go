@@ -0,0 +1,116 @@
+package cart
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+)
+
+// Length of the real time clock footer appended to the save data. This is
+// the format used by other common emulators, made up of the 5 clock registers
+// and the 5 latched clock registers stored as 32 bit values, followed by a 64
+// bit unix timestamp. Older saves may use a 32 bit timestamp instead.
+const (
+	rtcFooterLength      = 48
+	rtcFooterLengthShort = 44
+)
+
+// ExportSRAM returns the battery backed save data for the cartridge. If the
+// banking controller has a real time clock then its value is appended to the
+// end of the data. This clears the dirty flag of the cartridge RAM.
+func (c *Cart) ExportSRAM() []byte {
+	c.ClearDirty()
+	data := c.GetSaveData()
+	if rtc, ok := c.GetRTC(); ok {
+		data = append(data, encodeRTCFooter(rtc, time.Now().Unix())...)
+	}
+	return data
+}
+
+// ImportSRAM loads battery backed save data into the cartridge. If the banking
+// controller has a real time clock and the data has a clock footer, then the
+// footer is removed from the data and loaded into the clock.
+//
+// If the data is not the same size as the cartridge RAM then ErrSaveSizeMismatch
+// is returned, unless resize is true, in which case the data is truncated or
+// padded with zeros to fit and a warning is logged.
+func (c *Cart) ImportSRAM(data []byte, resize bool) error {
+	// The clock is only set once the RAM has been loaded, so it is not
+	// changed if the data is rejected.
+	var rtc *RTC
+	if _, ok := c.GetRTC(); ok {
+		// Save RAM is usually a multiple of 512 bytes, so any remaining
+		// bytes must be the clock footer. Smaller RAM is checked using the
+		// size of the RAM instead.
+		footer := len(data) % 0x200
+		if extra := len(data) - len(c.GetSaveData()); extra == rtcFooterLength || extra == rtcFooterLengthShort {
+			footer = extra
+		}
+		switch footer {
+		case rtcFooterLength, rtcFooterLengthShort:
+			footerRTC := decodeRTCFooter(data[len(data)-footer:])
+			rtc = &footerRTC
+			data = data[:len(data)-footer]
+		}
+	}
+	if expected := len(c.GetSaveData()); resize && len(data) != expected {
+		log.Printf("warning: resizing save data from %d to %d bytes", len(data), expected)
+		resized := make([]byte, expected)
+		copy(resized, data)
+		data = resized
+	}
+	if err := c.LoadSaveData(data); err != nil {
+		return err
+	}
+	if rtc != nil {
+		c.SetRTC(*rtc)
+	}
+	c.ClearDirty()
+	return nil
+}
+
+// Encode a real time clock value into a save data footer.
+func encodeRTCFooter(rtc RTC, timestamp int64) []byte {
+	registers := rtcRegisters(rtc)
+	footer := make([]byte, rtcFooterLength)
+	for i, reg := range registers {
+		// Write the same value to the clock and latched clock registers.
+		binary.LittleEndian.PutUint32(footer[i*4:], uint32(reg))
+		binary.LittleEndian.PutUint32(footer[(i+len(registers))*4:], uint32(reg))
+	}
+	binary.LittleEndian.PutUint64(footer[40:], uint64(timestamp))
+	return footer
+}
+
+// Decode a real time clock value from a save data footer.
+func decodeRTCFooter(footer []byte) RTC {
+	var registers [5]byte
+	for i := range registers {
+		registers[i] = byte(binary.LittleEndian.Uint32(footer[i*4:]))
+	}
+	return rtcFromRegisters(registers)
+}
+
+// Get a real time clock value from the values of the 5 clock registers.
+func rtcFromRegisters(registers [5]byte) RTC {
+	return RTC{
+		Seconds:  registers[0],
+		Minutes:  registers[1],
+		Hours:    registers[2],
+		Days:     uint16(registers[3]) | uint16(registers[4]&0x1)<<8,
+		Halted:   registers[4]&0x40 != 0,
+		DayCarry: registers[4]&0x80 != 0,
+	}
+}
+
+// Get the values of the 5 clock registers from a real time clock value.
+func rtcRegisters(rtc RTC) [5]byte {
+	dayHigh := byte(rtc.Days>>8) & 0x1
+	if rtc.Halted {
+		dayHigh |= 0x40
+	}
+	if rtc.DayCarry {
+		dayHigh |= 0x80
+	}
+	return [5]byte{rtc.Seconds, rtc.Minutes, rtc.Hours, byte(rtc.Days), dayHigh}
+}
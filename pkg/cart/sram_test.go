@@ -0,0 +1,115 @@
+package cart
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCart_ExportSRAM(t *testing.T) {
+	c := &Cart{BankingController: NewMBC1(make([]byte, 0x8000))}
+	c.WriteROM(0x0000, 0x0A)
+	c.WriteRAM(0xA000, 0x12)
+
+	data := c.ExportSRAM()
+	assert.Len(t, data, 0x8000)
+	assert.Equal(t, byte(0x12), data[0])
+}
+
+func TestCart_ExportSRAM_ROM(t *testing.T) {
+	c := &Cart{BankingController: NewROM([]byte{})}
+	assert.Equal(t, []byte{}, c.ExportSRAM())
+}
+
+func TestCart_SRAMRoundTripRTC(t *testing.T) {
+	expected := RTC{Seconds: 1, Minutes: 2, Hours: 3, Days: 0x1FF, DayCarry: true}
+	c := &Cart{BankingController: NewMBC3(mbc3ROM(0x03))}
+	c.WriteROM(0x0000, 0x0A)
+	c.WriteRAM(0xA000, 0x34)
+	c.SetRTC(expected)
+
+	data := c.ExportSRAM()
+	assert.Len(t, data, 0x8000+rtcFooterLength)
+
+	loaded := &Cart{BankingController: NewMBC3(mbc3ROM(0x03))}
+	assert.NoError(t, loaded.ImportSRAM(data, false))
+	rtc, _ := loaded.GetRTC()
+	assert.Equal(t, expected, rtc)
+	assert.Len(t, loaded.GetSaveData(), 0x8000)
+	assert.Equal(t, byte(0x34), loaded.GetSaveData()[0])
+}
+
+func TestCart_ImportSRAMShortFooter(t *testing.T) {
+	footer := encodeRTCFooter(RTC{Seconds: 59, Hours: 23}, 0)[:rtcFooterLengthShort]
+	c := &Cart{BankingController: NewMBC3(mbc3ROM(0x03))}
+	assert.NoError(t, c.ImportSRAM(append(bytes.Repeat([]byte{1}, 0x8000), footer...), false))
+
+	rtc, _ := c.GetRTC()
+	assert.Equal(t, RTC{Seconds: 59, Hours: 23}, rtc)
+	assert.Len(t, c.GetSaveData(), 0x8000)
+}
+
+func TestCart_ImportSRAMSizeMismatch(t *testing.T) {
+	c := &Cart{BankingController: NewMBC1(make([]byte, 0x8000))}
+	err := c.ImportSRAM(bytes.Repeat([]byte{1}, 0x2000), false)
+	assert.True(t, errors.Is(err, ErrSaveSizeMismatch))
+	assert.Equal(t, byte(0), c.GetSaveData()[0], "RAM should not be modified")
+
+	err = c.ImportSRAM([]byte{}, false)
+	assert.True(t, errors.Is(err, ErrSaveSizeMismatch))
+
+	rom := &Cart{BankingController: NewROM([]byte{})}
+	assert.True(t, errors.Is(rom.ImportSRAM([]byte{1}, false), ErrSaveSizeMismatch))
+	assert.NoError(t, rom.ImportSRAM([]byte{}, false))
+}
+
+func TestCart_ImportSRAMSizeMismatchRTC(t *testing.T) {
+	expected := RTC{Seconds: 1, Minutes: 2, Hours: 3}
+	c := &Cart{BankingController: NewMBC3(mbc3ROM(0x03))}
+	c.SetRTC(expected)
+
+	footer := encodeRTCFooter(RTC{Seconds: 59, Hours: 23}, 0)
+	err := c.ImportSRAM(append(bytes.Repeat([]byte{1}, 0x2000), footer...), false)
+	assert.True(t, errors.Is(err, ErrSaveSizeMismatch))
+
+	rtc, _ := c.GetRTC()
+	assert.Equal(t, expected, rtc, "clock should not be modified")
+}
+
+func TestCart_ImportSRAMResize(t *testing.T) {
+	c := &Cart{BankingController: NewMBC1(make([]byte, 0x8000))}
+	assert.NoError(t, c.ImportSRAM(bytes.Repeat([]byte{1}, 0x2000), true))
+	data := c.GetSaveData()
+	assert.Len(t, data, 0x8000)
+	assert.Equal(t, byte(1), data[0x1FFF])
+	assert.Equal(t, byte(0), data[0x2000])
+
+	assert.NoError(t, c.ImportSRAM(bytes.Repeat([]byte{2}, 0x9000), true))
+	assert.Equal(t, bytes.Repeat([]byte{2}, 0x8000), c.GetSaveData())
+}
+
+func TestCart_SRAMDirty(t *testing.T) {
+	c := &Cart{BankingController: NewMBC1(make([]byte, 0x8000))}
+	assert.False(t, c.IsDirty())
+
+	c.WriteRAM(0xA000, 0x12)
+	assert.False(t, c.IsDirty(), "write to disabled RAM should not set dirty")
+
+	c.WriteROM(0x0000, 0x0A)
+	c.WriteRAM(0xA000, 0x12)
+	assert.True(t, c.IsDirty())
+
+	c.ExportSRAM()
+	assert.False(t, c.IsDirty())
+
+	c.WriteRAM(0xA001, 0x34)
+	assert.True(t, c.IsDirty())
+	assert.NoError(t, c.ImportSRAM(make([]byte, 0x8000), false))
+	assert.False(t, c.IsDirty())
+
+	rom := &Cart{BankingController: NewROM([]byte{})}
+	rom.WriteRAM(0xA000, 0x12)
+	assert.False(t, rom.IsDirty())
+}
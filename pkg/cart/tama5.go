@@ -0,0 +1,226 @@
+package cart
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Indexes of the TAMA5 registers, which are selected by writing to 0xA001.
+const (
+	tama5BankLo  = 0x0
+	tama5BankHi  = 0x1
+	tama5WriteLo = 0x4
+	tama5WriteHi = 0x5
+	tama5AddrHi  = 0x6
+	tama5AddrLo  = 0x7
+	tama5Active  = 0xA
+	tama5ReadLo  = 0xC
+	tama5ReadHi  = 0xD
+)
+
+// Commands which are run when the low address register is written to. The
+// command is stored in the upper 3 bits of the high address register.
+const (
+	tama5RAMWrite = 0x0
+	tama5RAMRead  = 0x1
+	tama5RTCWrite = 0x2
+	tama5RTCRead  = 0x3
+)
+
+// NewTAMA5 returns a new Bandai TAMA5 memory controller.
+func NewTAMA5(data []byte) BankingController {
+	return &TAMA5{
+		BaseMBC: BaseMBC{
+			Rom:     data,
+			RomBank: 1,
+			Ram:     make([]byte, 0x20),
+		},
+	}
+}
+
+// TAMA5 is the memory controller of the Gameboy Tamagotchi cartridge. Instead
+// of being mapped into memory its registers, RAM and real time clock are
+// accessed through a set of 4 bit registers. A register is selected by writing
+// its index to 0xA001, and is then written or read at 0xA000.
+//
+// The RAM and clock are read and written by setting the address and command in
+// the address registers, and the value to write in the write registers. Writing
+// the low address register runs the command. The clock holds the time as BCD
+// digits, but does not advance on its own.
+type TAMA5 struct {
+	BaseMBC
+
+	// Index of the selected register and the values of the registers.
+	Register  byte
+	Registers [0x10]byte
+
+	Rtc RTC
+}
+
+// Read returns a value at a memory address in the ROM or the selected register.
+func (r *TAMA5) Read(address uint16) byte {
+	switch {
+	case address < 0x4000:
+		return r.Rom[address] // Bank 0 is fixed
+	case address < 0x8000:
+		offset := r.RomBank * 0x4000 % uint32(len(r.Rom))
+		return r.Rom[offset+uint32(address-0x4000)] // Use selected rom bank
+	case address&0x1 != 0:
+		return 0xFF
+	}
+
+	switch r.Register {
+	case tama5Active:
+		// The game waits for this to be set before using the cartridge
+		return 0xF1
+	case tama5ReadLo:
+		return r.readValue()&0xF | 0xF0
+	case tama5ReadHi:
+		return r.readValue()>>4 | 0xF0
+	}
+	return 0xF0
+}
+
+// Get the value for the read command in the address registers.
+func (r *TAMA5) readValue() byte {
+	switch r.Registers[tama5AddrHi] >> 1 {
+	case tama5RAMRead:
+		return r.Ram[r.ramAddress()]
+	case tama5RTCRead:
+		digits := r.rtcDigits()
+		if index := r.Registers[tama5AddrLo]; int(index) < len(digits) {
+			return digits[index]
+		}
+	}
+	return 0
+}
+
+// Get the address in RAM from the address registers.
+func (r *TAMA5) ramAddress() byte {
+	return (r.Registers[tama5AddrHi]&0x1)<<4 | r.Registers[tama5AddrLo]
+}
+
+// WriteROM is a noop, as the registers are all accessed through 0xA000-0xA001.
+func (r *TAMA5) WriteROM(uint16, byte) {}
+
+// WriteRAM selects a register if the address is odd, otherwise it writes to the
+// selected register. The write only registers control the ROM bank and run the
+// commands to access the RAM and clock.
+func (r *TAMA5) WriteRAM(address uint16, value byte) {
+	if address&0x1 != 0 {
+		r.Register = value & 0xF
+		return
+	}
+	if r.Register > tama5AddrLo {
+		return // Read only registers
+	}
+	r.Registers[r.Register] = value & 0xF
+
+	switch r.Register {
+	case tama5BankLo, tama5BankHi:
+		r.RomBank = uint32(r.Registers[tama5BankHi]&0x1)<<4 | uint32(r.Registers[tama5BankLo])
+	case tama5AddrLo:
+		value := r.Registers[tama5WriteHi]<<4 | r.Registers[tama5WriteLo]
+		switch r.Registers[tama5AddrHi] >> 1 {
+		case tama5RAMWrite:
+			r.Ram[r.ramAddress()] = value
+			r.dirty = true
+		case tama5RTCWrite:
+			r.setRTCDigit(r.Registers[tama5AddrLo], value&0xF)
+		}
+	}
+}
+
+// Get the BCD digits of the clock, from the ones of the seconds up to the
+// hundreds of the days.
+func (r *TAMA5) rtcDigits() [9]byte {
+	days := r.Rtc.Days
+	return [9]byte{
+		r.Rtc.Seconds % 10, r.Rtc.Seconds / 10,
+		r.Rtc.Minutes % 10, r.Rtc.Minutes / 10,
+		r.Rtc.Hours % 10, r.Rtc.Hours / 10,
+		byte(days % 10), byte(days / 10 % 10), byte(days / 100 % 10),
+	}
+}
+
+// Set a single BCD digit of the clock.
+func (r *TAMA5) setRTCDigit(index, value byte) {
+	digits := r.rtcDigits()
+	if int(index) >= len(digits) {
+		return
+	}
+	digits[index] = value
+	r.Rtc.Seconds = digits[1]*10 + digits[0]
+	r.Rtc.Minutes = digits[3]*10 + digits[2]
+	r.Rtc.Hours = digits[5]*10 + digits[4]
+	r.Rtc.Days = uint16(digits[8])*100 + uint16(digits[7])*10 + uint16(digits[6])
+	r.dirty = true
+}
+
+// GetSaveData returns the save data for this banking controller.
+func (r *TAMA5) GetSaveData() []byte {
+	data := make([]byte, len(r.Ram))
+	copy(data, r.Ram)
+	return data
+}
+
+// LoadSaveData loads the save data into the cartridge. An error is returned
+// if the data is not the same size as the cartridge RAM.
+func (r *TAMA5) LoadSaveData(data []byte) error {
+	return r.loadRAM(data)
+}
+
+// GetRTC returns the current value of the real time clock.
+func (r *TAMA5) GetRTC() (RTC, bool) {
+	return r.Rtc, true
+}
+
+// SetRTC sets the value of the real time clock.
+func (r *TAMA5) SetRTC(rtc RTC) {
+	r.Rtc = rtc
+}
+
+// SaveState saves the state of the banking controller.
+func (r *TAMA5) SaveState(writer io.Writer) error {
+	// Write BaseMBC
+	if err := r.BaseMBC.SaveState(writer); err != nil {
+		return err
+	}
+
+	// Write the selected register and the registers
+	if _, err := writer.Write([]byte{r.Register}); err != nil {
+		return err
+	}
+	if _, err := writer.Write(r.Registers[:]); err != nil {
+		return err
+	}
+
+	// Write rtc
+	registers := rtcRegisters(r.Rtc)
+	_, err := writer.Write(registers[:])
+	return err
+}
+
+// LoadState loads the state of the banking controller.
+func (r *TAMA5) LoadState(reader io.Reader) error {
+	// Read BaseMBC
+	if err := r.BaseMBC.LoadState(reader); err != nil {
+		return err
+	}
+
+	// Read the selected register and the registers
+	if err := binary.Read(reader, binary.LittleEndian, &r.Register); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(reader, r.Registers[:]); err != nil {
+		return err
+	}
+
+	// Read rtc
+	var registers [5]byte
+	if _, err := io.ReadFull(reader, registers[:]); err != nil {
+		return err
+	}
+	r.Rtc = rtcFromRegisters(registers)
+	return nil
+}
@@ -0,0 +1,131 @@
+package cart
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Write a value to a TAMA5 register.
+func writeTAMA5(mbc BankingController, register, value byte) {
+	mbc.WriteRAM(0xA001, register)
+	mbc.WriteRAM(0xA000, value)
+}
+
+// Run a TAMA5 command with an address and value.
+func commandTAMA5(mbc BankingController, command, address, value byte) {
+	writeTAMA5(mbc, tama5WriteLo, value&0xF)
+	writeTAMA5(mbc, tama5WriteHi, value>>4)
+	writeTAMA5(mbc, tama5AddrHi, command<<1|address>>4)
+	writeTAMA5(mbc, tama5AddrLo, address&0xF)
+}
+
+// Read the value of the last read command from the TAMA5 registers.
+func readTAMA5(mbc BankingController) byte {
+	mbc.WriteRAM(0xA001, tama5ReadLo)
+	lo := mbc.Read(0xA000)
+	mbc.WriteRAM(0xA001, tama5ReadHi)
+	hi := mbc.Read(0xA000)
+	return (hi&0xF)<<4 | lo&0xF
+}
+
+func TestTAMA5_NewCart(t *testing.T) {
+	c := NewCart(appendBytes(
+		bytes.Repeat([]byte{0}, 0x147),
+		[]byte{0xFD},
+	), "test", nil)
+	_, ok := c.BankingController.(*TAMA5)
+	assert.True(t, ok, "expected TAMA5 controller but got %T", c.BankingController)
+	assert.True(t, c.HasBattery())
+}
+
+func TestTAMA5_Active(t *testing.T) {
+	mbc := NewTAMA5(bankedROM(32))
+	mbc.WriteRAM(0xA001, tama5Active)
+	assert.Equal(t, byte(0xF1), mbc.Read(0xA000))
+	assert.Equal(t, byte(0xFF), mbc.Read(0xA001))
+}
+
+func TestTAMA5_Banking(t *testing.T) {
+	mbc := NewTAMA5(bankedROM(32))
+	assert.Equal(t, byte(1), mbc.Read(0x4000))
+
+	writeTAMA5(mbc, tama5BankLo, 0x5)
+	writeTAMA5(mbc, tama5BankHi, 0x1)
+	assert.Equal(t, byte(0x15), mbc.Read(0x4000))
+	writeTAMA5(mbc, tama5BankLo, 0x0)
+	writeTAMA5(mbc, tama5BankHi, 0x0)
+	assert.Equal(t, byte(0), mbc.Read(0x4000), "bank 0 should be selectable")
+
+	// Writes to ROM should not change the bank
+	mbc.WriteROM(0x2000, 0x03)
+	assert.Equal(t, byte(0), mbc.Read(0x4000))
+}
+
+func TestTAMA5_RAM(t *testing.T) {
+	mbc := NewTAMA5(bankedROM(32))
+	commandTAMA5(mbc, tama5RAMWrite, 0x13, 0xAB)
+	commandTAMA5(mbc, tama5RAMWrite, 0x02, 0x34)
+	assert.True(t, mbc.IsDirty())
+
+	commandTAMA5(mbc, tama5RAMRead, 0x13, 0)
+	assert.Equal(t, byte(0xAB), readTAMA5(mbc))
+	commandTAMA5(mbc, tama5RAMRead, 0x02, 0)
+	assert.Equal(t, byte(0x34), readTAMA5(mbc))
+
+	data := mbc.GetSaveData()
+	require.Len(t, data, 0x20)
+	assert.Equal(t, byte(0xAB), data[0x13])
+}
+
+func TestTAMA5_RTC(t *testing.T) {
+	mbc := NewTAMA5(bankedROM(32))
+	mbc.SetRTC(RTC{Seconds: 45, Minutes: 12, Hours: 23, Days: 123})
+
+	for index, digit := range []byte{5, 4, 2, 1, 3, 2, 3, 2, 1} {
+		commandTAMA5(mbc, tama5RTCRead, byte(index), 0)
+		assert.Equal(t, digit, readTAMA5(mbc), "unexpected value for digit %v", index)
+	}
+
+	commandTAMA5(mbc, tama5RTCWrite, 1, 3)
+	commandTAMA5(mbc, tama5RTCWrite, 8, 2)
+	rtc, ok := mbc.GetRTC()
+	assert.True(t, ok)
+	assert.Equal(t, RTC{Seconds: 35, Minutes: 12, Hours: 23, Days: 223}, rtc)
+}
+
+func TestTAMA5_SRAM(t *testing.T) {
+	c := &Cart{BankingController: NewTAMA5(bankedROM(32))}
+	commandTAMA5(c, tama5RAMWrite, 0x1F, 0x99)
+	c.SetRTC(RTC{Seconds: 1, Minutes: 2, Hours: 3, Days: 4})
+
+	data := c.ExportSRAM()
+	assert.Len(t, data, 0x20+rtcFooterLength)
+
+	loaded := &Cart{BankingController: NewTAMA5(bankedROM(32))}
+	require.NoError(t, loaded.ImportSRAM(data, false))
+	rtc, _ := loaded.GetRTC()
+	assert.Equal(t, RTC{Seconds: 1, Minutes: 2, Hours: 3, Days: 4}, rtc)
+	assert.Equal(t, byte(0x99), loaded.GetSaveData()[0x1F])
+}
+
+func TestTAMA5_State(t *testing.T) {
+	mbc := NewTAMA5(bankedROM(32))
+	writeTAMA5(mbc, tama5BankLo, 0x3)
+	commandTAMA5(mbc, tama5RAMWrite, 0x05, 0x42)
+	mbc.SetRTC(RTC{Seconds: 10, Hours: 5, Days: 300})
+
+	var buf bytes.Buffer
+	require.NoError(t, mbc.SaveState(&buf))
+
+	loaded := NewTAMA5(bankedROM(32)).(*TAMA5)
+	require.NoError(t, loaded.LoadState(&buf))
+	expected := mbc.(*TAMA5)
+	assert.Equal(t, expected.RomBank, loaded.RomBank)
+	assert.Equal(t, expected.Ram, loaded.Ram)
+	assert.Equal(t, expected.Register, loaded.Register)
+	assert.Equal(t, expected.Registers, loaded.Registers)
+	assert.Equal(t, expected.Rtc, loaded.Rtc)
+}
@@ -52,12 +52,7 @@ func runMooneyeTest(t *testing.T, file string) {
 	require.NoError(t, err, "error in init gb %v", err)
 
 	// Run the CPU until the output has matched the expected
-	// or until 4000 iterations have passed.
-	for i := 0; i < 4000; i++ {
-		gb.Update()
-		if inFinishLoop(gb) {
-			break
-		}
-	}
+	// or until 4000 frames have passed.
+	require.NoError(t, gb.RunUntil(inFinishLoop, 4000*CyclesFrame), "test did not finish")
 	require.True(t, passedTest(gb), "registers do not match expected")
 }
@@ -70,20 +70,44 @@ type CPU struct {
 	Divider int
 }
 
-// Init CPU and its registers to the initial values.
-func (cpu *CPU) Init(cgb bool) {
+// Init CPU and its registers to the values left by the boot rom of a model.
+// On the CGB the values depend on if the game is running in CGB mode.
+func (cpu *CPU) Init(model Model, cgbMode bool) {
+	// The lower 4 bits of the F register are always zero, so mask them
+	// before any values are written.
+	cpu.AF.mask = 0xFFF0
+
 	cpu.PC = 0x100
-	if cgb {
+	switch {
+	case model == CGB && cgbMode:
 		cpu.AF.Set(0x1180)
-	} else {
+		cpu.BC.Set(0x0000)
+		cpu.DE.Set(0xFF56)
+		cpu.HL.Set(0x000D)
+	case model == CGB:
+		// B and HL also depend on the licensee of the game, these are
+		// the values for most games.
+		cpu.AF.Set(0x1180)
+		cpu.BC.Set(0x0000)
+		cpu.DE.Set(0x0008)
+		cpu.HL.Set(0x007C)
+	case model == SGB:
+		cpu.AF.Set(0x0100)
+		cpu.BC.Set(0x0014)
+		cpu.DE.Set(0x0000)
+		cpu.HL.Set(0xC060)
+	case model == MGB:
+		cpu.AF.Set(0xFFB0)
+		cpu.BC.Set(0x0013)
+		cpu.DE.Set(0x00D8)
+		cpu.HL.Set(0x014D)
+	default:
 		cpu.AF.Set(0x01B0)
+		cpu.BC.Set(0x0013)
+		cpu.DE.Set(0x00D8)
+		cpu.HL.Set(0x014D)
 	}
-	cpu.BC.Set(0x0000)
-	cpu.DE.Set(0xFF56)
-	cpu.HL.Set(0x000D)
 	cpu.SP.Set(0xFFFE)
-
-	cpu.AF.mask = 0xFFF0
 }
 
 // Internally set the value of a flag on the flag register.
@@ -134,3 +158,46 @@ func (cpu *CPU) H() bool {
 func (cpu *CPU) C() bool {
 	return cpu.AF.HiLo()>>4&1 == 1
 }
+
+// Registers are the values of the CPU registers.
+type Registers struct {
+	A, F byte
+	B, C byte
+	D, E byte
+	H, L byte
+
+	SP uint16
+	PC uint16
+
+	// IME is the interrupt master enable flag.
+	IME bool
+}
+
+// Registers returns the current values of the CPU registers.
+func (gb *Gameboy) Registers() Registers {
+	cpu := gb.CPU
+	return Registers{
+		A: cpu.AF.Hi(), F: cpu.AF.Lo(),
+		B: cpu.BC.Hi(), C: cpu.BC.Lo(),
+		D: cpu.DE.Hi(), E: cpu.DE.Lo(),
+		H: cpu.HL.Hi(), L: cpu.HL.Lo(),
+		SP:  cpu.SP.HiLo(),
+		PC:  cpu.PC,
+		IME: gb.interruptsOn,
+	}
+}
+
+// SetRegisters sets all of the CPU registers. The lower 4 bits of F are always
+// zero, so are ignored. Along with StepInstruction this can be used to run a
+// single instruction from a known state, such as for CPU test suites.
+func (gb *Gameboy) SetRegisters(regs Registers) {
+	cpu := gb.CPU
+	cpu.AF.Set(uint16(regs.A)<<8 | uint16(regs.F))
+	cpu.BC.Set(uint16(regs.B)<<8 | uint16(regs.C))
+	cpu.DE.Set(uint16(regs.D)<<8 | uint16(regs.E))
+	cpu.HL.Set(uint16(regs.H)<<8 | uint16(regs.L))
+	cpu.SP.Set(regs.SP)
+	cpu.PC = regs.PC
+	gb.interruptsOn = regs.IME
+	gb.interruptsEnabling = false
+}
@@ -0,0 +1,85 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCPU_Flags(t *testing.T) {
+	cpu := &CPU{}
+	cpu.Init(DMG, false)
+	cpu.AF.Set(0x1200)
+
+	flags := []struct {
+		name string
+		set  func(bool)
+		get  func() bool
+		bit  uint16
+	}{
+		{"Z", cpu.SetZ, cpu.Z, 0x80},
+		{"N", cpu.SetN, cpu.N, 0x40},
+		{"H", cpu.SetH, cpu.H, 0x20},
+		{"C", cpu.SetC, cpu.C, 0x10},
+	}
+	for _, flag := range flags {
+		t.Run(flag.name, func(t *testing.T) {
+			flag.set(true)
+			assert.True(t, flag.get())
+			assert.Equal(t, 0x1200|flag.bit, cpu.AF.HiLo())
+
+			flag.set(false)
+			assert.False(t, flag.get())
+			assert.Equal(t, uint16(0x1200), cpu.AF.HiLo())
+		})
+	}
+}
+
+func TestCPU_FlagLowerBitsZero(t *testing.T) {
+	cpu := &CPU{}
+	cpu.Init(DMG, false)
+
+	cpu.AF.Set(0x12FF)
+	assert.Equal(t, uint16(0x12F0), cpu.AF.HiLo())
+
+	cpu.AF.SetLo(0x0F)
+	assert.Equal(t, byte(0x00), cpu.AF.Lo())
+	assert.False(t, cpu.Z() || cpu.N() || cpu.H() || cpu.C())
+
+	cpu.AF.SetHi(0xAB)
+	assert.Equal(t, uint16(0xAB00), cpu.AF.HiLo())
+
+	// Other registers should not be masked.
+	cpu.BC.Set(0x12FF)
+	assert.Equal(t, uint16(0x12FF), cpu.BC.HiLo())
+}
+
+func TestGameboy_SetRegisters(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.Memory.Write(0xFFFF, 0)
+
+	// ADD A,B followed by LD (HL),A
+	gb.Memory.Write(0xC000, 0x80)
+	gb.Memory.Write(0xC001, 0x77)
+	gb.SetRegisters(Registers{
+		A: 0x3A, F: 0x0F, B: 0xC6, C: 0x01, D: 0x02, E: 0x03, H: 0xC1, L: 0x00,
+		SP: 0xDFF0, PC: 0xC000,
+	})
+	assert.Equal(t, byte(0x00), gb.Registers().F, "lower bits of F should be ignored")
+
+	gb.StepInstruction()
+	assert.Equal(t, Registers{
+		A: 0x00, F: 0xB0, B: 0xC6, C: 0x01, D: 0x02, E: 0x03, H: 0xC1, L: 0x00,
+		SP: 0xDFF0, PC: 0xC001,
+	}, gb.Registers())
+
+	gb.Memory.Write(0xC100, 0x12)
+	gb.StepInstruction()
+	assert.Equal(t, uint16(0xC002), gb.Registers().PC)
+	assert.Equal(t, byte(0x00), gb.Memory.Read(0xC100))
+
+	gb.SetRegisters(Registers{IME: true})
+	assert.True(t, gb.Registers().IME)
+}
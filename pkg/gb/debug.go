@@ -12,34 +12,57 @@ import (
 	"github.com/Humpheh/goboy/pkg/debug"
 )
 
+// Logger is the interface used to output the debug logging of the Gameboy. This
+// is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Logger which prints to stdout, used when no logger is set with WithLogger.
+type stdoutLogger struct{}
+
+func (stdoutLogger) Printf(format string, v ...interface{}) {
+	fmt.Printf(format, v...)
+}
+
+// Output debug logging to the logger set with the WithLogger option.
+func (gb *Gameboy) logf(format string, v ...interface{}) {
+	if gb.options.logger == nil {
+		stdoutLogger{}.Printf(format, v...)
+		return
+	}
+	gb.options.logger.Printf(format, v...)
+}
+
 // LogOpcode is a debug function to log the the current state of the gameboys CPU and next memory.
 func LogOpcode(gb *Gameboy, short bool) {
 	pc := gb.CPU.PC
 	opcode := gb.Memory.Read(pc)
 
 	next := gb.Memory.Read(pc + 1)
-	fmt.Printf("[%0#2x]: %3v %-20v %0#4x", opcode, gb.scanlineCounter, debug.GetOpcodeName(opcode, next), pc)
+	out := fmt.Sprintf("[%0#2x]: %3v %-20v %0#4x", opcode, gb.scanlineCounter, debug.GetOpcodeName(opcode, next), pc)
 
 	if !short {
-		fmt.Printf("  [[")
+		out += "  [["
 		for i := math.Max(0, float64(pc)-5); i < float64(pc); i++ {
-			fmt.Printf(" %02x", gb.Memory.Read(uint16(i)))
+			out += fmt.Sprintf(" %02x", gb.Memory.Read(uint16(i)))
 		}
-		fmt.Printf(" \033[1;31m%02x\033[0m", opcode)
+		out += fmt.Sprintf(" \033[1;31m%02x\033[0m", opcode)
 		for i := float64(pc) + 1; i < float64(pc)+6; i++ {
-			fmt.Printf(" %02x", gb.Memory.Read(uint16(i)))
+			out += fmt.Sprintf(" %02x", gb.Memory.Read(uint16(i)))
 		}
-		fmt.Print(" ]]\n")
+		out += " ]]\n"
 	}
+	gb.logf("%s", out)
 }
 
 // LogMemory is a debug function to log some arbitrary memory.
 func LogMemory(gb *Gameboy, start uint16, len uint16) {
-	fmt.Printf(" [[")
+	out := " [["
 	for i := start; i < start+len; i++ {
-		fmt.Printf(" %02x", gb.Memory.Read(i))
+		out += fmt.Sprintf(" %02x", gb.Memory.Read(i))
 	}
-	fmt.Print(" ]]\n")
+	gb.logf("%s ]]\n", out)
 }
 
 // WaitForInput is a debug function which blocks and waits for some input before continuing.
@@ -97,7 +97,8 @@ func (gb *Gameboy) instDec(set func(byte), org byte) {
 }
 
 // Perform a 16bit ADD operation on a value and store the result using the set function.
-// Will also update the CPU flags using the result of the operation.
+// Will also update the CPU flags using the result of the operation. The H flag is set
+// on a carry from bit 11 and the C flag on a carry from bit 15, the Z flag is unchanged.
 func (gb *Gameboy) instAdd16(set func(uint16), val1 uint16, val2 uint16) {
 	total := int32(val1) + int32(val2)
 	set(uint16(total))
@@ -1,12 +1,21 @@
 package gb
 
 import (
+	"context"
+	"crypto/sha1"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"image"
 	"io"
+	"os"
+	"sync/atomic"
 
 	"github.com/Humpheh/goboy/pkg/apu"
 	"github.com/Humpheh/goboy/pkg/bits"
+	"github.com/Humpheh/goboy/pkg/cart"
 )
 
 const (
@@ -46,15 +55,40 @@ type Gameboy struct {
 	// been fully rendered.
 	PreparedData [ScreenWidth][ScreenHeight][3]uint8
 
+	// Images reused for the scaled and cropped frame output.
+	scaledFrame *image.RGBA
+	regionFrame *image.RGBA
+
+	// Recording of the screen started with StartGIF.
+	gif *gifRecorder
+
 	interruptsEnabling bool
 	interruptsOn       bool
 	halted             bool
+	stopped            bool
+
+	// Set when the interrupts were enabled by an EI before the current
+	// instruction, which changes how HALT behaves.
+	interruptsJustEnabled bool
+	// Set by the HALT bug, where the byte after HALT is read twice.
+	haltBug bool
 
 	cbInst [0x100]func()
 
 	// Mask of the currently pressed buttons.
 	inputMask byte
 
+	// Mask of the buttons held down by the player, which may differ from the
+	// inputMask for buttons which have turbo enabled.
+	heldMask byte
+	// Turbo rate of each button in presses per second.
+	turboRate  [8]int
+	turboFrame int
+
+	// Input staged by SetNextInput to be applied at the start of the next
+	// frame. Bit 8 is set when there is staged input.
+	nextInput atomic.Uint32
+
 	// Flag if the game is running in cgb mode. For this to be true the game
 	// rom must support cgb mode and the option be true.
 	cgbMode       bool
@@ -66,6 +100,47 @@ type Gameboy struct {
 
 	thisCpuTicks int
 
+	// Number of bits left to shift in the current serial transfer, and the
+	// cycles until the next bit is shifted.
+	serialBits    int
+	serialCounter int
+	// Byte received from the link partner in the current serial transfer,
+	// which is shifted into the data register a bit at a time.
+	serialIn byte
+
+	// Connection of the infrared port to a peer, which is nil unless
+	// WithInfraredPeer is used.
+	infrared *infraredPort
+
+	// The instruction which was last executed.
+	lastInstruction Instruction
+
+	// Opcodes which have been executed, indexed by the opcode and then the CB
+	// opcodes from 0x100. This is nil unless WithOpcodeCoverage is used.
+	opcodeCoverage *[0x200]bool
+
+	// Number of cycles the previous frame ran over the cycles in a frame,
+	// which are taken off the cycles run in the next frame.
+	cycleOverflow int
+
+	// Detector for the game being stuck, which is nil unless
+	// WithStallCallback is used.
+	stall *stallDetector
+
+	// Number of frames until the save data is saved by WithAutoSave, or 0 if
+	// there is no save waiting.
+	autoSaveFrames int
+
+	// If drawing the screen is skipped, which is used by UpdateN to only draw
+	// the last frame.
+	skipRender bool
+
+	// If the PPU is not run, which is used by the GBS player. The V-Blank
+	// interrupt is instead requested every frame from vblankCounter, which
+	// counts the cycles since the last one.
+	skipPPU       bool
+	vblankCounter int
+
 	keyHandlers map[Button]func()
 }
 
@@ -75,27 +150,212 @@ func (gb *Gameboy) Update() int {
 		return 0
 	}
 
+	gb.latchInput()
+	gb.updateTurbo()
+
 	cycles := 0
-	for cycles < CyclesFrame*gb.getSpeed() {
-		cyclesOp := 4
-		if !gb.halted {
-			if gb.Debug.OutputOpcodes {
-				LogOpcode(gb, false)
-			}
-			cyclesOp = gb.ExecuteNextOpcode()
-		} else {
-			// TODO: This is incorrect
+	for cycles+gb.cycleOverflow < CyclesFrame*gb.getSpeed() {
+		cycles += gb.step()
+	}
+	// Carry the cycles over the end of the frame into the next frame, so
+	// that on average each frame runs for exactly the cycles in a frame.
+	gb.cycleOverflow += cycles - CyclesFrame*gb.getSpeed()
+
+	if gb.stall != nil {
+		gb.countStallFrame()
+	}
+
+	if gb.autoSaveFrames > 0 {
+		gb.updateAutoSave()
+	}
+
+	if gb.gif != nil {
+		gb.recordGIFFrame()
+	}
+	return cycles
+}
+
+// StepInstruction runs a single instruction, along with the graphics, timers
+// and interrupts for the cycles it takes, and returns the number of cycles
+// which were run. If the CPU is halted or stopped then no instruction is run
+// and 4 cycles pass.
+func (gb *Gameboy) StepInstruction() int {
+	return gb.step()
+}
+
+// Maximum number of cycles StepOver and StepOut run for before giving up,
+// which is 10 seconds of emulation.
+const maxStepCycles = ClockSpeed * 10
+
+// StepOver runs the next instruction, and if it is a CALL or RST, continues to
+// run until the subroutine returns to the instruction after it. Otherwise this
+// is the same as StepInstruction. Returns the number of cycles which were run.
+// If the subroutine has not returned after 10 seconds of emulation then
+// ErrCycleLimit is returned.
+func (gb *Gameboy) StepOver() (int, error) {
+	pc, sp := gb.CPU.PC, gb.CPU.SP.HiLo()
+	var next uint16
+	switch opcode := gb.Memory.Read(pc); {
+	case opcode == 0xCD || opcode&0xE7 == 0xC4:
+		// CALL a16 and CALL cc,a16
+		next = pc + 3
+	case opcode&0xC7 == 0xC7:
+		// RST n
+		next = pc + 1
+	default:
+		return gb.step(), nil
+	}
+	if gb.halted || gb.stopped {
+		return gb.step(), nil
+	}
+
+	// The subroutine has returned when the PC is after the call and the
+	// return address has been popped off the stack.
+	cycles := 0
+	for cycles < maxStepCycles {
+		cycles += gb.step()
+		if gb.CPU.PC == next && gb.CPU.SP.HiLo() == sp {
+			return cycles, nil
+		}
+	}
+	return cycles, fmt.Errorf("%w: stepping over call at %#04x", ErrCycleLimit, pc)
+}
+
+// StepOut runs until the current subroutine returns with a RET or RETI, which
+// is when the return address on top of the stack is popped. Returns the number
+// of cycles which were run. If the subroutine has not returned after 10 seconds
+// of emulation then ErrCycleLimit is returned.
+func (gb *Gameboy) StepOut() (int, error) {
+	sp := gb.CPU.SP.HiLo()
+	cycles := 0
+	for cycles < maxStepCycles {
+		cycles += gb.step()
+		if isReturn(gb.lastInstruction.Opcode) && gb.CPU.SP.HiLo() > sp {
+			return cycles, nil
+		}
+	}
+	return cycles, fmt.Errorf("%w: stepping out of subroutine", ErrCycleLimit)
+}
+
+// Returns if an opcode is one of the return instructions.
+func isReturn(opcode byte) bool {
+	return opcode == 0xC9 || opcode == 0xD9 || opcode&0xE7 == 0xC0
+}
+
+// Run the next instruction and clock the rest of the hardware by the cycles it
+// took. Returns the number of cycles, including any to handle an interrupt.
+func (gb *Gameboy) step() int {
+	if gb.stopped {
+		// While stopped the CPU, timers and LCD are not clocked. On the
+		// DMG the screen is blanked until the CPU is resumed.
+		if !gb.IsCGB() {
+			gb.clearScreen()
 		}
-		cycles += cyclesOp
+		gb.Sound.Buffer(4, gb.getSpeed())
+		return 4
+	}
+
+	cyclesOp := 4
+	pc := gb.CPU.PC
+	gb.Memory.written = false
+	if !gb.halted {
+		if gb.Debug.OutputOpcodes {
+			LogOpcode(gb, false)
+		}
+		cyclesOp = gb.ExecuteNextOpcode()
+	}
+	if gb.stall != nil {
+		gb.watchStall(pc, gb.Memory.written)
+	}
+	cycles := cyclesOp
+	if gb.skipPPU {
+		gb.updateVBlank(cyclesOp)
+	} else {
 		gb.updateGraphics(cyclesOp)
-		gb.updateTimers(cyclesOp)
-		cycles += gb.doInterrupts()
+	}
+	gb.updateTimers(cyclesOp)
+	gb.updateSerial(cyclesOp)
+	cycles += gb.doInterrupts()
+
+	gb.Sound.Buffer(cyclesOp, gb.getSpeed())
+	return cycles
+}
+
+// RunFrames updates the state of the gameboy by a number of frames and returns
+// the total number of cycles that were run. This does not depend on any output
+// being rendered, so can be used for running the emulator headless.
+func (gb *Gameboy) RunFrames(n int) int {
+	cycles := 0
+	for i := 0; i < n; i++ {
+		cycles += gb.Update()
+	}
+	return cycles
+}
 
-		gb.Sound.Buffer(cyclesOp, gb.getSpeed())
+// UpdateN updates the state of the gameboy by a number of frames, the same as
+// calling Update for each frame, and returns the total number of cycles that
+// were run. Drawing the screen is skipped for all but the last frames, which
+// makes this faster for running headless when only the final screen is
+// needed, such as for automation. All of the other emulation, including the
+// interrupts and VBlank callback, still runs for each frame.
+func (gb *Gameboy) UpdateN(frames int) int {
+	cycles := 0
+	for i := 0; i < frames; i++ {
+		// Each update runs for the cycles of one frame, so the frame which
+		// is completed in the last update starts in the one before it.
+		gb.skipRender = i < frames-2
+		cycles += gb.Update()
 	}
 	return cycles
 }
 
+// ErrCycleLimit is returned by RunUntil if the condition was not met within the
+// maximum number of cycles.
+var ErrCycleLimit = errors.New("cycle limit reached")
+
+// RunUntil updates the gameboy frame by frame until the condition returns true,
+// which is checked after each frame. If the condition is not met before
+// maxCycles have been run then ErrCycleLimit is returned. This can be used to
+// run test roms, which signal they have finished through the serial port,
+// memory or registers.
+func (gb *Gameboy) RunUntil(condition func(*Gameboy) bool, maxCycles int) error {
+	if gb.paused {
+		return errors.New("cannot run while paused")
+	}
+	cycles := 0
+	for !condition(gb) {
+		if cycles >= maxCycles {
+			return fmt.Errorf("%w: ran %v cycles", ErrCycleLimit, cycles)
+		}
+		cycles += gb.Update()
+	}
+	return nil
+}
+
+// Run updates the gameboy frame by frame until the context is cancelled, calling
+// frameCb after each frame if it is not nil. Frames are run as fast as possible,
+// unless a pacer was set with the WithFramePacer option. When the context is
+// cancelled the save data is flushed with Close and the error from the context
+// is returned.
+func (gb *Gameboy) Run(ctx context.Context, frameCb func(*Gameboy)) error {
+	defer gb.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if gb.options.pacer != nil {
+			gb.options.pacer.Wait()
+		}
+		gb.Update()
+		if frameCb != nil {
+			frameCb(gb)
+		}
+	}
+}
+
 // togglePaused switches the paused state of the execution.
 func (gb *Gameboy) togglePaused() {
 	gb.paused = !gb.paused
@@ -104,10 +364,38 @@ func (gb *Gameboy) togglePaused() {
 // ToggleSoundChannel toggles a sound channel for debugging.
 func (gb *Gameboy) ToggleSoundChannel(channel int) {
 	gb.Sound.ToggleSoundChannel(channel)
+	gb.logf("Toggle Channel %v mute\n", channel)
+}
+
+// SetSoundEnabled enables or disables the sound output while the Gameboy is
+// running. The sound channels continue to run while disabled so the sound
+// does not go out of sync, and the samples read with ReadAudio are not muted.
+func (gb *Gameboy) SetSoundEnabled(enabled bool) {
+	gb.Sound.SetOutputEnabled(enabled)
+}
+
+// ReadAudio reads the sound samples generated since the last call into buf and
+// returns the number of values written. The samples are interleaved left and
+// right samples in the range [-1, 1] at the rate set with WithSampleRate, which
+// is apu.SampleRate by default. This can be used to play the sound with any
+// audio library instead of using the WithSound option, and is safe to call
+// from a different goroutine.
+func (gb *Gameboy) ReadAudio(buf []float32) int {
+	return gb.Sound.ReadSamples(buf)
 }
 
+// ReadAudioMono is the same as ReadAudio, but the left and right samples are
+// mixed into a single mono sample, so each value written to buf is a sample.
+// This is useful when the output only has one channel and the stereo panning
+// is not needed. The samples are shared with ReadAudio, so each sample is only
+// read by one of them.
+func (gb *Gameboy) ReadAudioMono(buf []float32) int {
+	return gb.Sound.ReadSamplesMono(buf)
+}
+
+// SoundString logs the state of the sound registers.
 func (gb *Gameboy) SoundString() {
-	gb.Sound.LogSoundState()
+	gb.logf("%s", gb.Sound.SoundState())
 }
 
 // BGMapString returns a string of the values in the background map.
@@ -123,8 +411,221 @@ func (gb *Gameboy) BGMapString() string {
 	return out
 }
 
+// TileAttributes are the CGB attributes of a tile in a background map, which
+// are stored in VRAM bank 1.
+type TileAttributes byte
+
+// Palette returns the index of the CGB background palette used by the tile.
+func (attr TileAttributes) Palette() byte {
+	return byte(attr) & 0x7
+}
+
+// Bank returns the VRAM bank the tile data is read from.
+func (attr TileAttributes) Bank() byte {
+	return byte(attr) >> 3 & 0x1
+}
+
+// XFlip returns if the tile is flipped horizontally.
+func (attr TileAttributes) XFlip() bool {
+	return bits.Test(byte(attr), 5)
+}
+
+// YFlip returns if the tile is flipped vertically.
+func (attr TileAttributes) YFlip() bool {
+	return bits.Test(byte(attr), 6)
+}
+
+// Priority returns if the tile is drawn above sprites.
+func (attr TileAttributes) Priority() bool {
+	return bits.Test(byte(attr), 7)
+}
+
+// BGAttributes returns the CGB attributes of each tile in the background map,
+// indexed by the row and then the column of the tile. The second return value
+// is false if the game is not running in CGB mode, in which case there are no
+// attributes.
+func (gb *Gameboy) BGAttributes() (attrs [0x20][0x20]TileAttributes, ok bool) {
+	if !gb.IsCGB() {
+		return attrs, false
+	}
+	for y := uint16(0); y < 0x20; y++ {
+		for x := uint16(0); x < 0x20; x++ {
+			attrs[y][x] = TileAttributes(gb.Memory.VRAM[0x3800+(y*0x20)+x])
+		}
+	}
+	return attrs, true
+}
+
+// BGAttrString returns a string of the CGB attributes of the tiles in the
+// background map. An empty string is returned if the game is not running in
+// CGB mode.
+func (gb *Gameboy) BGAttrString() string {
+	attrs, ok := gb.BGAttributes()
+	if !ok {
+		return ""
+	}
+	out := ""
+	for y, row := range attrs {
+		out += fmt.Sprintf("%2x: ", y)
+		for _, attr := range row {
+			out += fmt.Sprintf("%02x ", byte(attr))
+		}
+		out += "\n"
+	}
+	return out
+}
+
+// TileInfo describes the tile in a background map at a position.
+type TileInfo struct {
+	// Index is the tile number stored in the background map.
+	Index byte
+	// DataAddress is the address of the tile data in VRAM. On CGB the data is
+	// in the VRAM bank given by the attributes.
+	DataAddress uint16
+	// Attributes are the CGB attributes of the tile, which are always zero
+	// when not running in CGB mode.
+	Attributes TileAttributes
+}
+
+// TileAt returns the tile in the background map covering a pixel in background
+// space, where (0, 0) is the top left of the 256x256 pixel background. The
+// map and tile data area are selected by the LCDC register in the same way as
+// when the background is rendered. Coordinates outside of the background wrap
+// around like the background does when scrolling.
+func (gb *Gameboy) TileAt(bgX, bgY int) TileInfo {
+	lcdControl := gb.Memory.ReadHighRam(uint16(LCDC))
+	backgroundMemory := bgTileMapBase(lcdControl)
+	tileData, unsigned := tileDataBase(lcdControl)
+
+	tileAddress := backgroundMemory + uint16((bgY&0xFF)/8)*32 + uint16((bgX&0xFF)/8)
+	info := TileInfo{Index: gb.Memory.VRAM[tileAddress-0x8000]}
+	info.DataAddress = tileDataAddress(tileData, unsigned, info.Index)
+	if gb.IsCGB() {
+		info.Attributes = TileAttributes(gb.Memory.VRAM[tileAddress-0x6000])
+	}
+	return info
+}
+
+// SpriteAt returns the index in OAM of the sprite which is drawn at a pixel on
+// the screen. The second return value is false if there is no sprite covering
+// the pixel. Sprites are matched using their size, including transparent
+// pixels, and the same priority and per-line limit as when they are rendered.
+func (gb *Gameboy) SpriteAt(screenX, screenY int) (int, bool) {
+	ySize := spriteHeight(gb.Memory.ReadHighRam(uint16(LCDC)))
+	found, foundX := -1, 0
+	lineSprites := 0
+	for sprite := 0; sprite < 40; sprite++ {
+		yPos := int(gb.Memory.OAM[sprite*4]) - 16
+		if screenY < yPos || screenY >= yPos+int(ySize) {
+			continue
+		}
+
+		// Only 10 sprites are allowed to be displayed on each line
+		if lineSprites >= 10 {
+			break
+		}
+		lineSprites++
+
+		xPos := int(gb.Memory.OAM[sprite*4+1]) - 8
+		if screenX < xPos || screenX >= xPos+8 {
+			continue
+		}
+
+		// On DMG the sprite with the smallest X coordinate is on top, and on
+		// CGB the first sprite in OAM is on top (see oamSpritePriority).
+		if found == -1 || (!gb.oamSpritePriority() && xPos < foundX) {
+			found, foundX = sprite, xPos
+		}
+	}
+	return found, found != -1
+}
+
+// Sprite is a decoded entry in the sprite attribute table (OAM).
+type Sprite struct {
+	// X and Y are the position of the top left of the sprite on the screen,
+	// which are negative or past the edge of the screen for hidden sprites.
+	X, Y int
+	// TileIndex is the tile number of the sprite data. In 8x16 mode the low
+	// bit is ignored when the sprite is drawn.
+	TileIndex byte
+	// Priority is true if the sprite is drawn behind non-zero background
+	// colours.
+	Priority bool
+	FlipX    bool
+	FlipY    bool
+	// Palette is the DMG palette the sprite uses, 0 for OBP0 or 1 for OBP1.
+	Palette byte
+	// CGBBank is the VRAM bank of the tile data and CGBPalette is the sprite
+	// palette number. These are only used in CGB mode.
+	CGBBank    byte
+	CGBPalette byte
+}
+
+// Sprites returns a snapshot of the 40 sprites in OAM, in OAM order.
+func (gb *Gameboy) Sprites() []Sprite {
+	sprites := make([]Sprite, 40)
+	for i := range sprites {
+		entry := gb.Memory.OAM[i*4 : i*4+4]
+		attributes := entry[3]
+		sprites[i] = Sprite{
+			Y:          int(entry[0]) - 16,
+			X:          int(entry[1]) - 8,
+			TileIndex:  entry[2],
+			Priority:   bits.Test(attributes, 7),
+			FlipY:      bits.Test(attributes, 6),
+			FlipX:      bits.Test(attributes, 5),
+			Palette:    bits.Val(attributes, 4),
+			CGBBank:    bits.Val(attributes, 3),
+			CGBPalette: attributes & 0x7,
+		}
+	}
+	return sprites
+}
+
+// MemoryRegions is the mapping of the banked memory regions which are visible
+// to the CPU.
+type MemoryRegions struct {
+	// ROMBank0 and ROMBank are the cartridge ROM banks selected at 0x0000 and
+	// 0x4000. These are the bank numbers selected by the controller, before
+	// they wrap around the number of banks in the ROM.
+	ROMBank0 uint32
+	ROMBank  uint32
+	// RAMBank is the cartridge RAM bank selected at 0xA000, and RAMEnabled is
+	// true if it can be accessed. On the MBC3 this may select a real time
+	// clock register instead.
+	RAMBank    uint32
+	RAMEnabled bool
+	// WRAMBank is the WRAM bank at 0xD000, which can only be switched from
+	// bank 1 in CGB mode.
+	WRAMBank byte
+	// VRAMBank is the VRAM bank at 0x8000, which is always 0 unless in CGB
+	// mode.
+	VRAMBank byte
+}
+
+// MemoryRegions returns the banks which are currently mapped into memory. The
+// cartridge banks are zero if no game is loaded.
+func (gb *Gameboy) MemoryRegions() MemoryRegions {
+	regions := MemoryRegions{
+		WRAMBank: gb.Memory.WRAMBank,
+		VRAMBank: gb.Memory.VRAMBank,
+	}
+	if !gb.IsGameLoaded() {
+		return regions
+	}
+	state := gb.Memory.Cart.BankState()
+	regions.ROMBank0 = state.RomBank0
+	regions.ROMBank = state.RomBank
+	regions.RAMBank = state.RamBank
+	regions.RAMEnabled = state.RamEnabled
+	return regions
+}
+
 func (gb *Gameboy) printBGMap() {
-	fmt.Printf("BG Map:\n%s", gb.BGMapString())
+	gb.logf("BG Map:\n%s", gb.BGMapString())
+	if gb.IsCGB() {
+		gb.logf("BG Attributes:\n%s", gb.BGAttrString())
+	}
 }
 
 // Get the current CPU speed multiplier (either 1 or 2).
@@ -194,10 +695,42 @@ func (gb *Gameboy) dividerRegister(cycles int) {
 	gb.CPU.Divider += cycles
 	if gb.CPU.Divider >= 255 {
 		gb.CPU.Divider -= 255
-		gb.Memory.HighRAM[DIV-0xFF00]++
+		gb.setDivider(gb.Memory.HighRAM[DIV-0xFF00] + 1)
 	}
 }
 
+// Set the value of the DIV register. The APU frame sequencer is stepped on
+// the falling edge of bit 4 of DIV (bit 5 in double speed mode), so changing
+// the value may also step the frame sequencer.
+func (gb *Gameboy) setDivider(value byte) {
+	var bit byte = 4
+	if gb.currentSpeed == 1 {
+		bit = 5
+	}
+	if bits.Test(gb.Memory.HighRAM[DIV-0xFF00], bit) && !bits.Test(value, bit) {
+		gb.Sound.StepFrameSequencer()
+	}
+	gb.Memory.HighRAM[DIV-0xFF00] = value
+}
+
+// RequestInterrupt requests an interrupt by setting its bit in the IF register,
+// which will be serviced if the interrupt is enabled. The interrupts are:
+//
+//	0  V-Blank
+//	1  LCDC Status
+//	2  Timer Overflow
+//	3  Serial Transfer
+//	4  Joypad
+//
+// An error is returned if the interrupt is not one of these.
+func (gb *Gameboy) RequestInterrupt(interrupt int) error {
+	if interrupt < 0 || interrupt > 4 {
+		return fmt.Errorf("invalid interrupt %v, must be between 0 and 4", interrupt)
+	}
+	gb.requestInterrupt(byte(interrupt))
+	return nil
+}
+
 // Request the Gameboy to perform an interrupt.
 func (gb *Gameboy) requestInterrupt(interrupt byte) {
 	req := gb.Memory.HighRAM[0x0F] | 0xE0
@@ -205,26 +738,30 @@ func (gb *Gameboy) requestInterrupt(interrupt byte) {
 	gb.Memory.Write(0xFF0F, req)
 }
 
+// Handle the interrupts after an instruction. A pending interrupt wakes the CPU
+// from HALT even if interrupts are disabled, but is only serviced if they are
+// enabled. Returns the number of cycles taken to service an interrupt.
 func (gb *Gameboy) doInterrupts() (cycles int) {
 	if gb.interruptsEnabling {
 		gb.interruptsOn = true
 		gb.interruptsEnabling = false
+		gb.interruptsJustEnabled = true
 		return 0
 	}
-	if !gb.interruptsOn && !gb.halted {
+	gb.interruptsJustEnabled = false
+
+	pending := gb.Memory.HighRAM[0x0F] & gb.Memory.HighRAM[0xFF] & 0x1F
+	if pending == 0 {
 		return 0
 	}
-
-	req := gb.Memory.HighRAM[0x0F] | 0xE0
-	enabled := gb.Memory.HighRAM[0xFF]
-
-	if req > 0 {
-		var i byte
-		for i = 0; i < 5; i++ {
-			if bits.Test(req, i) && bits.Test(enabled, i) {
-				gb.serviceInterrupt(i)
-				return 20
-			}
+	gb.halted = false
+	if !gb.interruptsOn {
+		return 0
+	}
+	for i := byte(0); i < 5; i++ {
+		if bits.Test(pending, i) {
+			gb.serviceInterrupt(i)
+			return 20
 		}
 	}
 	return 0
@@ -239,14 +776,9 @@ var interruptAddresses = map[byte]uint16{
 	4: 0x60, // Hi-Lo P10-P13
 }
 
-// Called if an interrupt has been raised. Will check if interrupts are
-// enabled and will jump to the interrupt address.
+// Service an interrupt by disabling interrupts, clearing its request flag and
+// jumping to the interrupt address.
 func (gb *Gameboy) serviceInterrupt(interrupt byte) {
-	// If was halted without interrupts, do not jump or reset IF
-	if !gb.interruptsOn && gb.halted {
-		gb.halted = false
-		return
-	}
 	gb.interruptsOn = false
 	gb.halted = false
 
@@ -256,6 +788,9 @@ func (gb *Gameboy) serviceInterrupt(interrupt byte) {
 
 	gb.pushStack(gb.CPU.PC)
 	gb.CPU.PC = interruptAddresses[interrupt]
+	if gb.stall != nil {
+		gb.stall.reset(gb.CPU.PC)
+	}
 }
 
 // Push a 16 bit value onto the stack and decrement SP.
@@ -275,39 +810,294 @@ func (gb *Gameboy) popStack() uint16 {
 	return byte1 | byte2
 }
 
+// StackDump returns a number of 16 bit values from the top of the stack, in the
+// order they would be popped, without changing the stack pointer.
+func (gb *Gameboy) StackDump(depth int) []uint16 {
+	values := make([]uint16, 0, depth)
+	sp := gb.CPU.SP.HiLo()
+	for i := 0; i < depth; i++ {
+		values = append(values, uint16(gb.Memory.Read(sp))|uint16(gb.Memory.Read(sp+1))<<8)
+		sp += 2
+	}
+	return values
+}
+
 func (gb *Gameboy) joypadValue(current byte) byte {
+	// Bit 5 selects the action buttons and bit 4 selects the directions when
+	// they are low. If both are selected the lines of both are combined.
 	var in byte = 0xF
-	if bits.Test(current, 4) {
-		in = gb.inputMask & 0xF
-	} else if bits.Test(current, 5) {
-		in = (gb.inputMask >> 4) & 0xF
+	if !bits.Test(current, 5) {
+		in &= gb.inputMask & 0xF
+	}
+	if !bits.Test(current, 4) {
+		in &= (gb.inputMask >> 4) & 0xF
 	}
 	return current | 0xc0 | in
 }
 
+// HasBattery returns if the loaded game has battery backed RAM, so can save. If
+// not, the game has no save data to load or save. This returns false if there
+// is no game loaded.
+func (gb *Gameboy) HasBattery() bool {
+	return gb.IsGameLoaded() && gb.Memory.Cart.HasBattery()
+}
+
 // IsGameLoaded returns if there is a game loaded in the gameboy or not.
 func (gb *Gameboy) IsGameLoaded() bool {
 	return gb.Memory != nil && gb.Memory.Cart != nil
 }
 
+// Title returns the title of the loaded game from the cartridge header, or an
+// empty string if there is no game loaded.
+func (gb *Gameboy) Title() string {
+	if !gb.IsGameLoaded() {
+		return ""
+	}
+	return gb.Memory.Cart.GetName()
+}
+
+// ROMHash returns the SHA-1 hash of the loaded rom, which is calculated when
+// the rom is loaded. This matches the hashes used by ROM databases such as
+// No-Intro, so can be used to look up the game or as a key for per-game
+// settings. The hash is zero if there is no game loaded.
+func (gb *Gameboy) ROMHash() [sha1.Size]byte {
+	if !gb.IsGameLoaded() {
+		return [sha1.Size]byte{}
+	}
+	return gb.Memory.Cart.ROMHash()
+}
+
+// Cart returns the banking controller of the loaded cartridge, or nil if there
+// is no game loaded. The returned controller is live, so can be used to access
+// the cartridge RAM and any controller specific functionality.
+func (gb *Gameboy) Cart() cart.BankingController {
+	if !gb.IsGameLoaded() {
+		return nil
+	}
+	return gb.Memory.Cart.BankingController
+}
+
+// Close saves the battery backed save data of the loaded game to the location
+// set with the WithSaveFile or WithSRAMSaver options.
+func (gb *Gameboy) Close() {
+	if gb.IsGameLoaded() {
+		gb.Memory.Cart.Save()
+	}
+}
+
+// ExportSRAM returns the battery backed save data of the loaded cartridge,
+// including the real time clock if the cartridge has one. An empty slice is
+// returned if there is no game loaded.
+func (gb *Gameboy) ExportSRAM() []byte {
+	if !gb.IsGameLoaded() {
+		return []byte{}
+	}
+	return gb.Memory.Cart.ExportSRAM()
+}
+
+// SRAMChecksum returns the CRC-32 checksum of the cartridge RAM, which can be
+// used to detect if the save data has changed. Unlike ExportSRAM this does not
+// include the real time clock or clear the dirty flag. If there is no game
+// loaded then 0 is returned.
+func (gb *Gameboy) SRAMChecksum() uint32 {
+	if !gb.IsGameLoaded() {
+		return 0
+	}
+	return crc32.ChecksumIEEE(gb.Memory.Cart.GetSaveData())
+}
+
+// SRAMDirty returns true if the battery backed RAM of the loaded cartridge
+// has changed since it was last exported or saved. This can be used to skip
+// saving the RAM when it has not changed.
+func (gb *Gameboy) SRAMDirty() bool {
+	if !gb.IsGameLoaded() {
+		return false
+	}
+	return gb.Memory.Cart.IsDirty()
+}
+
+// ImportSRAM loads battery backed save data into the loaded cartridge. This
+// is a noop if there is no game loaded. If the data is not the same size as
+// the cartridge RAM then an error is returned, unless resize is true, in which
+// case the data is truncated or padded to fit.
+func (gb *Gameboy) ImportSRAM(data []byte, resize bool) error {
+	if !gb.IsGameLoaded() {
+		return nil
+	}
+	return gb.Memory.Cart.ImportSRAM(data, resize)
+}
+
 // IsCGB returns if we are using CGB features.
 func (gb *Gameboy) IsCGB() bool {
 	return gb.cgbMode
 }
 
+// oamSpritePriority returns if overlapping sprites are prioritised by their
+// position in OAM rather than by their X coordinate. This is the CGB
+// behaviour unless bit 0 of the object priority mode register (OPRI, 0xFF6C)
+// is set to select the DMG behaviour.
+func (gb *Gameboy) oamSpritePriority() bool {
+	return gb.IsCGB() && !bits.Test(gb.Memory.HighRAM[0x6C], 0)
+}
+
+// IsHalted returns if the CPU has been halted by the HALT instruction and is
+// waiting for an interrupt.
+func (gb *Gameboy) IsHalted() bool {
+	return gb.halted
+}
+
+// IsStopped returns if the CPU has been stopped by the STOP instruction and is
+// waiting for a button to be pressed.
+func (gb *Gameboy) IsStopped() bool {
+	return gb.stopped
+}
+
+// IsDoubleSpeed returns if the CPU is running in the CGB double speed mode.
+func (gb *Gameboy) IsDoubleSpeed() bool {
+	return gb.currentSpeed == 1
+}
+
+// SetDoubleSpeed sets if the CPU is running in double speed mode, without the
+// game switching speed, and clears any prepared speed switch. This is a noop if
+// the game is not running in CGB mode.
+func (gb *Gameboy) SetDoubleSpeed(double bool) {
+	if !gb.IsCGB() {
+		return
+	}
+	gb.currentSpeed = bits.B(double)
+	gb.prepareSpeed = false
+}
+
 // Initialise the Gameboy using a path to a rom.
 func (gb *Gameboy) init(romFile string) error {
 	gb.setup()
 
 	// Load the ROM file
-	hasCGB, err := gb.Memory.LoadCart(romFile, gb.options.saver)
+	hasCGB, err := gb.Memory.LoadCart(romFile, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open rom file: %w", err)
+	}
+	gb.Memory.Cart.SetSRAMStorage(gb.options.sramLoader, gb.options.sramSaver)
+	gb.setCameraSource()
+	gb.setAutoSave()
+	gb.cgbMode = gb.options.model == CGB && hasCGB
+	gb.CPU.Init(gb.options.model, gb.cgbMode)
+	return nil
+}
+
+// LoadROM replaces the currently loaded game with a rom from a file. The save
+// data of the current game is flushed and the Gameboy is reset, keeping the
+// options and sound output it was created with. The save locations set with the
+// WithSaveFile, WithSRAMLoader and WithSRAMSaver options belong to the original
+// game, so are not used for the new game; its save data can instead be managed
+// with ImportSRAM and ExportSRAM.
+func (gb *Gameboy) LoadROM(romFile string) error {
+	rom, err := os.ReadFile(romFile)
+	if err != nil {
+		return fmt.Errorf("failed to open rom file: %w", err)
+	}
+	return gb.loadROM(rom, romFile)
+}
+
+// LoadROMBytes replaces the currently loaded game with a rom from a byte array.
+// This behaves in the same way as LoadROM.
+func (gb *Gameboy) LoadROMBytes(rom []byte) error {
+	return gb.loadROM(rom, "")
+}
+
+// Swap the loaded cartridge to a new rom and reset the Gameboy.
+func (gb *Gameboy) loadROM(rom []byte, filename string) error {
+	c, err := cart.LoadCart(rom, filename, nil)
 	if err != nil {
-		return fmt.Errorf("failed to open rom file: %s", err)
+		return fmt.Errorf("failed to load rom: %w", err)
 	}
-	gb.cgbMode = gb.options.cgbMode && hasCGB
+	if gb.IsGameLoaded() {
+		gb.Memory.Cart.Save()
+	}
+	gb.reset(c)
 	return nil
 }
 
+// PowerCycle turns the Gameboy off and on again with the same game. The save
+// data is flushed and everything is reset to the power on state, including
+// the RAM which is filled using the WithMemoryInitPattern option and the
+// cartridge banking controller. Only the battery backed save data and real time
+// clock of the cartridge are kept, along with the paused state, the buttons
+// held by the player and their turbo rates. There is no boot rom, so the CPU starts
+// from the state after the boot rom has run.
+func (gb *Gameboy) PowerCycle() {
+	if !gb.IsGameLoaded() {
+		return
+	}
+	gb.reset(gb.Memory.Cart.PowerCycle())
+}
+
+// Reset the Gameboy with a new cartridge, keeping the options, sound output,
+// paused state and the buttons held by the player and their turbo rates. The
+// save data of the current cartridge should be flushed before calling this.
+func (gb *Gameboy) reset(c *cart.Cart) {
+	var handlers []memoryHandler
+	heldMask := byte(0xFF)
+	if gb.Memory != nil {
+		handlers = gb.Memory.handlers
+		heldMask = gb.heldMask
+	}
+
+	*gb = Gameboy{
+		options:   gb.options,
+		Sound:     gb.Sound,
+		gif:       gb.gif,
+		infrared:  gb.infrared,
+		paused:    gb.paused,
+		turboRate: gb.turboRate,
+	}
+	gb.setup()
+	gb.Memory.handlers = handlers
+	gb.heldMask = heldMask
+	gb.inputMask = heldMask
+	gb.ClearScreen()
+
+	gb.Memory.Cart = c
+	gb.cgbMode = gb.options.model == CGB && c.GetMode()&cart.CGB != 0
+	gb.CPU.Init(gb.options.model, gb.cgbMode)
+	gb.setCameraSource()
+	gb.setAutoSave()
+}
+
+// Set the image source of the cartridge if it is a Gameboy Camera.
+func (gb *Gameboy) setCameraSource() {
+	if camera, ok := gb.Memory.Cart.BankingController.(*cart.Camera); ok {
+		camera.SetSource(gb.options.cameraSource)
+	}
+}
+
+// Number of frames WithAutoSave waits after the game disables the cartridge
+// RAM before saving, which is about half a second. Games may disable the RAM
+// several times while saving, or regularly to read the real time clock, so
+// the disables in this time are grouped into a single save.
+const autoSaveDelay = 30
+
+// Save the cartridge RAM after it is disabled if the WithAutoSave option is
+// set. The save is made at the end of a frame, so the save location is not
+// written to while the game is writing to memory.
+func (gb *Gameboy) setAutoSave() {
+	if gb.options.autoSave {
+		gb.Memory.Cart.SetRAMDisableCallback(func() {
+			if gb.autoSaveFrames == 0 {
+				gb.autoSaveFrames = autoSaveDelay
+			}
+		})
+	}
+}
+
+// Count down the frames until the save data is saved by WithAutoSave.
+func (gb *Gameboy) updateAutoSave() {
+	gb.autoSaveFrames--
+	if gb.autoSaveFrames == 0 {
+		gb.Memory.Cart.Save()
+	}
+}
+
 func (gb *Gameboy) initKeyHandlers() {
 	gb.keyHandlers = map[Button]func(){
 		ButtonPause:               gb.togglePaused,
@@ -325,22 +1115,42 @@ func (gb *Gameboy) initKeyHandlers() {
 
 // Setup and instantitate the gameboys components.
 func (gb *Gameboy) setup() {
-	// Initialise the CPU
+	// Initialise the CPU, the registers are set once the game is loaded as
+	// they depend on if it is running in CGB mode
 	gb.CPU = &CPU{}
-	gb.CPU.Init(gb.options.cgbMode)
 
 	// Initialise the memory
 	gb.Memory = &Memory{}
 	gb.Memory.Init(gb)
 
-	gb.Sound = &apu.APU{}
-	gb.Sound.Init(gb.options.sound)
+	// Keep the sound output if it has already been started
+	if gb.Sound == nil {
+		gb.Sound = &apu.APU{}
+		gb.Sound.Init(gb.options.sound, gb.options.sampleRate)
+	} else {
+		gb.Sound.Reset()
+	}
+	gb.Sound.SetHighPassFilter(gb.options.model.chargeFactor())
+	gb.Sound.SetDynamicRateControl(gb.options.dynamicAudioSync)
 
 	gb.Debug = DebugFlags{}
 	gb.scanlineCounter = 456
 	gb.inputMask = 0xFF
+	gb.heldMask = 0xFF
 
 	gb.cbInst = gb.cbInstructions()
+	if gb.options.infraredPeer != nil && gb.infrared == nil {
+		gb.infrared = newInfraredPort(gb.options.infraredPeer)
+	}
+	if gb.options.opcodeCoverage {
+		gb.opcodeCoverage = &[0x200]bool{}
+	}
+	if gb.options.stallCallback != nil {
+		gb.stall = &stallDetector{
+			threshold: gb.options.stallFrames,
+			callback:  gb.options.stallCallback,
+		}
+	}
 
 	gb.SpritePalette = NewPalette()
 	gb.BGPalette = NewPalette()
@@ -348,7 +1158,81 @@ func (gb *Gameboy) setup() {
 	gb.initKeyHandlers()
 }
 
+var (
+	// ErrSaveStateMismatch is returned when loading a save state which was
+	// saved from a different game.
+	ErrSaveStateMismatch = errors.New("save state is for a different game")
+
+	// ErrSaveStateMode is returned when loading a save state which was saved
+	// from the same game running in a different mode, such as a state saved
+	// in CGB mode being loaded with the DMG model.
+	ErrSaveStateMode = errors.New("save state is for a different CGB mode")
+
+	// ErrSaveStateFormat is returned when loading a save state which was saved
+	// in a different format, such as a state encoded with WithStateCodec being
+	// loaded without a codec, or a state from a different version.
+	ErrSaveStateFormat = errors.New("save state is in a different format")
+)
+
+const (
+	// Version of the save state format, which is stored in the low bits of
+	// the format byte after the game identifier.
+	stateVersion = 1
+	// Bit set in the format byte if the state is encoded with WithStateCodec.
+	stateEncoded = 0x80
+)
+
+// Get the format byte of the save states written with the current options.
+func (gb *Gameboy) stateFormat(encoded bool) byte {
+	format := byte(stateVersion)
+	if encoded {
+		format |= stateEncoded
+	}
+	return format
+}
+
+// Get an identifier for the loaded cartridge, made up of the cartridge type
+// and a hash of the title, to store in save states.
+func (gb *Gameboy) cartID() (byte, uint32) {
+	hash := fnv.New32a()
+	hash.Write([]byte(gb.Memory.Cart.GetName()))
+	return gb.Memory.Cart.GetType(), hash.Sum32()
+}
+
 func (gb *Gameboy) SaveState(writer io.Writer) error {
+	// Write the cartridge identifier
+	cartType, titleHash := gb.cartID()
+	if err := binary.Write(writer, binary.LittleEndian, cartType); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, binary.LittleEndian, titleHash); err != nil {
+		return err
+	}
+
+	// Write if the game is running in CGB mode
+	if err := binary.Write(writer, binary.LittleEndian, gb.IsCGB()); err != nil {
+		return err
+	}
+
+	// Write the version and if the rest of the state is encoded
+	format := gb.stateFormat(gb.options.stateEncoder != nil)
+	if err := binary.Write(writer, binary.LittleEndian, format); err != nil {
+		return err
+	}
+
+	if gb.options.stateEncoder == nil {
+		return gb.saveStateBody(writer)
+	}
+	encoder := gb.options.stateEncoder(writer)
+	if err := gb.saveStateBody(encoder); err != nil {
+		encoder.Close()
+		return err
+	}
+	return encoder.Close()
+}
+
+// Write the state of the Gameboy after the cartridge identifier.
+func (gb *Gameboy) saveStateBody(writer io.Writer) error {
 	// Write registers
 	if err := binary.Write(writer, binary.LittleEndian, gb.CPU.AF.HiLo()); err != nil {
 		return err
@@ -385,25 +1269,81 @@ func (gb *Gameboy) SaveState(writer io.Writer) error {
 	}
 
 	// Write interrupts
-	ints := byte(0)
-	if gb.interruptsEnabling {
-		ints = 1
+	if err := binary.Write(writer, binary.LittleEndian, gb.interruptFlags()); err != nil {
+		return err
 	}
-	if gb.interruptsOn {
-		ints |= 2
+
+	// Write serial transfer
+	if err := binary.Write(writer, binary.LittleEndian, int32(gb.serialBits)); err != nil {
+		return err
 	}
-	if gb.halted {
-		ints |= 3
+	if err := binary.Write(writer, binary.LittleEndian, int32(gb.serialCounter)); err != nil {
+		return err
 	}
-	if err := binary.Write(writer, binary.LittleEndian, ints); err != nil {
+	if err := binary.Write(writer, binary.LittleEndian, gb.serialIn); err != nil {
 		return err
 	}
 
 	// Write Memory
-	return gb.Memory.SaveState(writer)
+	if err := gb.Memory.SaveState(writer); err != nil {
+		return err
+	}
+
+	// Write sound
+	return gb.Sound.SaveState(writer)
 }
 
+// LoadState loads a state saved with SaveState. ErrSaveStateMismatch is
+// returned if the state was saved from a different game, ErrSaveStateMode if
+// it was saved from the same game running in a different CGB mode, and
+// ErrSaveStateFormat if it was saved by a different version or with a
+// different use of WithStateCodec.
 func (gb *Gameboy) LoadState(reader io.Reader) error {
+	// Check the cartridge identifier
+	var cartType byte
+	if err := binary.Read(reader, binary.LittleEndian, &cartType); err != nil {
+		return err
+	}
+	var titleHash uint32
+	if err := binary.Read(reader, binary.LittleEndian, &titleHash); err != nil {
+		return err
+	}
+	if expectedType, expectedHash := gb.cartID(); cartType != expectedType || titleHash != expectedHash {
+		return ErrSaveStateMismatch
+	}
+
+	// Check the state was saved in the same mode
+	var cgbMode bool
+	if err := binary.Read(reader, binary.LittleEndian, &cgbMode); err != nil {
+		return err
+	}
+	if cgbMode != gb.IsCGB() {
+		return ErrSaveStateMode
+	}
+
+	// Check the state can be read with the current options
+	var format byte
+	if err := binary.Read(reader, binary.LittleEndian, &format); err != nil {
+		return err
+	}
+	encoded := gb.options.stateDecoder != nil
+	switch {
+	case format&stateEncoded != 0 && !encoded:
+		return fmt.Errorf("%w: state is encoded but no codec is set", ErrSaveStateFormat)
+	case format&stateEncoded == 0 && encoded:
+		return fmt.Errorf("%w: state is not encoded but a codec is set", ErrSaveStateFormat)
+	case format != gb.stateFormat(encoded):
+		return fmt.Errorf("%w: version %v, expected %v", ErrSaveStateFormat, format&^stateEncoded, stateVersion)
+	}
+
+	if gb.options.stateDecoder != nil {
+		reader = gb.options.stateDecoder(reader)
+	}
+	return gb.loadStateBody(reader)
+}
+
+// Read the state of the Gameboy after the cartridge identifier.
+func (gb *Gameboy) loadStateBody(reader io.Reader) error {
 	// Read registers
 	var tmp uint16
 	if err := binary.Read(reader, binary.LittleEndian, &tmp); err != nil {
@@ -454,15 +1394,33 @@ func (gb *Gameboy) LoadState(reader io.Reader) error {
 	if err := binary.Read(reader, binary.LittleEndian, &ints); err != nil {
 		return err
 	}
-	gb.interruptsEnabling = ints&1 != 0
-	gb.interruptsOn = ints&2 != 0
-	gb.halted = ints&4 != 0
+	gb.setInterruptFlags(ints)
+
+	// Read serial transfer
+	if err := binary.Read(reader, binary.LittleEndian, &tmp32); err != nil {
+		return err
+	}
+	gb.serialBits = int(tmp32)
+	if err := binary.Read(reader, binary.LittleEndian, &tmp32); err != nil {
+		return err
+	}
+	gb.serialCounter = int(tmp32)
+	if err := binary.Read(reader, binary.LittleEndian, &gb.serialIn); err != nil {
+		return err
+	}
 
 	// Read Memory
-	return gb.Memory.LoadState(reader)
+	if err := gb.Memory.LoadState(reader); err != nil {
+		return err
+	}
+
+	// Read sound
+	return gb.Sound.LoadState(reader)
 }
 
-// NewGameboy returns a new Gameboy instance.
+// NewGameboy returns a new Gameboy instance. If the rom cannot be loaded then
+// the error can be checked with errors.Is for the errors from cart.CheckROM,
+// or for the errors from opening the file.
 func NewGameboy(romFile string, opts ...GameboyOption) (*Gameboy, error) {
 	// Build the gameboy
 	gameboy := Gameboy{}
@@ -0,0 +1,835 @@
+package gb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"io/fs"
+	"log"
+	"math/rand"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/Humpheh/goboy/pkg/bits"
+	"github.com/Humpheh/goboy/pkg/cart"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGameboy_Cart(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	_, ok := gb.Cart().(*cart.MBC1)
+	assert.True(t, ok, "expected MBC1 controller but got %T", gb.Cart())
+
+	empty := Gameboy{}
+	assert.Nil(t, empty.Cart())
+}
+
+func TestGameboy_HasBattery(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.False(t, gb.HasBattery())
+
+	gb, err = NewGameboy("./../../roms/mooneye/acceptance/oam_dma/sources-dmgABCmgbS.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.True(t, gb.HasBattery())
+
+	empty := Gameboy{}
+	assert.False(t, empty.HasBattery())
+}
+
+func TestGameboy_ROMHash(t *testing.T) {
+	const file = "./../../roms/blargg/cpu_instrs.gb"
+	rom, err := os.ReadFile(file)
+	require.NoError(t, err)
+	gb, err := NewGameboy(file)
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.Equal(t, sha1.Sum(rom), gb.ROMHash())
+	assert.Equal(t, "CPU_INSTRS", gb.Title())
+
+	empty := Gameboy{}
+	assert.Equal(t, [sha1.Size]byte{}, empty.ROMHash())
+	assert.Equal(t, "", empty.Title())
+}
+
+func TestGameboy_SRAMDirty(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.False(t, gb.SRAMDirty())
+
+	gb.Memory.Write(0x0000, 0x0A)
+	gb.Memory.Write(0xA000, 0x12)
+	assert.True(t, gb.SRAMDirty())
+
+	gb.ExportSRAM()
+	assert.False(t, gb.SRAMDirty())
+
+	empty := Gameboy{}
+	assert.False(t, empty.SRAMDirty())
+}
+
+func TestGameboy_SRAMChecksum(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	checksum := gb.SRAMChecksum()
+	assert.Equal(t, crc32.ChecksumIEEE(make([]byte, 0x8000)), checksum)
+
+	gb.Memory.Write(0x0000, 0x0A)
+	gb.Memory.Write(0xA000, 0x12)
+	assert.NotEqual(t, checksum, gb.SRAMChecksum())
+	assert.True(t, gb.SRAMDirty(), "checksum should not clear the dirty flag")
+	gb.Memory.Write(0xA000, 0x00)
+	assert.Equal(t, checksum, gb.SRAMChecksum())
+
+	empty := Gameboy{}
+	assert.Zero(t, empty.SRAMChecksum())
+}
+
+func TestGameboy_SRAMLoaderSaver(t *testing.T) {
+	loader := bytes.NewReader(bytes.Repeat([]byte{0x12}, 0x8000))
+	saver := &bytes.Buffer{}
+	gb, err := NewGameboy("./../../roms/mooneye/acceptance/oam_dma/sources-dmgABCmgbS.gb",
+		WithSRAMLoader(loader), WithSRAMSaver(saver))
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.Memory.Write(0x0000, 0x0A)
+	assert.Equal(t, byte(0x12), gb.Memory.Read(0xA000))
+	assert.Equal(t, 0, saver.Len())
+
+	gb.Memory.Write(0xA000, 0x34)
+	gb.Close()
+	require.Equal(t, 0x8000, saver.Len())
+	assert.Equal(t, byte(0x34), saver.Bytes()[0])
+	assert.Equal(t, byte(0x12), saver.Bytes()[1])
+}
+
+func TestGameboy_AutoSave(t *testing.T) {
+	saver := &bytes.Buffer{}
+	gb, err := NewGameboy("./../../roms/mooneye/acceptance/oam_dma/sources-dmgABCmgbS.gb",
+		WithSRAMSaver(saver), WithAutoSave())
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.Memory.Write(0x0000, 0x0A)
+	gb.Memory.Write(0xA000, 0x34)
+	assert.Equal(t, 0, saver.Len(), "should not save while the RAM is enabled")
+
+	gb.Memory.Write(0x0000, 0x00)
+	assert.Equal(t, 0, saver.Len(), "should not save while writing to memory")
+
+	// Spin in a loop in WRAM so the rom does not write to the cartridge RAM.
+	gb.Memory.Write(0xC000, 0x18) // JR -2
+	gb.Memory.Write(0xC001, 0xFE)
+	gb.CPU.PC = 0xC000
+	gb.interruptsOn = false
+
+	gb.RunFrames(autoSaveDelay - 1)
+	assert.Equal(t, 0, saver.Len(), "should wait before saving")
+	gb.RunFrames(1)
+	require.Equal(t, 0x8000, saver.Len())
+	assert.Equal(t, byte(0x34), saver.Bytes()[0])
+	assert.False(t, gb.SRAMDirty())
+}
+
+func TestGameboy_RunFrames(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	cycles := gb.RunFrames(10)
+	assert.GreaterOrEqual(t, cycles, 10*CyclesFrame)
+}
+
+func TestGameboy_UpdateN(t *testing.T) {
+	var blank []bool
+	gb, err := NewGameboy("./../../roms/mooneye/runnable/sprite_priority.gb",
+		WithVBlankCallback(func(gb *Gameboy) {
+			blank = append(blank, isBlankFrame(&gb.PreparedData))
+		}))
+	require.NoError(t, err, "error in init gb %v", err)
+	expected, err := NewGameboy("./../../roms/mooneye/runnable/sprite_priority.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	cycles := gb.UpdateN(20)
+	assert.Equal(t, expected.RunFrames(20), cycles)
+	assert.Equal(t, expected.CPU, gb.CPU)
+	assert.Equal(t, expected.PreparedData, gb.PreparedData, "last frame should be drawn")
+	assert.False(t, isBlankFrame(&gb.PreparedData))
+
+	// The frames before the last two should not have been drawn, apart from
+	// the first which is from before any frames were completed.
+	require.Greater(t, len(blank), 3)
+	for i, b := range blank[1 : len(blank)-2] {
+		assert.True(t, b, "frame %v should not be drawn", i+1)
+	}
+
+	// Frames are drawn again after UpdateN.
+	gb.Update()
+	expected.Update()
+	assert.Equal(t, expected.PreparedData, gb.PreparedData)
+}
+
+func TestGameboy_RunUntil(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/mooneye/acceptance/oam_dma/basic.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	err = gb.RunUntil(inFinishLoop, 4000*CyclesFrame)
+	require.NoError(t, err)
+	assert.True(t, passedTest(gb), "registers do not match expected")
+
+	frames := 0
+	err = gb.RunUntil(func(*Gameboy) bool {
+		frames++
+		return false
+	}, 3*CyclesFrame)
+	assert.True(t, errors.Is(err, ErrCycleLimit))
+	assert.Equal(t, 4, frames, "condition should be checked after each frame")
+
+	gb.togglePaused()
+	assert.Error(t, gb.RunUntil(inFinishLoop, CyclesFrame), "should not run while paused")
+}
+
+func TestGameboy_WithSampleRate(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithSampleRate(48000))
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.Equal(t, 48000, gb.Sound.SampleRate())
+
+	gb.ReadAudio(nil)
+	gb.RunFrames(60)
+	buf := make([]float32, 200000)
+	assert.InDelta(t, 48000*2, gb.ReadAudio(buf), 2*48000/60)
+
+	gb.RunFrames(60)
+	assert.InDelta(t, 48000, gb.ReadAudioMono(buf), 48000/60)
+}
+
+func TestGameboy_Run(t *testing.T) {
+	saver := &bytes.Buffer{}
+	gb, err := NewGameboy("./../../roms/mooneye/acceptance/oam_dma/sources-dmgABCmgbS.gb",
+		WithSRAMSaver(saver))
+	require.NoError(t, err, "error in init gb %v", err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	frames := 0
+	err = gb.Run(ctx, func(g *Gameboy) {
+		assert.Same(t, gb, g)
+		frames++
+		if frames == 5 {
+			cancel()
+		}
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 5, frames)
+	assert.Equal(t, 0x8000, saver.Len(), "save data should be flushed")
+}
+
+// BenchmarkRunFrames measures the speed of the emulator running the cpu_instrs
+// rom headless, reporting the number of frames emulated per second. Run with:
+//
+//	go test -run=^$ -bench=RunFrames ./pkg/gb
+func BenchmarkRunFrames(b *testing.B) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(b, err, "error in init gb %v", err)
+
+	b.ResetTimer()
+	gb.RunFrames(b.N)
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "frames/s")
+}
+
+func TestGameboy_Stop(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.Memory.HighRAM[DIV-0xFF00] = 0x12
+
+	executeInstruction(gb, 0x10, 0x00)
+	assert.True(t, gb.stopped)
+	assert.True(t, gb.IsStopped())
+	assert.False(t, gb.IsHalted())
+	assert.Equal(t, uint16(0xC002), gb.CPU.PC, "STOP should consume the following byte")
+	assert.Equal(t, byte(0), gb.Memory.HighRAM[DIV-0xFF00], "DIV should be reset")
+
+	// Running while stopped should not execute anything or clock DIV.
+	gb.Update()
+	assert.Equal(t, uint16(0xC002), gb.CPU.PC)
+	assert.Equal(t, byte(0), gb.Memory.HighRAM[DIV-0xFF00])
+	assert.Equal(t, byte(255), gb.PreparedData[0][0][0], "screen should be blank while stopped")
+
+	// Pressing a button resumes the CPU.
+	gb.pressButton(ButtonA)
+	assert.False(t, gb.stopped)
+	assert.False(t, gb.IsStopped())
+}
+
+func TestGameboy_IsHalted(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.False(t, gb.IsHalted())
+
+	// HALT with interrupts enabled and none pending
+	gb.interruptsOn = true
+	gb.Memory.Write(0xFF0F, 0)
+	executeInstruction(gb, 0x76)
+	assert.True(t, gb.IsHalted())
+	assert.False(t, gb.IsStopped())
+}
+
+func TestGameboy_StepOverOut(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.interruptsOn = false
+	gb.CPU.SP.Set(0xDFF0)
+
+	program := map[uint16][]byte{
+		// CALL 0xC010, CALL NZ,0xC010, NOP
+		0xC000: {0xCD, 0x10, 0xC0, 0xC4, 0x10, 0xC0, 0x00},
+		// INC A, CALL 0xC020, RET
+		0xC010: {0x3C, 0xCD, 0x20, 0xC0, 0xC9},
+		// INC A, RET
+		0xC020: {0x3C, 0xC9},
+		// JR -2
+		0xC030: {0x18, 0xFE},
+	}
+	for address, bytes := range program {
+		for i, b := range bytes {
+			gb.Memory.Write(address+uint16(i), b)
+		}
+	}
+	reset := func(pc uint16, flags byte) {
+		gb.CPU.PC = pc
+		gb.CPU.AF.Set(uint16(flags))
+	}
+
+	// Step over the call and both nested calls
+	reset(0xC000, 0)
+	cycles, err := gb.StepOver()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0xC003), gb.CPU.PC)
+	assert.Equal(t, byte(2), gb.CPU.AF.Hi())
+	assert.Equal(t, uint16(0xDFF0), gb.CPU.SP.HiLo())
+	assert.Greater(t, cycles, 24)
+
+	// A conditional call which is not taken and other instructions step once
+	reset(0xC003, 0x80)
+	_, err = gb.StepOver()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0xC006), gb.CPU.PC)
+	_, err = gb.StepOver()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0xC007), gb.CPU.PC)
+
+	// Step out of the nested call returns to the outer subroutine
+	reset(0xC000, 0)
+	gb.StepInstruction()
+	gb.StepInstruction()
+	gb.StepInstruction()
+	require.Equal(t, uint16(0xC020), gb.CPU.PC)
+	_, err = gb.StepOut()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0xC014), gb.CPU.PC)
+	_, err = gb.StepOut()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(0xC003), gb.CPU.PC)
+	assert.Equal(t, uint16(0xDFF0), gb.CPU.SP.HiLo())
+
+	// A subroutine which never returns
+	reset(0xC030, 0)
+	_, err = gb.StepOut()
+	assert.True(t, errors.Is(err, ErrCycleLimit))
+}
+
+func TestGameboy_DoubleSpeed(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled())
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.False(t, gb.IsDoubleSpeed())
+	assert.Equal(t, byte(0x7E), gb.Memory.Read(0xFF4D))
+
+	// Prepare the speed switch and run STOP to switch speed
+	gb.Memory.Write(0xFF4D, 0x01)
+	assert.Equal(t, byte(0x7F), gb.Memory.Read(0xFF4D))
+	executeInstruction(gb, 0x10, 0x00)
+	assert.True(t, gb.IsDoubleSpeed())
+	assert.False(t, gb.IsStopped())
+	assert.Equal(t, byte(0xFE), gb.Memory.Read(0xFF4D))
+
+	gb.Memory.Write(0xFF4D, 0x01)
+	gb.SetDoubleSpeed(false)
+	assert.False(t, gb.IsDoubleSpeed())
+	assert.Equal(t, byte(0x7E), gb.Memory.Read(0xFF4D), "prepared switch should be cleared")
+	gb.SetDoubleSpeed(true)
+	assert.True(t, gb.IsDoubleSpeed())
+
+	// The speed cannot be changed outside of CGB mode
+	dmg, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	dmg.SetDoubleSpeed(true)
+	assert.False(t, dmg.IsDoubleSpeed())
+	assert.Equal(t, byte(0xFF), dmg.Memory.Read(0xFF4D))
+}
+
+func TestGameboy_LoadROM(t *testing.T) {
+	output := ""
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithTransferFunction(func(val byte) {
+		output += string(val)
+	}))
+	require.NoError(t, err, "error in init gb %v", err)
+	sound := gb.Sound
+	gb.RunFrames(100)
+	require.NotEmpty(t, output)
+
+	err = gb.LoadROM("./../../roms/blargg/instr_timing.gb")
+	require.NoError(t, err)
+	assert.Equal(t, "INSTR_TIMING", gb.Memory.Cart.GetName())
+	assert.Equal(t, uint16(0x100), gb.CPU.PC)
+	assert.Same(t, sound, gb.Sound, "sound output should be kept")
+
+	// The transfer function option should be kept.
+	output = ""
+	gb.RunFrames(100)
+	assert.Contains(t, output, "instr_timing")
+}
+
+func TestGameboy_PowerCycle(t *testing.T) {
+	saver := &bytes.Buffer{}
+	gb, err := NewGameboy("./../../roms/mooneye/acceptance/oam_dma/sources-dmgABCmgbS.gb",
+		WithSRAMSaver(saver),
+		WithMemoryInitPattern(func(address uint16) byte { return byte(address) }))
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.RunFrames(10)
+	gb.Memory.Write(0x0000, 0x0A)
+	gb.Memory.Write(0xA000, 0x42)
+	gb.Memory.Write(0xC123, 0x99)
+	gb.PowerCycle()
+
+	assert.Equal(t, 0x8000, saver.Len(), "save data should be flushed once")
+	assert.Equal(t, uint16(0x100), gb.CPU.PC)
+	assert.Equal(t, byte(0x23), gb.Memory.Read(0xC123), "RAM should be filled with the init pattern")
+	assert.False(t, gb.Memory.Cart.BankState().RamEnabled)
+	assert.Equal(t, byte(0x42), gb.Memory.Cart.GetSaveData()[0], "save data should be kept")
+}
+
+func TestGameboy_PowerCycleKeepsControls(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.SetTurbo(ButtonA, 15)
+	gb.pressButton(ButtonB)
+	gb.togglePaused()
+	gb.PowerCycle()
+
+	assert.True(t, gb.paused, "emulator should stay paused")
+	assert.Equal(t, 15, gb.turboRate[ButtonA])
+	assert.False(t, bits.Test(gb.inputMask, byte(ButtonB)), "held button should stay pressed")
+	assert.True(t, bits.Test(gb.inputMask, byte(ButtonA)))
+}
+
+func TestGameboy_LoadROMErrors(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	assert.Error(t, gb.LoadROM("./../../roms/missing.gb"))
+	assert.Error(t, gb.LoadROMBytes([]byte{0x00}))
+	assert.Equal(t, "CPU_INSTRS", gb.Memory.Cart.GetName(), "game should not change on error")
+}
+
+// Create an empty ROM with a valid header for a cartridge type.
+func testROM(cartType byte) []byte {
+	rom := make([]byte, 0x8000)
+	rom[0x147] = cartType
+	var checksum byte
+	for _, b := range rom[0x134:0x14D] {
+		checksum = checksum - b - 1
+	}
+	rom[0x14D] = checksum
+	return rom
+}
+
+func TestGameboy_LoadROMErrorTypes(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	assert.True(t, errors.Is(gb.LoadROM("./../../roms/missing.gb"), fs.ErrNotExist))
+	assert.True(t, errors.Is(gb.LoadROMBytes([]byte{0x00}), cart.ErrShortROM))
+	assert.True(t, errors.Is(gb.LoadROMBytes(testROM(0x22)), cart.ErrUnsupportedMapper))
+
+	bad := testROM(0x01)
+	bad[0x14D]++
+	assert.True(t, errors.Is(gb.LoadROMBytes(bad), cart.ErrBadHeader))
+	assert.NoError(t, gb.LoadROMBytes(testROM(0x01)))
+
+	_, err = NewGameboy("./../../roms/missing.gb")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestGameboy_SaveStateMismatch(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.RunFrames(10)
+
+	var state bytes.Buffer
+	require.NoError(t, gb.SaveState(&state))
+	saved := state.Bytes()
+
+	other, err := NewGameboy("./../../roms/blargg/instr_timing.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	err = other.LoadState(bytes.NewReader(saved))
+	assert.True(t, errors.Is(err, ErrSaveStateMismatch))
+
+	same, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	require.NoError(t, same.LoadState(bytes.NewReader(saved)))
+	assert.Equal(t, gb.CPU.PC, same.CPU.PC)
+}
+
+func TestGameboy_SaveStateMode(t *testing.T) {
+	dmg, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	cgb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled())
+	require.NoError(t, err, "error in init gb %v", err)
+	require.True(t, cgb.IsCGB())
+
+	var dmgState, cgbState bytes.Buffer
+	require.NoError(t, dmg.SaveState(&dmgState))
+	require.NoError(t, cgb.SaveState(&cgbState))
+
+	assert.True(t, errors.Is(dmg.LoadState(bytes.NewReader(cgbState.Bytes())), ErrSaveStateMode))
+	assert.True(t, errors.Is(cgb.LoadState(bytes.NewReader(dmgState.Bytes())), ErrSaveStateMode))
+	assert.NoError(t, dmg.LoadState(bytes.NewReader(dmgState.Bytes())))
+	assert.NoError(t, cgb.LoadState(bytes.NewReader(cgbState.Bytes())))
+}
+
+func TestGameboy_SaveStateSound(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.Memory.Write(0xFF12, 0xF0)
+	gb.Memory.Write(0xFF14, 0x80)
+
+	var state bytes.Buffer
+	require.NoError(t, gb.SaveState(&state))
+	saved := state.Bytes()
+
+	same, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	require.NoError(t, same.LoadState(bytes.NewReader(saved)))
+	assert.Equal(t, byte(0xF1), same.Memory.Read(0xFF26), "channel 1 should be playing")
+
+	// A state which ends before the sound is not loaded.
+	var soundState bytes.Buffer
+	require.NoError(t, gb.Sound.SaveState(&soundState))
+	truncated := saved[:len(saved)-soundState.Len()]
+	assert.Error(t, same.LoadState(bytes.NewReader(truncated)))
+}
+
+func TestGameboy_StateCodec(t *testing.T) {
+	codec := WithStateCodec(
+		func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		func(r io.Reader) io.Reader {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return iotest.ErrReader(err)
+			}
+			return zr
+		},
+	)
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", codec)
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// Incompressible RAM makes the decoder return short reads. The emulator
+	// is not run, as the divider and scanline counter are not saved, so the
+	// state hashes can be compared.
+	rand.New(rand.NewSource(1)).Read(gb.Memory.WRAM[:])
+
+	var plain, compressed bytes.Buffer
+	require.NoError(t, gb.SaveState(&compressed))
+	gb.options.stateEncoder = nil
+	require.NoError(t, gb.SaveState(&plain))
+	assert.Less(t, compressed.Len(), plain.Len(), "state should be compressed")
+	assert.Equal(t, plain.Bytes()[:6], compressed.Bytes()[:6], "game identifier and mode should not be encoded")
+	assert.NotEqual(t, plain.Bytes()[6], compressed.Bytes()[6], "format should record the state is encoded")
+
+	loaded, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", codec)
+	require.NoError(t, err, "error in init gb %v", err)
+	require.NoError(t, loaded.LoadState(bytes.NewReader(compressed.Bytes())))
+	assert.Equal(t, gb.CPU.PC, loaded.CPU.PC)
+	assert.Equal(t, gb.Memory.WRAM, loaded.Memory.WRAM)
+	assert.Equal(t, gb.StateHash(), loaded.StateHash())
+
+	// The identifier is checked before the state is decoded.
+	other, err := NewGameboy("./../../roms/blargg/instr_timing.gb", codec)
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.True(t, errors.Is(other.LoadState(bytes.NewReader(compressed.Bytes())), ErrSaveStateMismatch))
+	assert.True(t, errors.Is(loaded.LoadState(bytes.NewReader(plain.Bytes())), ErrSaveStateFormat))
+
+	// The format records if the state is encoded and its version.
+	bare, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.True(t, errors.Is(bare.LoadState(bytes.NewReader(compressed.Bytes())), ErrSaveStateFormat))
+	require.NoError(t, bare.LoadState(bytes.NewReader(plain.Bytes())))
+	version := append([]byte{}, plain.Bytes()...)
+	version[6]++
+	assert.True(t, errors.Is(bare.LoadState(bytes.NewReader(version)), ErrSaveStateFormat))
+}
+
+func TestGameboy_SaveStateHalted(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.interruptsOn = false
+	gb.halted = true
+	gb.haltBug = true
+
+	var state bytes.Buffer
+	require.NoError(t, gb.SaveState(&state))
+	loaded, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	require.NoError(t, loaded.LoadState(&state))
+	assert.True(t, loaded.IsHalted())
+	assert.True(t, loaded.haltBug)
+	assert.False(t, loaded.interruptsOn)
+	assert.False(t, loaded.interruptsEnabling)
+}
+
+func TestGameboy_SaveStateSerial(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.serialBits = 5
+	gb.serialCounter = 123
+	gb.serialIn = 0x5A
+
+	var state bytes.Buffer
+	require.NoError(t, gb.SaveState(&state))
+	buf := make([]byte, gb.FastStateSize())
+	require.Equal(t, len(buf), gb.SaveStateFast(buf))
+
+	loaded, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	require.NoError(t, loaded.LoadState(&state))
+	assert.Equal(t, 5, loaded.serialBits)
+	assert.Equal(t, 123, loaded.serialCounter)
+	assert.Equal(t, byte(0x5A), loaded.serialIn)
+
+	fast, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	require.NoError(t, fast.LoadStateFast(buf))
+	assert.Equal(t, 5, fast.serialBits)
+	assert.Equal(t, 123, fast.serialCounter)
+	assert.Equal(t, byte(0x5A), fast.serialIn)
+}
+
+func TestGameboy_UpdateCycleOverflow(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// The total cycles should never drift from the expected frame cycles by
+	// more than the length of a single instruction or interrupt.
+	total := 0
+	for i := 1; i <= 100; i++ {
+		total += gb.Update()
+		assert.InDelta(t, i*CyclesFrame, total, 24, "drift after %v frames", i)
+	}
+}
+
+func TestGameboy_RequestInterrupt(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.Memory.Write(0xFF0F, 0x00)
+
+	require.NoError(t, gb.RequestInterrupt(3))
+	assert.Equal(t, byte(0xE8), gb.Memory.Read(0xFF0F))
+	require.NoError(t, gb.RequestInterrupt(0))
+	assert.Equal(t, byte(0xE9), gb.Memory.Read(0xFF0F))
+
+	assert.Error(t, gb.RequestInterrupt(-1))
+	assert.Error(t, gb.RequestInterrupt(5))
+	assert.Equal(t, byte(0xE9), gb.Memory.Read(0xFF0F))
+}
+
+func TestGameboy_BGAttributes(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled())
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// Write the attributes of tile (2, 1) to VRAM bank 1
+	gb.Memory.Write(0xFF4F, 1)
+	gb.Memory.Write(0x9800+0x20+2, 0xED)
+	gb.Memory.Write(0xFF4F, 0)
+
+	attrs, ok := gb.BGAttributes()
+	require.True(t, ok)
+	attr := attrs[1][2]
+	assert.Equal(t, byte(5), attr.Palette())
+	assert.Equal(t, byte(1), attr.Bank())
+	assert.True(t, attr.XFlip())
+	assert.True(t, attr.YFlip())
+	assert.True(t, attr.Priority())
+	assert.Equal(t, TileAttributes(0), attrs[0][0])
+	assert.Contains(t, gb.BGAttrString(), " 1: 00 00 ed 00")
+
+	dmg, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	_, ok = dmg.BGAttributes()
+	assert.False(t, ok)
+	assert.Equal(t, "", dmg.BGAttrString())
+}
+
+func TestGameboy_TileAt(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled())
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// Tile (3, 2) in the second map has signed tile number -1 and attributes
+	gb.Memory.VRAM[0x1C00+0x40+3] = 0xFF
+	gb.Memory.VRAM[0x3C00+0x40+3] = 0x8A
+
+	gb.Memory.HighRAM[LCDC-0xFF00] = 0x88
+	info := gb.TileAt(3*8+5, 2*8+7)
+	assert.Equal(t, byte(0xFF), info.Index)
+	assert.Equal(t, uint16(0x8FF0), info.DataAddress)
+	assert.Equal(t, TileAttributes(0x8A), info.Attributes)
+
+	// Unsigned tile data and wrapping coordinates
+	gb.Memory.HighRAM[LCDC-0xFF00] = 0x98
+	info = gb.TileAt(256+3*8, -256+2*8)
+	assert.Equal(t, byte(0xFF), info.Index)
+	assert.Equal(t, uint16(0x8FF0), info.DataAddress)
+
+	gb.Memory.VRAM[0x1C00] = 0x01
+	info = gb.TileAt(0, 0)
+	assert.Equal(t, uint16(0x8010), info.DataAddress)
+	gb.Memory.HighRAM[LCDC-0xFF00] = 0x88
+	info = gb.TileAt(0, 0)
+	assert.Equal(t, uint16(0x9010), info.DataAddress)
+}
+
+func TestGameboy_SpriteAt(t *testing.T) {
+	dmg, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	cgb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled())
+	require.NoError(t, err, "error in init gb %v", err)
+
+	for _, gb := range []*Gameboy{dmg, cgb} {
+		gb.Memory.OAM = [0x100]byte{}
+		gb.Memory.HighRAM[LCDC-0xFF00] = 0x82
+
+		// Sprite 1 is at (10, 20) and overlaps sprite 2 at (6, 20)
+		copy(gb.Memory.OAM[4:], []byte{20 + 16, 10 + 8, 0, 0, 20 + 16, 6 + 8, 0, 0})
+	}
+
+	_, ok := dmg.SpriteAt(0, 0)
+	assert.False(t, ok)
+	index, ok := dmg.SpriteAt(11, 27)
+	assert.True(t, ok)
+	assert.Equal(t, 2, index, "sprite with smallest X should be on top on DMG")
+	index, ok = cgb.SpriteAt(11, 27)
+	assert.True(t, ok)
+	assert.Equal(t, 1, index, "first sprite should be on top on CGB")
+
+	// Only 8x16 sprites cover the lower tile
+	_, ok = cgb.SpriteAt(11, 28)
+	assert.False(t, ok)
+	cgb.Memory.HighRAM[LCDC-0xFF00] = 0x86
+	index, ok = cgb.SpriteAt(11, 35)
+	assert.True(t, ok)
+	assert.Equal(t, 1, index)
+}
+
+func TestGameboy_Sprites(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.Memory.OAM = [0x100]byte{}
+	copy(gb.Memory.OAM[4:], []byte{20 + 16, 10 + 8, 0x42, 0xFB})
+	copy(gb.Memory.OAM[39*4:], []byte{0, 0xA8, 0x01, 0x00})
+
+	sprites := gb.Sprites()
+	assert.Equal(t, 40, len(sprites))
+	assert.Equal(t, Sprite{X: -8, Y: -16}, sprites[0])
+	assert.Equal(t, Sprite{
+		X: 10, Y: 20, TileIndex: 0x42,
+		Priority: true, FlipY: true, FlipX: true,
+		Palette: 1, CGBBank: 1, CGBPalette: 3,
+	}, sprites[1])
+	assert.Equal(t, Sprite{X: 0xA0, Y: -16, TileIndex: 0x01}, sprites[39])
+
+	// The sprites are a snapshot which are not changed by writes to OAM
+	gb.Memory.OAM[4] = 0
+	assert.Equal(t, 20, sprites[1].Y)
+}
+
+func TestGameboy_MemoryRegions(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled())
+	require.NoError(t, err, "error in init gb %v", err)
+
+	assert.Equal(t, MemoryRegions{ROMBank: 1, WRAMBank: 1}, gb.MemoryRegions())
+
+	// The cpu_instrs rom uses an MBC1
+	gb.Memory.Write(0x0000, 0x0A)
+	gb.Memory.Write(0x2000, 0x03)
+	gb.Memory.Write(0x4000, 0x02)
+	gb.Memory.Write(0xFF70, 0x05)
+	gb.Memory.Write(0xFF4F, 0x01)
+	assert.Equal(t, MemoryRegions{
+		ROMBank: 0x43, RAMEnabled: true, WRAMBank: 5, VRAMBank: 1,
+	}, gb.MemoryRegions())
+
+	gb.Memory.Write(0x6000, 0x01)
+	assert.Equal(t, MemoryRegions{
+		ROMBank0: 0x40, ROMBank: 0x43, RAMBank: 2, RAMEnabled: true, WRAMBank: 5, VRAMBank: 1,
+	}, gb.MemoryRegions())
+}
+
+func TestGameboy_WithLogger(t *testing.T) {
+	var out bytes.Buffer
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithLogger(log.New(&out, "", 0)))
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.printBGMap()
+	LogOpcode(gb, true)
+	gb.SoundString()
+	assert.Contains(t, out.String(), "BG Map:\n 0: ")
+	assert.Contains(t, out.String(), fmt.Sprintf("%0#4x", gb.CPU.PC))
+	assert.Contains(t, out.String(), "0xFF1A E--- ----")
+}
+
+func TestGameboy_CameraSource(t *testing.T) {
+	black := image.NewGray(image.Rect(0, 0, 128, 112))
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb",
+		WithCameraSource(func() image.Image { return black }))
+	require.NoError(t, err, "error in init gb %v", err)
+
+	require.NoError(t, gb.LoadROMBytes(testROM(0xFC)))
+	_, ok := gb.Cart().(*cart.Camera)
+	require.True(t, ok, "expected camera controller but got %T", gb.Cart())
+
+	// Take a picture with the dither thresholds at their maximum
+	gb.Memory.Write(0x4000, 0x10)
+	for address := uint16(0xA006); address < 0xA036; address++ {
+		gb.Memory.Write(address, 0xFF)
+	}
+	gb.Memory.Write(0xA000, 0x01)
+
+	gb.Memory.Write(0x0000, 0x0A)
+	gb.Memory.Write(0x4000, 0x00)
+	assert.Equal(t, byte(0xFF), gb.Memory.Read(0xA100))
+	assert.Equal(t, byte(0xFF), gb.Memory.Read(0xA101))
+}
+
+func TestGameboy_StackDump(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.CPU.SP.Set(0xDFFE)
+	gb.pushStack(0x1234)
+	gb.pushStack(0xABCD)
+	gb.pushStack(0x0150)
+
+	assert.Equal(t, []uint16{0x0150, 0xABCD, 0x1234}, gb.StackDump(3))
+	assert.Equal(t, []uint16{0x0150}, gb.StackDump(1))
+	assert.Empty(t, gb.StackDump(0))
+	assert.Equal(t, uint16(0xDFF8), gb.CPU.SP.HiLo(), "stack pointer should not change")
+	assert.Equal(t, uint16(0x0150), gb.popStack())
+}
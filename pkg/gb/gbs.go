@@ -0,0 +1,206 @@
+package gb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Humpheh/goboy/pkg/cart"
+)
+
+const (
+	// Length of the header at the start of a GBS file.
+	gbsHeaderLength = 0x70
+
+	// Address of the routine the CPU idles in between calls to the play routine.
+	gbsIdleAddress = 0x80
+)
+
+// GBS is a Gameboy Sound System file, which contains the music code ripped
+// from a game along with the addresses of the routines used to play it.
+type GBS struct {
+	Version   byte
+	SongCount byte
+	// FirstSong is the index of the song which should be played first,
+	// starting from 1.
+	FirstSong byte
+
+	LoadAddress  uint16
+	InitAddress  uint16
+	PlayAddress  uint16
+	StackPointer uint16
+
+	// TimerModulo and TimerControl are the values to load into the TMA and
+	// TAC registers. If the timer is enabled in TimerControl the play routine
+	// is called on the timer interrupt, otherwise it is called on V-Blank.
+	TimerModulo  byte
+	TimerControl byte
+
+	Title     string
+	Author    string
+	Copyright string
+
+	code []byte
+}
+
+// ParseGBS parses the header and code of a GBS file.
+func ParseGBS(data []byte) (*GBS, error) {
+	if len(data) < gbsHeaderLength || string(data[:3]) != "GBS" {
+		return nil, errors.New("not a valid GBS file")
+	}
+	gbs := &GBS{
+		Version:      data[0x03],
+		SongCount:    data[0x04],
+		FirstSong:    data[0x05],
+		LoadAddress:  binary.LittleEndian.Uint16(data[0x06:]),
+		InitAddress:  binary.LittleEndian.Uint16(data[0x08:]),
+		PlayAddress:  binary.LittleEndian.Uint16(data[0x0A:]),
+		StackPointer: binary.LittleEndian.Uint16(data[0x0C:]),
+		TimerModulo:  data[0x0E],
+		TimerControl: data[0x0F],
+		Title:        gbsString(data[0x10:0x30]),
+		Author:       gbsString(data[0x30:0x50]),
+		Copyright:    gbsString(data[0x50:0x70]),
+		code:         data[gbsHeaderLength:],
+	}
+	if gbs.LoadAddress < 0x400 || gbs.LoadAddress >= 0x8000 {
+		return nil, fmt.Errorf("unsupported GBS load address: %#04x", gbs.LoadAddress)
+	}
+	return gbs, nil
+}
+
+// Read a null terminated string from a GBS header field.
+func gbsString(field []byte) string {
+	if i := bytes.IndexByte(field, 0); i >= 0 {
+		field = field[:i]
+	}
+	return strings.TrimSpace(string(field))
+}
+
+// Build a cartridge ROM which contains the GBS code at the load address, along
+// with the routines to call the play routine from the interrupt vectors.
+func (gbs *GBS) rom() []byte {
+	// Size the ROM to a whole number of banks, with at least 2 banks.
+	size := (int(gbs.LoadAddress) + len(gbs.code) + 0x3FFF) &^ 0x3FFF
+	if size < 0x8000 {
+		size = 0x8000
+	}
+	rom := make([]byte, size)
+	copy(rom[gbs.LoadAddress:], gbs.code)
+
+	// RST instructions jump to the same offset from the load address.
+	for rst := uint16(0); rst < 0x40; rst += 8 {
+		rom[rst] = 0xC3 // JP nn
+		binary.LittleEndian.PutUint16(rom[rst+1:], gbs.LoadAddress+rst)
+	}
+
+	// Call the play routine from the V-Blank and timer interrupts.
+	for _, vector := range []uint16{0x40, 0x50} {
+		rom[vector] = 0xCD // CALL nn
+		binary.LittleEndian.PutUint16(rom[vector+1:], gbs.PlayAddress)
+		rom[vector+3] = 0xD9 // RETI
+	}
+
+	// Idle in a loop waiting for interrupts after the init routine returns.
+	copy(rom[gbsIdleAddress:], []byte{
+		0xFB,       // EI
+		0x76,       // HALT
+		0x18, 0xFD, // JR -3
+	})
+	return rom
+}
+
+// GBSPlayer plays the songs in a GBS file. The player runs the code in the
+// file on the Gameboy CPU and outputs the sound through the APU, without
+// running the PPU.
+type GBSPlayer struct {
+	*Gameboy
+	GBS *GBS
+}
+
+// NewGBSPlayer returns a new player for a GBS file, which will start playing
+// the first song in the file.
+func NewGBSPlayer(gbsFile string, opts ...GameboyOption) (*GBSPlayer, error) {
+	data, err := os.ReadFile(gbsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gbs file: %s", err)
+	}
+	return NewGBSPlayerFromBytes(data, opts...)
+}
+
+// NewGBSPlayerFromBytes returns a new player for a GBS file from a byte array,
+// which will start playing the first song in the file.
+func NewGBSPlayerFromBytes(data []byte, opts ...GameboyOption) (*GBSPlayer, error) {
+	gbs, err := ParseGBS(data)
+	if err != nil {
+		return nil, err
+	}
+
+	player := &GBSPlayer{
+		Gameboy: &Gameboy{},
+		GBS:     gbs,
+	}
+	for _, opt := range opts {
+		opt(&player.options)
+	}
+
+	first := int(gbs.FirstSong) - 1
+	if first < 0 || first >= player.SongCount() {
+		first = 0
+	}
+	if err := player.PlaySong(first); err != nil {
+		return nil, err
+	}
+	return player, nil
+}
+
+// SongCount returns the number of songs in the GBS file.
+func (p *GBSPlayer) SongCount() int {
+	return int(p.GBS.SongCount)
+}
+
+// PlaySong resets the player and starts playing a song, where n is the index
+// of the song starting from 0.
+func (p *GBSPlayer) PlaySong(n int) error {
+	if n < 0 || n >= p.SongCount() {
+		return fmt.Errorf("song %v is out of range of the %v songs", n, p.SongCount())
+	}
+
+	p.reset(&cart.Cart{BankingController: cart.NewMBC1(p.GBS.rom())})
+	p.cgbMode = false
+	p.skipPPU = true
+
+	// The cartridge RAM region is available to the rip as extra RAM
+	p.Memory.Cart.WriteROM(0x0000, 0x0A)
+
+	// Set the timer and enable the interrupt used to call the play routine
+	p.WriteIO(TMA, p.GBS.TimerModulo)
+	p.WriteIO(TAC, p.GBS.TimerControl&0x7)
+	p.Memory.Write(0xFF0F, 0)
+	if p.GBS.TimerControl&0x4 != 0 {
+		p.Memory.Write(0xFFFF, 1<<2)
+	} else {
+		p.Memory.Write(0xFFFF, 1<<0)
+	}
+
+	// Call the init routine with the song number in A, which returns into
+	// the idle loop.
+	p.CPU.AF.SetHi(byte(n))
+	p.CPU.SP.Set(p.GBS.StackPointer)
+	p.pushStack(gbsIdleAddress)
+	p.CPU.PC = p.GBS.InitAddress
+	return nil
+}
+
+// Request a V-Blank interrupt at the frame rate in place of the PPU, so the
+// GBS play routine is called once per frame.
+func (gb *Gameboy) updateVBlank(cycles int) {
+	gb.vblankCounter += cycles
+	if gb.vblankCounter >= CyclesFrame*gb.getSpeed() {
+		gb.vblankCounter -= CyclesFrame * gb.getSpeed()
+		gb.requestInterrupt(0)
+	}
+}
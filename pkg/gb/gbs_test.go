@@ -0,0 +1,91 @@
+package gb
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Build a GBS file which stores the song number in 0xC000 on init and counts
+// the number of calls to the play routine in 0xC001.
+func testGBS(timerControl byte) []byte {
+	header := make([]byte, gbsHeaderLength)
+	copy(header, "GBS")
+	header[0x03] = 1
+	header[0x04] = 3
+	header[0x05] = 2
+	binary.LittleEndian.PutUint16(header[0x06:], 0x400)
+	binary.LittleEndian.PutUint16(header[0x08:], 0x400)
+	binary.LittleEndian.PutUint16(header[0x0A:], 0x404)
+	binary.LittleEndian.PutUint16(header[0x0C:], 0xFFFE)
+	header[0x0E] = 0x00
+	header[0x0F] = timerControl
+	copy(header[0x10:], "Title")
+	copy(header[0x30:], "Author")
+	copy(header[0x50:], "Copyright")
+
+	code := []byte{
+		0xEA, 0x00, 0xC0, // LD (0xC000),A
+		0xC9,             // RET
+		0x21, 0x01, 0xC0, // LD HL,0xC001
+		0x34, // INC (HL)
+		0xC9, // RET
+	}
+	return append(header, code...)
+}
+
+func TestParseGBS(t *testing.T) {
+	gbs, err := ParseGBS(testGBS(0))
+	require.NoError(t, err)
+	assert.Equal(t, byte(3), gbs.SongCount)
+	assert.Equal(t, byte(2), gbs.FirstSong)
+	assert.Equal(t, uint16(0x404), gbs.PlayAddress)
+	assert.Equal(t, "Title", gbs.Title)
+	assert.Equal(t, "Author", gbs.Author)
+	assert.Equal(t, "Copyright", gbs.Copyright)
+
+	_, err = ParseGBS([]byte("GBX"))
+	assert.Error(t, err)
+}
+
+func TestGBSPlayer(t *testing.T) {
+	player, err := NewGBSPlayerFromBytes(testGBS(0))
+	require.NoError(t, err)
+	assert.Equal(t, 3, player.SongCount())
+
+	// The first song in the header should be played on creation.
+	player.RunFrames(1)
+	assert.Equal(t, byte(1), player.Memory.Read(0xC000))
+
+	// The play routine should be called once per frame from V-Blank.
+	require.NoError(t, player.PlaySong(2))
+	player.RunFrames(10)
+	assert.Equal(t, byte(2), player.Memory.Read(0xC000))
+	assert.InDelta(t, 10, int(player.Memory.Read(0xC001)), 1)
+
+	// The PPU should not run, so LY is never updated.
+	assert.Equal(t, byte(0), player.Memory.Read(0xFF44))
+
+	assert.Error(t, player.PlaySong(3))
+}
+
+func TestGBSPlayer_Timer(t *testing.T) {
+	// Timer enabled at 4096Hz with a modulo of 0, so overflows at 16Hz.
+	player, err := NewGBSPlayerFromBytes(testGBS(0x04))
+	require.NoError(t, err)
+
+	player.RunFrames(60)
+	assert.InDelta(t, 16, int(player.Memory.Read(0xC001)), 1)
+}
+
+func TestGBS_ROM(t *testing.T) {
+	gbs, err := ParseGBS(testGBS(0))
+	require.NoError(t, err)
+	rom := gbs.rom()
+	assert.Len(t, rom, 0x8000)
+	assert.Equal(t, []byte{0xC3, 0x08, 0x04}, rom[0x08:0x0B], "RST 08 should jump to the load address")
+	assert.Equal(t, []byte{0xCD, 0x04, 0x04, 0xD9}, rom[0x40:0x44], "V-Blank should call play")
+	assert.Equal(t, byte(0xEA), rom[0x400])
+}
@@ -0,0 +1,147 @@
+package gb
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// GIFOptions are the options for recording the screen with StartGIF.
+type GIFOptions struct {
+	// FPS is the number of frames to record each second. Frames are dropped
+	// evenly to reach this rate. If zero every frame is recorded.
+	FPS float64
+	// Scale is the integer factor to upscale each frame by. If zero the frames
+	// are recorded at the native resolution.
+	Scale int
+}
+
+// ErrNotRecordingGIF is returned by StopGIF if no recording was started.
+var ErrNotRecordingGIF = errors.New("not recording a gif")
+
+// Recording of the frames of the screen which are written as a GIF when the
+// recording is stopped.
+type gifRecorder struct {
+	w     io.Writer
+	fps   float64
+	scale int
+	anim  gif.GIF
+
+	// Accumulators for which frames to record and the delay of each frame in
+	// 100ths of a second, so that rounding does not make the motion uneven.
+	frameCounter float64
+	elapsed      float64
+	delayTotal   int
+}
+
+// StartGIF starts recording the screen after each frame. The GIF is written to
+// w when the recording is stopped with StopGIF. An error is returned if the
+// options are not valid or a recording has already been started.
+func (gb *Gameboy) StartGIF(w io.Writer, opts GIFOptions) error {
+	if gb.gif != nil {
+		return errors.New("already recording a gif")
+	}
+	if opts.FPS < 0 || opts.FPS > FramesSecond {
+		return fmt.Errorf("invalid gif frame rate %v, must be at most %v", opts.FPS, FramesSecond)
+	}
+	if opts.Scale < 0 {
+		return fmt.Errorf("invalid gif scale factor %v, must be positive", opts.Scale)
+	}
+
+	rec := &gifRecorder{
+		w:     w,
+		fps:   opts.FPS,
+		scale: opts.Scale,
+	}
+	if rec.fps == 0 {
+		rec.fps = FramesSecond
+	}
+	if rec.scale == 0 {
+		rec.scale = 1
+	}
+	// Start the counter so that the first frame is always recorded
+	rec.frameCounter = FramesSecond - rec.fps
+	gb.gif = rec
+	return nil
+}
+
+// StopGIF stops recording the screen and writes the recorded frames as a GIF.
+// ErrNotRecordingGIF is returned if StartGIF was not called.
+func (gb *Gameboy) StopGIF() error {
+	rec := gb.gif
+	if rec == nil {
+		return ErrNotRecordingGIF
+	}
+	gb.gif = nil
+	if len(rec.anim.Image) == 0 {
+		return errors.New("no frames were recorded to the gif")
+	}
+	return gif.EncodeAll(rec.w, &rec.anim)
+}
+
+// Record the current frame if it is due to be recorded. Each frame adds the
+// target rate to a counter and a frame is recorded each time the counter
+// passes the native rate, which spreads the dropped frames evenly.
+func (gb *Gameboy) recordGIFFrame() {
+	rec := gb.gif
+	rec.frameCounter += rec.fps
+	if rec.frameCounter < FramesSecond {
+		return
+	}
+	rec.frameCounter -= FramesSecond
+
+	rec.elapsed += 100 / rec.fps
+	delay := int(rec.elapsed+0.5) - rec.delayTotal
+	rec.delayTotal += delay
+
+	rec.anim.Image = append(rec.anim.Image, gb.palettedFrame(rec.scale))
+	rec.anim.Delay = append(rec.anim.Delay, delay)
+}
+
+// Convert the current frame into a paletted image scaled by an integer factor.
+// The palette is made up of the colours in the frame, as there are rarely more
+// than 256. If there are more the frame is dithered to a standard palette.
+func (gb *Gameboy) palettedFrame(scale int) *image.Paletted {
+	frame := gb.GetFrame()
+	bounds := image.Rect(0, 0, ScreenWidth*scale, ScreenHeight*scale)
+
+	indexes := make(map[[3]uint8]uint8)
+	var pal color.Palette
+	for x := 0; x < ScreenWidth; x++ {
+		for y := 0; y < ScreenHeight; y++ {
+			col := frame[x][y]
+			if _, ok := indexes[col]; ok {
+				continue
+			}
+			if len(pal) == 256 {
+				scaled, _ := gb.GetFrameScaled(scale)
+				img := image.NewPaletted(bounds, palette.Plan9)
+				draw.FloydSteinberg.Draw(img, bounds, scaled, image.Point{})
+				return img
+			}
+			indexes[col] = uint8(len(pal))
+			pal = append(pal, color.RGBA{R: col[0], G: col[1], B: col[2], A: 0xFF})
+		}
+	}
+
+	img := image.NewPaletted(bounds, pal)
+	for y := 0; y < ScreenHeight; y++ {
+		// Write the first row of the scaled pixels and copy it to the others
+		row := img.Pix[y*scale*img.Stride : (y*scale+1)*img.Stride]
+		for x := 0; x < ScreenWidth; x++ {
+			index := indexes[frame[x][y]]
+			for i := x * scale; i < (x+1)*scale; i++ {
+				row[i] = index
+			}
+		}
+		for i := 1; i < scale; i++ {
+			copy(img.Pix[(y*scale+i)*img.Stride:], row)
+		}
+	}
+	return img
+}
@@ -0,0 +1,74 @@
+package gb
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGameboy_GIF(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   GIFOptions
+		frames int
+		size   image.Rectangle
+		delays []int
+	}{
+		{"native", GIFOptions{}, 60, image.Rect(0, 0, ScreenWidth, ScreenHeight), []int{2, 1, 2}},
+		{"30fps", GIFOptions{FPS: 30}, 30, image.Rect(0, 0, ScreenWidth, ScreenHeight), []int{3, 4, 3}},
+		{"20fps scaled", GIFOptions{FPS: 20, Scale: 2}, 20, image.Rect(0, 0, ScreenWidth*2, ScreenHeight*2), []int{5, 5, 5}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gb, err := NewGameboy("./../../roms/mooneye/runnable/sprite_priority.gb")
+			require.NoError(t, err, "error in init gb %v", err)
+
+			var buf bytes.Buffer
+			require.NoError(t, gb.StartGIF(&buf, test.opts))
+			gb.RunFrames(60)
+			require.NoError(t, gb.StopGIF())
+
+			anim, err := gif.DecodeAll(&buf)
+			require.NoError(t, err)
+			require.Equal(t, test.frames, len(anim.Image))
+			assert.Equal(t, test.size, anim.Image[0].Bounds())
+			assert.Equal(t, test.delays, anim.Delay[:3])
+
+			// The delays should add up to the second which was recorded
+			total := 0
+			for _, delay := range anim.Delay {
+				total += delay
+			}
+			assert.Equal(t, 100, total)
+
+			// The last frame should match the screen
+			img := anim.Image[len(anim.Image)-1]
+			scale := test.size.Dx() / ScreenWidth
+			for _, p := range []image.Point{{0, 0}, {50, 20}, {159, 143}, {80, 100}} {
+				r, g, b, _ := img.At(p.X*scale, p.Y*scale).RGBA()
+				col := gb.PreparedData[p.X][p.Y]
+				assert.Equal(t, col, [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}, "unexpected colour for pixel %v", p)
+			}
+		})
+	}
+}
+
+func TestGameboy_GIFErrors(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/mooneye/runnable/sprite_priority.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	assert.True(t, errors.Is(gb.StopGIF(), ErrNotRecordingGIF))
+	assert.Error(t, gb.StartGIF(&bytes.Buffer{}, GIFOptions{FPS: -1}))
+	assert.Error(t, gb.StartGIF(&bytes.Buffer{}, GIFOptions{FPS: 61}))
+	assert.Error(t, gb.StartGIF(&bytes.Buffer{}, GIFOptions{Scale: -2}))
+
+	require.NoError(t, gb.StartGIF(&bytes.Buffer{}, GIFOptions{}))
+	assert.Error(t, gb.StartGIF(&bytes.Buffer{}, GIFOptions{}))
+	assert.Error(t, gb.StopGIF(), "no frames have been recorded")
+	assert.True(t, errors.Is(gb.StopGIF(), ErrNotRecordingGIF))
+}
@@ -0,0 +1,65 @@
+package gb
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/Humpheh/goboy/pkg/bits"
+)
+
+// RP is the CGB infrared communications port register.
+const RP IORegister = 0xFF56
+
+// infraredPort connects the infrared port to another device. Each time the
+// LED is turned on or off a byte is written to the peer, which is 1 if it is
+// on and 0 if it is off, and the peer sends bytes in the same way for its LED.
+type infraredPort struct {
+	peer io.ReadWriter
+	// If the LED of the peer is on, which is updated as bytes are received.
+	signal atomic.Bool
+}
+
+// Create an infrared port for a peer and start receiving from it.
+func newInfraredPort(peer io.ReadWriter) *infraredPort {
+	port := &infraredPort{peer: peer}
+	go port.receive()
+	return port
+}
+
+// Receive the state of the LED of the peer until reading from it fails, after
+// which there is no signal.
+func (port *infraredPort) receive() {
+	buf := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(port.peer, buf); err != nil {
+			port.signal.Store(false)
+			return
+		}
+		port.signal.Store(buf[0] != 0)
+	}
+}
+
+// Write to the infrared port register. Bit 0 turns the LED on and bits 6 and
+// 7 enable reading the signal. When the LED is turned on or off the peer is
+// sent the new state. Errors writing to the peer are ignored, as the light is
+// simply not seen.
+func (gb *Gameboy) writeInfrared(value byte) {
+	rp := &gb.Memory.HighRAM[RP-0xFF00]
+	led := bits.Test(value, 0)
+	if gb.infrared != nil && led != bits.Test(*rp, 0) {
+		_, _ = gb.infrared.peer.Write([]byte{bits.B(led)})
+	}
+	*rp = value & 0xC1
+}
+
+// Read the infrared port register. Bit 1 is reset while a signal is being
+// received and reading is enabled, and the unused bits read as 1. Without a
+// peer there is never a signal.
+func (gb *Gameboy) readInfrared() byte {
+	rp := gb.Memory.HighRAM[RP-0xFF00]
+	value := rp | 0x3E
+	if rp&0xC0 == 0xC0 && gb.infrared != nil && gb.infrared.signal.Load() {
+		value = bits.Reset(value, 1)
+	}
+	return value
+}
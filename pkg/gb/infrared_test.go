@@ -0,0 +1,64 @@
+package gb
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfrared_NoPeer(t *testing.T) {
+	dmg, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	dmg.WriteIO(RP, 0xC1)
+	assert.Equal(t, byte(0xFF), dmg.ReadIO(RP), "RP is not mapped on DMG")
+
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled())
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.Equal(t, byte(0x3E), gb.ReadIO(RP))
+	gb.WriteIO(RP, 0xFF)
+	assert.Equal(t, byte(0xFF), gb.ReadIO(RP), "there should be no signal")
+	gb.WriteIO(RP, 0x00)
+	assert.Equal(t, byte(0x3E), gb.ReadIO(RP))
+}
+
+func TestInfrared_Peer(t *testing.T) {
+	received, send := io.Pipe()
+	var sent bytes.Buffer
+	peer := struct {
+		io.Reader
+		io.Writer
+	}{received, &sent}
+
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled(), WithInfraredPeer(peer))
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// The peer is only sent changes to the LED.
+	gb.WriteIO(RP, 0x01)
+	gb.WriteIO(RP, 0x01)
+	gb.WriteIO(RP, 0xC0)
+	assert.Equal(t, []byte{1, 0}, sent.Bytes())
+
+	// The signal is only read while reading is enabled.
+	_, err = send.Write([]byte{1})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return gb.ReadIO(RP) == 0xFC
+	}, time.Second, time.Millisecond, "signal should be received")
+	gb.WriteIO(RP, 0x00)
+	assert.Equal(t, byte(0x3E), gb.ReadIO(RP))
+
+	// The connection is kept when the Gameboy is reset.
+	gb.PowerCycle()
+	gb.WriteIO(RP, 0xC0)
+	_, err = send.Write([]byte{0})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return gb.ReadIO(RP) == 0xFE
+	}, time.Second, time.Millisecond, "signal should stop")
+
+	require.NoError(t, send.Close())
+}
@@ -67,8 +67,26 @@ func (gb *Gameboy) pressButton(button Button) {
 		return
 	}
 
+	gb.heldMask = bits.Reset(gb.heldMask, byte(button))
 	gb.inputMask = bits.Reset(gb.inputMask, byte(button))
 	gb.requestInterrupt(4) // Request the joypad interrupt
+
+	// The joypad line going low resumes the CPU if it is stopped.
+	if gb.stopped && gb.stopWake(button) {
+		gb.stopped = false
+	}
+}
+
+// Returns if pressing a button should resume the CPU from STOP. On hardware
+// this happens when the line of the button is selected in the joypad register,
+// so it reads low, unless this is overridden with WithStopWakeCallback.
+func (gb *Gameboy) stopWake(button Button) bool {
+	lines := gb.joypadValue(gb.Memory.HighRAM[0x00])
+	wake := !bits.Test(lines, byte(button)%4)
+	if callback := gb.options.stopWakeCallback; callback != nil {
+		return callback(button, wake)
+	}
+	return wake
 }
 
 // releaseButton notifies the GameBoy that a button has just been released.
@@ -77,9 +95,84 @@ func (gb *Gameboy) releaseButton(button Button) {
 		return
 	}
 
+	gb.heldMask = bits.Set(gb.heldMask, byte(button))
 	gb.inputMask = bits.Set(gb.inputMask, byte(button))
 }
 
+// SetNextInput sets the buttons which are held down from the start of the next
+// frame, where bit n of pressed is set if Button n is held. The input is only
+// applied at the start of a frame, so it does not change while a frame is being
+// run. This is safe to call from a different goroutine to the one running the
+// emulator. If it is called more than once before the next frame, only the
+// last input is used.
+func (gb *Gameboy) SetNextInput(pressed byte) {
+	gb.nextInput.Store(uint32(pressed) | 0x100)
+}
+
+// Apply the input staged by SetNextInput. This is called at the start of each
+// frame.
+func (gb *Gameboy) latchInput() {
+	next := gb.nextInput.Swap(0)
+	if next&0x100 == 0 {
+		return
+	}
+	for button := ButtonA; button <= ButtonDown; button++ {
+		held := !bits.Test(gb.heldMask, byte(button))
+		switch pressed := bits.Test(byte(next), byte(button)); {
+		case pressed && !held:
+			gb.pressButton(button)
+		case !pressed && held:
+			gb.releaseButton(button)
+		}
+	}
+}
+
+// SetTurbo enables turbo for a button, so that while it is held it will be
+// repeatedly pressed and released at a rate of hz presses per second. The rate
+// is limited to half of the frame rate. Setting hz to 0 disables turbo.
+func (gb *Gameboy) SetTurbo(button Button, hz int) {
+	if !button.IsGameBoyButton() {
+		return
+	}
+	if hz > FramesSecond/2 {
+		hz = FramesSecond / 2
+	}
+	if hz < 0 {
+		hz = 0
+	}
+	gb.turboRate[button] = hz
+
+	// Restore the button to the held state when turbo is turned off
+	if hz == 0 {
+		if bits.Test(gb.heldMask, byte(button)) {
+			gb.inputMask = bits.Set(gb.inputMask, byte(button))
+		} else {
+			gb.inputMask = bits.Reset(gb.inputMask, byte(button))
+		}
+	}
+}
+
+// Press and release the held buttons which have turbo enabled. This is called
+// at the start of each frame.
+func (gb *Gameboy) updateTurbo() {
+	gb.turboFrame++
+	for i, rate := range gb.turboRate {
+		button := byte(i)
+		if rate == 0 || bits.Test(gb.heldMask, button) {
+			continue
+		}
+		// Each press lasts for the first half of the turbo period
+		if (gb.turboFrame*rate*2/FramesSecond)%2 == 0 {
+			if bits.Test(gb.inputMask, button) {
+				gb.inputMask = bits.Reset(gb.inputMask, button)
+				gb.requestInterrupt(4)
+			}
+		} else {
+			gb.inputMask = bits.Set(gb.inputMask, button)
+		}
+	}
+}
+
 func (gb *Gameboy) ProcessInput(buttons ButtonInput) {
 
 	for _, button := range buttons.Pressed {
@@ -0,0 +1,118 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/Humpheh/goboy/pkg/bits"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetTurbo(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	isPressed := func(button Button) bool {
+		return !bits.Test(gb.inputMask, byte(button))
+	}
+
+	// At 15Hz the button is pressed for 2 frames and released for 2 frames.
+	gb.SetTurbo(ButtonA, 15)
+	gb.pressButton(ButtonA)
+	gb.pressButton(ButtonB)
+
+	var pressed []bool
+	for i := 0; i < 8; i++ {
+		gb.Update()
+		pressed = append(pressed, isPressed(ButtonA))
+		assert.True(t, isPressed(ButtonB), "button without turbo should stay pressed")
+	}
+	assert.Equal(t, []bool{true, false, false, true, true, false, false, true}, pressed)
+
+	// Releasing the button stops the turbo.
+	gb.releaseButton(ButtonA)
+	for i := 0; i < 4; i++ {
+		gb.Update()
+		assert.False(t, isPressed(ButtonA))
+	}
+
+	// Disabling turbo returns to a normal hold.
+	gb.pressButton(ButtonA)
+	gb.SetTurbo(ButtonA, 0)
+	for i := 0; i < 4; i++ {
+		gb.Update()
+		assert.True(t, isPressed(ButtonA))
+	}
+}
+
+func TestSetNextInput(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	isPressed := func(button Button) bool {
+		return !bits.Test(gb.inputMask, byte(button))
+	}
+
+	// The input is not applied until the next frame
+	gb.SetNextInput(1<<ButtonA | 1<<ButtonDown)
+	assert.False(t, isPressed(ButtonA))
+	gb.Update()
+	assert.True(t, isPressed(ButtonA))
+	assert.True(t, isPressed(ButtonDown))
+	assert.False(t, isPressed(ButtonB))
+
+	// Only the last input before the frame is used
+	gb.SetNextInput(1 << ButtonB)
+	gb.SetNextInput(1 << ButtonStart)
+	gb.Update()
+	assert.False(t, isPressed(ButtonA))
+	assert.False(t, isPressed(ButtonDown))
+	assert.False(t, isPressed(ButtonB))
+	assert.True(t, isPressed(ButtonStart))
+
+	// The input is kept while there is no new input
+	gb.Update()
+	assert.True(t, isPressed(ButtonStart))
+}
+
+func TestStopWake(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// Select only the direction keys.
+	gb.Memory.Write(0xFF00, 0x20)
+	executeInstruction(gb, 0x10, 0x00)
+	require.True(t, gb.stopped)
+
+	gb.pressButton(ButtonA)
+	assert.True(t, gb.stopped, "unselected button should not resume the CPU")
+	gb.releaseButton(ButtonA)
+
+	gb.pressButton(ButtonRight)
+	assert.False(t, gb.stopped, "selected button should resume the CPU")
+}
+
+func TestStopWakeCallback(t *testing.T) {
+	var calls []Button
+	var wakes []bool
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithStopWakeCallback(func(button Button, wake bool) bool {
+		calls = append(calls, button)
+		wakes = append(wakes, wake)
+		return button == ButtonStart
+	}))
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// Select only the action buttons.
+	gb.Memory.Write(0xFF00, 0x10)
+	executeInstruction(gb, 0x10, 0x00)
+
+	gb.pressButton(ButtonA)
+	assert.True(t, gb.stopped, "callback should be able to stop the wake")
+	gb.pressButton(ButtonStart)
+	assert.False(t, gb.stopped)
+
+	// The callback is not used when the CPU is running.
+	gb.pressButton(ButtonB)
+	assert.Equal(t, []Button{ButtonA, ButtonStart}, calls)
+	assert.Equal(t, []bool{true, true}, wakes)
+}
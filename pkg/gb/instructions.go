@@ -1,6 +1,8 @@
 package gb
 
-import "log"
+import (
+	"github.com/Humpheh/goboy/pkg/debug"
+)
 
 // OpcodeCycles is the number of cpu cycles for each normal opcode.
 var OpcodeCycles = []int{
@@ -42,19 +44,107 @@ var CBOpcodeCycles = []int{
 	2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2, // F
 } //0  1  2  3  4  5  6  7  8  9  a  b  c  d  e  f
 
+// BranchOpcodeCycles is the number of extra cpu cycles taken by each
+// conditional jump, call and return opcode when the condition is met.
+var BranchOpcodeCycles = map[byte]int{
+	0x20: 1, 0x28: 1, 0x30: 1, 0x38: 1, // JR cc,n
+	0xC2: 1, 0xCA: 1, 0xD2: 1, 0xDA: 1, // JP cc,nn
+	0xC4: 3, 0xCC: 3, 0xD4: 3, 0xDC: 3, // CALL cc,nn
+	0xC0: 3, 0xC8: 3, 0xD0: 3, 0xD8: 3, // RET cc
+}
+
+// InstructionCycles returns the number of cpu cycles taken to execute an
+// opcode, where each cycle is 4 clock cycles. If cb is true then the opcode
+// is from the CB prefixed table, and the cycles include reading the prefix.
+// For conditional jumps, calls and returns branchTaken selects the cycles
+// for when the condition is met.
+func InstructionCycles(opcode byte, cb bool, branchTaken bool) int {
+	if cb {
+		return CBOpcodeCycles[opcode]
+	}
+	cycles := OpcodeCycles[opcode]
+	if branchTaken {
+		cycles += BranchOpcodeCycles[opcode]
+	}
+	return cycles
+}
+
+// Instruction is an instruction which has been executed by the CPU.
+type Instruction struct {
+	// PC is the address of the opcode of the instruction.
+	PC uint16
+	// Opcode of the instruction, which is 0xCB for CB prefixed instructions.
+	Opcode byte
+
+	operands     [2]byte
+	operandCount int
+}
+
+// Operands returns the bytes read after the opcode by the instruction. For CB
+// prefixed instructions the first operand is the CB opcode.
+func (inst Instruction) Operands() []byte {
+	return inst.operands[:inst.operandCount]
+}
+
+// String returns the name of the instruction.
+func (inst Instruction) String() string {
+	return debug.GetOpcodeName(inst.Opcode, inst.operands[0])
+}
+
+// LastInstruction returns the last instruction which was executed by the CPU.
+func (gb *Gameboy) LastInstruction() Instruction {
+	return gb.lastInstruction
+}
+
 // ExecuteNextOpcode gets the value at the current PC address, increments the PC,
 // updates the CPU ticks and executes the opcode.
 func (gb *Gameboy) ExecuteNextOpcode() int {
+	pc := gb.CPU.PC
 	opcode := gb.popPC()
+	if gb.haltBug {
+		gb.haltBug = false
+		gb.CPU.PC--
+	}
+	gb.lastInstruction = Instruction{PC: pc, Opcode: opcode}
 	gb.thisCpuTicks = OpcodeCycles[opcode] * 4
 	instructions[opcode](gb)
+	if gb.opcodeCoverage != nil {
+		gb.recordCoverage()
+	}
 	return gb.thisCpuTicks
 }
 
-// Read the value at the PC and increment the PC.
+// Record the last instruction as executed in the opcode coverage.
+func (gb *Gameboy) recordCoverage() {
+	inst := gb.lastInstruction
+	gb.opcodeCoverage[inst.Opcode] = true
+	if inst.Opcode == 0xCB {
+		gb.opcodeCoverage[0x100+int(inst.operands[0])] = true
+	}
+}
+
+// OpcodeCoverage returns which opcodes have been executed since the Gameboy was
+// created or reset. The first 0x100 values are the opcodes and the next 0x100
+// are the CB prefixed opcodes, so a CB opcode is at 0x100 plus the opcode. The
+// 0xCB prefix is marked as executed along with the CB opcode.
+// Coverage is only recorded when the WithOpcodeCoverage option is used,
+// otherwise no opcodes are marked as executed.
+func (gb *Gameboy) OpcodeCoverage() [0x200]bool {
+	if gb.opcodeCoverage == nil {
+		return [0x200]bool{}
+	}
+	return *gb.opcodeCoverage
+}
+
+// Read the value at the PC and increment the PC. The value is recorded as an
+// operand of the instruction being executed.
 func (gb *Gameboy) popPC() byte {
 	opcode := gb.Memory.Read(gb.CPU.PC)
 	gb.CPU.PC++
+	if inst := &gb.lastInstruction; inst.operandCount < len(inst.operands) {
+		inst.operands[inst.operandCount] = opcode
+		inst.operandCount++
+	}
 	return opcode
 }
 
@@ -71,7 +161,7 @@ func init() {
 		if v == nil {
 			opcode := k
 			instructions[k] = func(gb *Gameboy) {
-				log.Printf("Unimplemented opcode: %#2x", opcode)
+				gb.logf("Unimplemented opcode: %#2x\n", opcode)
 				WaitForInput()
 			}
 		}
@@ -935,13 +1025,15 @@ var instructions = [0x100]func(*Gameboy){
 		// DAA
 
 		// When this instruction is executed, the A register is BCD
-		// corrected using the contents of the flags. The exact process
-		// is the following: if the least significant four bits of A
-		// contain a non-BCD digit (i. e. it is greater than 9) or the
-		// H flag is set, then 0x60 is added to the register. Then the
-		// four most significant bits are checked. If this more significant
-		// digit also happens to be greater than 9 or the C flag is set,
-		// then 0x60 is added.
+		// corrected using the contents of the flags. After an addition
+		// (N flag reset), 0x60 is added to the register if it is greater
+		// than 0x99 or the C flag is set, which also sets the C flag. Then
+		// if the least significant four bits contain a non-BCD digit (i.e.
+		// it is greater than 9) or the H flag is set, 0x06 is added.
+		//
+		// After a subtraction (N flag set), only the H and C flags are
+		// used, subtracting 0x06 and 0x60 respectively. The C flag is
+		// left unchanged. In all cases the H flag is reset.
 		if !gb.CPU.N() {
 			if gb.CPU.C() || gb.CPU.AF.Hi() > 0x99 {
 				gb.CPU.AF.SetHi(gb.CPU.AF.Hi() + 0x60)
@@ -985,28 +1077,59 @@ var instructions = [0x100]func(*Gameboy){
 	},
 	0x76: func(gb *Gameboy) {
 		// HALT
-		gb.halted = true
+		pending := gb.Memory.HighRAM[0x0F]&gb.Memory.HighRAM[0xFF]&0x1F != 0
+		switch {
+		case !pending:
+			// Wait for the next interrupt
+			gb.halted = true
+		case gb.interruptsOn && gb.interruptsJustEnabled:
+			// If interrupts have just been enabled by EI the interrupt is
+			// serviced straight away and returns to the HALT, which is then
+			// run again to wait for the next interrupt.
+			gb.CPU.PC--
+		case gb.interruptsOn:
+			// The CPU does not halt and the interrupt is serviced after
+			// the HALT.
+		default:
+			// The HALT bug: with interrupts disabled the CPU does not halt,
+			// and fails to increment the PC after reading the next byte, so
+			// it is read twice.
+			gb.haltBug = true
+		}
 	},
 	0x10: func(gb *Gameboy) {
 		// STOP
-		gb.halted = true
-		if gb.IsCGB() {
-			// Handle switching to double speed mode
-			gb.checkSpeedSwitch()
-		}
 
 		// Pop the next value as the STOP instruction is 2 bytes long. The second value
 		// can be ignored, although generally it is expected to be 0x00 and any other
 		// value is counted as a corrupted STOP instruction.
 		gb.popPC()
+
+		// The divider is reset when entering STOP.
+		gb.setDivider(0)
+		gb.CPU.Divider = 0
+
+		if gb.IsCGB() && gb.prepareSpeed {
+			// Handle switching to double speed mode
+			gb.checkSpeedSwitch()
+			return
+		}
+
+		// Otherwise stop the CPU until a joypad line goes low.
+		gb.stopped = true
 	},
 	0xF3: func(gb *Gameboy) {
-		// DI
+		// DI, which also cancels an EI which has not taken effect yet
 		gb.interruptsOn = false
+		gb.interruptsEnabling = false
 	},
 	0xFB: func(gb *Gameboy) {
-		// EI
-		gb.interruptsEnabling = true
+		// EI, which enables interrupts after the next instruction. If they
+		// are already enabled then the delay is not restarted, so an
+		// interrupt can be serviced straight after a sequence of EIs.
+		if !gb.interruptsOn {
+			gb.interruptsEnabling = true
+		}
 	},
 	0x07: func(gb *Gameboy) {
 		// RLCA
@@ -1238,9 +1361,9 @@ var instructions = [0x100]func(*Gameboy){
 		}
 	},
 	0xD9: func(gb *Gameboy) {
-		// RETI
+		// RETI, unlike EI the interrupts are enabled immediately
 		gb.instRet()
-		gb.interruptsEnabling = true
+		gb.interruptsOn = true
 	},
 	0xCB: func(gb *Gameboy) {
 		// CB
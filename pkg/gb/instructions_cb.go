@@ -168,3 +168,12 @@ func (gb *Gameboy) cbInstructions() [0x100]func() {
 
 	return instructions
 }
+
+// ExecuteCB executes a single CB prefixed opcode against the current state of
+// the CPU and memory, and returns the number of clock cycles it takes including
+// the prefix. The opcode is not read from memory and the PC is not changed, so
+// this can be used to test each of the CB instructions in isolation.
+func (gb *Gameboy) ExecuteCB(opcode byte) int {
+	gb.cbInst[opcode]()
+	return CBOpcodeCycles[opcode] * 4
+}
@@ -0,0 +1,65 @@
+package gb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteCB(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	tests := []struct {
+		name     string
+		opcode   byte
+		value    byte
+		flags    byte
+		expected byte
+		expFlags byte
+	}{
+		{name: "RLC", opcode: 0x00, value: 0x85, expected: 0x0B, expFlags: 0x10},
+		{name: "RLC zero", opcode: 0x00, value: 0x00, flags: 0x70, expected: 0x00, expFlags: 0x80},
+		{name: "RRC", opcode: 0x08, value: 0x01, expected: 0x80, expFlags: 0x10},
+		{name: "RL", opcode: 0x10, value: 0x80, expected: 0x00, expFlags: 0x90},
+		{name: "RL carry in", opcode: 0x10, value: 0x11, flags: 0x10, expected: 0x23, expFlags: 0x00},
+		{name: "RR", opcode: 0x18, value: 0x01, expected: 0x00, expFlags: 0x90},
+		{name: "RR carry in", opcode: 0x18, value: 0x8A, flags: 0x10, expected: 0xC5, expFlags: 0x00},
+		{name: "SLA", opcode: 0x20, value: 0xFF, expected: 0xFE, expFlags: 0x10},
+		{name: "SRA", opcode: 0x28, value: 0x81, expected: 0xC0, expFlags: 0x10},
+		{name: "SWAP", opcode: 0x30, value: 0xF1, flags: 0x70, expected: 0x1F, expFlags: 0x00},
+		{name: "SWAP zero", opcode: 0x30, value: 0x00, expected: 0x00, expFlags: 0x80},
+		{name: "SRL", opcode: 0x38, value: 0x01, flags: 0x60, expected: 0x00, expFlags: 0x90},
+		{name: "SRL no carry", opcode: 0x38, value: 0xFE, expected: 0x7F, expFlags: 0x00},
+		{name: "BIT 7 reset", opcode: 0x78, value: 0x7F, flags: 0x50, expected: 0x7F, expFlags: 0xB0},
+		{name: "BIT 0 set", opcode: 0x40, value: 0x01, flags: 0x40, expected: 0x01, expFlags: 0x20},
+		{name: "RES 0", opcode: 0x80, value: 0xFF, flags: 0x10, expected: 0xFE, expFlags: 0x10},
+		{name: "SET 7", opcode: 0xF8, value: 0x00, flags: 0xF0, expected: 0x80, expFlags: 0xF0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gb.CPU.BC.SetHi(test.value)
+			gb.CPU.AF.SetLo(test.flags)
+			cycles := gb.ExecuteCB(test.opcode)
+			assert.Equal(t, test.expected, gb.CPU.BC.Hi())
+			assert.Equal(t, fmt.Sprintf("%08b", test.expFlags), fmt.Sprintf("%08b", gb.CPU.AF.Lo()), "incorrect ZNHC flags")
+			assert.Equal(t, 8, cycles)
+		})
+	}
+}
+
+func TestExecuteCB_HL(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.CPU.HL.Set(0xC100)
+	gb.Memory.Write(0xC100, 0x85)
+	pc := gb.CPU.PC
+	cycles := gb.ExecuteCB(0x06) // RLC (HL)
+	assert.Equal(t, byte(0x0B), gb.Memory.Read(0xC100))
+	assert.True(t, gb.CPU.C())
+	assert.Equal(t, 16, cycles)
+	assert.Equal(t, pc, gb.CPU.PC, "PC should not change")
+}
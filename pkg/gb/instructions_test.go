@@ -6,6 +6,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/Humpheh/goboy/pkg/bits"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -58,3 +59,398 @@ func BenchmarkGameboy_ExecuteNextOpcode(b *testing.B) {
 		gb.ExecuteNextOpcode()
 	}
 }
+
+// Execute a single instruction by writing it into work RAM and running it.
+func executeInstruction(gb *Gameboy, instruction ...byte) int {
+	for i, b := range instruction {
+		gb.Memory.Write(0xC000+uint16(i), b)
+	}
+	gb.CPU.PC = 0xC000
+	return gb.ExecuteNextOpcode()
+}
+
+func TestInstructions_Dispatch(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// LD B,d8
+	cycles := executeInstruction(gb, 0x06, 0x3C)
+	assert.Equal(t, byte(0x3C), gb.CPU.BC.Hi())
+	assert.Equal(t, 8, cycles)
+	assert.Equal(t, uint16(0xC002), gb.CPU.PC)
+
+	// ADD A,B
+	gb.CPU.AF.Set(0x0400)
+	cycles = executeInstruction(gb, 0x80)
+	assert.Equal(t, byte(0x40), gb.CPU.AF.Hi())
+	assert.Equal(t, 4, cycles)
+	assert.True(t, gb.CPU.H())
+	assert.False(t, gb.CPU.Z() || gb.CPU.N() || gb.CPU.C())
+}
+
+// Reference implementation of DAA used to test the instruction. Returns the
+// new value of A and the C flag.
+func referenceDAA(a byte, n, h, c bool) (byte, bool) {
+	var adjust byte
+	if h || (!n && a&0xF > 0x9) {
+		adjust |= 0x06
+	}
+	if c || (!n && a > 0x99) {
+		adjust |= 0x60
+		c = true
+	}
+	if n {
+		return a - adjust, c
+	}
+	return a + adjust, c
+}
+
+func TestInstructions_OpcodeCoverage(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithOpcodeCoverage())
+	require.NoError(t, err, "error in init gb %v", err)
+
+	executeInstruction(gb, 0x06, 0x3C)
+	executeInstruction(gb, 0xCB, 0x37)
+	coverage := gb.OpcodeCoverage()
+	assert.True(t, coverage[0x06])
+	assert.True(t, coverage[0xCB])
+	assert.True(t, coverage[0x137])
+	assert.False(t, coverage[0x37])
+	assert.False(t, coverage[0x106])
+
+	// Without the option nothing is recorded
+	gb, err = NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	executeInstruction(gb, 0x06, 0x3C)
+	assert.Equal(t, [512]bool{}, gb.OpcodeCoverage())
+}
+
+func TestInstructions_DAA(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	for a := 0; a <= 0xFF; a++ {
+		for flags := uint16(0); flags <= 0xF; flags++ {
+			gb.CPU.AF.Set(uint16(a)<<8 | flags<<4)
+			n, h, c := gb.CPU.N(), gb.CPU.H(), gb.CPU.C()
+			executeInstruction(gb, 0x27)
+
+			expectedA, expectedC := referenceDAA(byte(a), n, h, c)
+			require.Equal(t, expectedA, gb.CPU.AF.Hi(), "A for A=%#02x flags=%04b", a, flags)
+			require.Equal(t, expectedA == 0, gb.CPU.Z(), "Z for A=%#02x flags=%04b", a, flags)
+			require.Equal(t, n, gb.CPU.N(), "N for A=%#02x flags=%04b", a, flags)
+			require.False(t, gb.CPU.H(), "H for A=%#02x flags=%04b", a, flags)
+			require.Equal(t, expectedC, gb.CPU.C(), "C for A=%#02x flags=%04b", a, flags)
+		}
+	}
+}
+
+func TestInstructions_AddHL(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	tests := []struct {
+		hl, bc   uint16
+		expected uint16
+		h, c     bool
+	}{
+		{0x0FFF, 0x0001, 0x1000, true, false},
+		{0xFFFF, 0x0001, 0x0000, true, true},
+		{0x8000, 0x8000, 0x0000, false, true},
+		{0x00FF, 0x0001, 0x0100, false, false},
+		{0x0F00, 0x0100, 0x1000, true, false},
+		{0x1234, 0x0000, 0x1234, false, false},
+	}
+	for _, test := range tests {
+		// Z should be preserved, and N always reset.
+		for _, z := range []bool{false, true} {
+			gb.CPU.HL.Set(test.hl)
+			gb.CPU.BC.Set(test.bc)
+			gb.CPU.SetZ(z)
+			gb.CPU.SetN(true)
+			executeInstruction(gb, 0x09) // ADD HL,BC
+
+			assert.Equal(t, test.expected, gb.CPU.HL.HiLo(), "%#04x+%#04x", test.hl, test.bc)
+			assert.Equal(t, test.h, gb.CPU.H(), "H for %#04x+%#04x", test.hl, test.bc)
+			assert.Equal(t, test.c, gb.CPU.C(), "C for %#04x+%#04x", test.hl, test.bc)
+			assert.Equal(t, z, gb.CPU.Z(), "Z for %#04x+%#04x", test.hl, test.bc)
+			assert.False(t, gb.CPU.N())
+		}
+	}
+
+	// ADD HL,HL
+	gb.CPU.HL.Set(0x8800)
+	executeInstruction(gb, 0x29)
+	assert.Equal(t, uint16(0x1000), gb.CPU.HL.HiLo())
+	assert.True(t, gb.CPU.H())
+	assert.True(t, gb.CPU.C())
+
+	// ADD HL,SP
+	gb.CPU.HL.Set(0x0001)
+	gb.CPU.SP.Set(0xFFFF)
+	executeInstruction(gb, 0x39)
+	assert.Equal(t, uint16(0x0000), gb.CPU.HL.HiLo())
+	assert.True(t, gb.CPU.H())
+	assert.True(t, gb.CPU.C())
+}
+
+func TestInstructionCycles(t *testing.T) {
+	assert.Equal(t, 1, InstructionCycles(0x00, false, false))
+	assert.Equal(t, 2, InstructionCycles(0x11, true, false))
+	assert.Equal(t, 4, InstructionCycles(0x06, true, true))
+	assert.Equal(t, 6, InstructionCycles(0xCD, false, true))
+	assert.Equal(t, 3, InstructionCycles(0xC4, false, false))
+	assert.Equal(t, 6, InstructionCycles(0xC4, false, true))
+}
+
+func TestInstructionCycles_Branch(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	for opcode := range BranchOpcodeCycles {
+		for _, taken := range []bool{false, true} {
+			// Bit 3 of the opcode is set if the condition is on the flag being
+			// set, and bit 4 selects the carry flag instead of the zero flag.
+			flag := taken == (opcode&0x08 != 0)
+			gb.CPU.SetZ(false)
+			gb.CPU.SetC(false)
+			if opcode&0x10 != 0 {
+				gb.CPU.SetC(flag)
+			} else {
+				gb.CPU.SetZ(flag)
+			}
+			gb.CPU.SP.Set(0xDFF0)
+
+			cycles := executeInstruction(gb, opcode, 0x00, 0xC0)
+			assert.Equal(t, InstructionCycles(opcode, false, taken)*4, cycles,
+				"unexpected cycles for opcode %#02x (taken=%v)", opcode, taken)
+		}
+	}
+}
+
+func TestInstructions_RETIEnablesInterruptsImmediately(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.interruptsOn = false
+	gb.CPU.SP.Set(0xDFF0)
+	gb.pushStack(0xC100)
+	gb.Memory.Write(0xFFFF, 0x04)
+	gb.requestInterrupt(2)
+
+	// RETI
+	executeInstruction(gb, 0xD9)
+	assert.True(t, gb.interruptsOn)
+
+	// The pending interrupt should be serviced before the next instruction
+	assert.Equal(t, 20, gb.doInterrupts())
+	assert.Equal(t, uint16(0x50), gb.CPU.PC)
+	assert.Equal(t, uint16(0xC100), gb.popStack())
+}
+
+func TestInstructions_EIDelay(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.interruptsOn = false
+	gb.Memory.Write(0xFFFF, 0x04)
+	gb.requestInterrupt(2)
+
+	// EI, the interrupt should not be serviced until after the next instruction
+	executeInstruction(gb, 0xFB, 0x00)
+	assert.Equal(t, 0, gb.doInterrupts())
+	assert.Equal(t, uint16(0xC001), gb.CPU.PC)
+
+	gb.ExecuteNextOpcode()
+	assert.Equal(t, 20, gb.doInterrupts())
+	assert.Equal(t, uint16(0x50), gb.CPU.PC)
+}
+
+func TestInstructions_EISequences(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		steps   int
+		pc      uint16
+		ime     bool
+		halted  bool
+		stack   uint16
+	}{
+		// DI straight after EI cancels it, so no interrupt is serviced
+		{"EI DI", []byte{0xFB, 0xF3, 0x00}, 3, 0xC003, false, false, 0},
+		// Repeated EIs do not delay the interrupt any further
+		{"EI EI", []byte{0xFB, 0xFB, 0x00}, 2, 0x50, false, false, 0xC002},
+		// The interrupt is serviced and returns to the HALT
+		{"EI HALT", []byte{0xFB, 0x76, 0x00}, 2, 0x50, false, false, 0xC001},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+			require.NoError(t, err, "error in init gb %v", err)
+			gb.CPU.SP.Set(0xDFF0)
+			gb.interruptsOn = false
+			gb.Memory.Write(0xFFFF, 0x04)
+			gb.requestInterrupt(2)
+			for i, b := range test.program {
+				gb.Memory.Write(0xC000+uint16(i), b)
+			}
+			gb.CPU.PC = 0xC000
+
+			for i := 0; i < test.steps; i++ {
+				gb.StepInstruction()
+			}
+			assert.Equal(t, test.pc, gb.CPU.PC)
+			assert.Equal(t, test.ime, gb.interruptsOn)
+			assert.Equal(t, test.halted, gb.IsHalted())
+			if test.stack != 0 {
+				assert.Equal(t, test.stack, gb.popStack())
+			}
+		})
+	}
+}
+
+func TestInstructions_Halt(t *testing.T) {
+	tests := []struct {
+		name    string
+		ime     bool
+		pending bool
+		halted  bool
+		pc      uint16
+		a       byte
+		stack   uint16
+	}{
+		// Waits for an interrupt
+		{"IME=0 not pending", false, false, true, 0xC001, 0x00, 0},
+		{"IME=1 not pending", true, false, true, 0xC001, 0x00, 0},
+		// The HALT bug runs the INC A after the HALT twice
+		{"IME=0 pending", false, true, false, 0xC002, 0x02, 0},
+		// The interrupt is serviced and returns after the HALT
+		{"IME=1 pending", true, true, false, 0x50, 0x00, 0xC001},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+			require.NoError(t, err, "error in init gb %v", err)
+			gb.CPU.SP.Set(0xDFF0)
+			gb.CPU.AF.Set(0)
+			gb.interruptsOn = test.ime
+			gb.Memory.Write(0xFFFF, 0x04)
+			gb.Memory.Write(0xFF0F, 0)
+			if test.pending {
+				gb.requestInterrupt(2)
+			}
+			gb.Memory.Write(0xC000, 0x76)
+			gb.Memory.Write(0xC001, 0x3C)
+			gb.CPU.PC = 0xC000
+
+			gb.StepInstruction()
+			if !test.ime && test.pending {
+				assert.True(t, gb.haltBug)
+				gb.StepInstruction()
+				gb.StepInstruction()
+			}
+			assert.Equal(t, test.halted, gb.IsHalted())
+			assert.Equal(t, test.pc, gb.CPU.PC)
+			assert.Equal(t, test.a, gb.CPU.AF.Hi())
+			if test.stack != 0 {
+				assert.Equal(t, test.stack, gb.popStack())
+			}
+		})
+	}
+}
+
+func TestInstructions_HaltWake(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.interruptsOn = false
+	gb.Memory.Write(0xFFFF, 0x04)
+	gb.Memory.Write(0xFF0F, 0)
+
+	executeInstruction(gb, 0x76)
+	assert.Equal(t, 4, gb.StepInstruction(), "no instruction should run while halted")
+	require.True(t, gb.IsHalted())
+
+	// With interrupts disabled the CPU wakes without servicing the interrupt
+	gb.requestInterrupt(2)
+	assert.Equal(t, 4, gb.StepInstruction())
+	assert.False(t, gb.IsHalted())
+	assert.Equal(t, uint16(0xC001), gb.CPU.PC)
+	assert.True(t, bits.Test(gb.Memory.Read(0xFF0F), 2), "interrupt should still be requested")
+}
+
+func TestInstructions_EIHaltRepeats(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.CPU.SP.Set(0xDFF0)
+	gb.interruptsOn = false
+	gb.Memory.Write(0xFFFF, 0x04)
+	gb.requestInterrupt(2)
+
+	executeInstruction(gb, 0xFB, 0x76)
+	gb.doInterrupts()
+	gb.StepInstruction()
+	require.Equal(t, uint16(0x50), gb.CPU.PC)
+
+	// Returning from the interrupt runs the HALT again, which waits for the
+	// next interrupt
+	gb.CPU.PC = gb.popStack()
+	gb.interruptsOn = true
+	gb.StepInstruction()
+	assert.True(t, gb.IsHalted())
+	assert.Equal(t, uint16(0xC002), gb.CPU.PC)
+
+	gb.requestInterrupt(2)
+	gb.StepInstruction()
+	assert.False(t, gb.IsHalted())
+	assert.Equal(t, uint16(0x50), gb.CPU.PC)
+	assert.Equal(t, uint16(0xC002), gb.popStack())
+}
+
+func TestInstructions_HaltPendingIME(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.CPU.SP.Set(0xDFF0)
+	gb.interruptsOn = true
+	gb.Memory.Write(0xFFFF, 0x04)
+	gb.requestInterrupt(2)
+
+	// Without an EI before the HALT the interrupt returns after the HALT
+	executeInstruction(gb, 0x76)
+	gb.doInterrupts()
+	assert.Equal(t, uint16(0x50), gb.CPU.PC)
+	assert.Equal(t, uint16(0xC001), gb.popStack())
+}
+
+func TestInstructions_InterruptMooneye(t *testing.T) {
+	for _, rom := range []string{"ei_sequence", "ei_timing", "halt_ime0_ei", "halt_ime1_timing", "rapid_di_ei"} {
+		t.Run(rom, func(t *testing.T) {
+			runMooneyeTest(t, romPath+"/"+rom+".gb")
+		})
+	}
+}
+
+func TestGameboy_LastInstruction(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	tests := []struct {
+		instruction []byte
+		operands    []byte
+		name        string
+	}{
+		{[]byte{0x00}, []byte{}, "NOP"},
+		{[]byte{0x06, 0x3C}, []byte{0x3C}, "LD B,n"},
+		{[]byte{0x21, 0x34, 0x12}, []byte{0x34, 0x12}, "LD HL,nn"},
+		{[]byte{0xCB, 0x11}, []byte{0x11}, "RL C"},
+		// Jumps do not change the recorded operands
+		{[]byte{0xC3, 0x00, 0xC0}, []byte{0x00, 0xC0}, "JP nn"},
+	}
+	for _, test := range tests {
+		executeInstruction(gb, test.instruction...)
+		inst := gb.LastInstruction()
+		assert.Equal(t, uint16(0xC000), inst.PC)
+		assert.Equal(t, test.instruction[0], inst.Opcode)
+		assert.Equal(t, test.operands, inst.Operands())
+		assert.Equal(t, test.name, inst.String())
+	}
+}
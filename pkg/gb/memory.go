@@ -2,7 +2,6 @@ package gb
 
 import (
 	"io"
-	"log"
 
 	"github.com/Humpheh/goboy/pkg/bits"
 	"github.com/Humpheh/goboy/pkg/cart"
@@ -11,16 +10,16 @@ import (
 const (
 	// DIV is the divider register which is incremented periodically by
 	// the Gameboy.
-	DIV = 0xFF04
+	DIV IORegister = 0xFF04
 	// TIMA is the timer counter register which is incremented by a clock
 	// frequency specified in the TAC register.
-	TIMA = 0xFF05
+	TIMA IORegister = 0xFF05
 	// TMA is the timer modulo register. When the TIMA overflows, this data
 	// will be loaded into the TIMA register.
-	TMA = 0xFF06
+	TMA IORegister = 0xFF06
 	// TAC is the timer control register. Writing to this register will
 	// start and stop the timer, and select the clock speed for the timer.
-	TAC = 0xFF07
+	TAC IORegister = 0xFF07
 
 	// TODO: move more hardware registers up here.
 )
@@ -36,16 +35,25 @@ type Memory struct {
 	// Index of the current VRAM bank
 	VRAMBank byte
 
-	// WRAM bank 0-7 data
+	// WRAM bank 0-7 data. Bank 0 is at the start, and the bank at 0xD000 is
+	// offset by the bank number times the size of a bank, which leaves room
+	// for all 8 CGB banks. Only banks 0 and 1 are used unless in CGB mode.
 	WRAM [0x9000]byte
 	// Index of the current WRAM bank
 	WRAMBank byte
 
 	OAM [0x100]byte
 
+	// Set on each write, which is used to check if an instruction wrote to
+	// memory.
+	written bool
+
 	// CGB HDMA transfer variables
 	hdmaLength byte
 	hdmaActive bool
+
+	// Handlers for custom peripherals mapped into memory
+	handlers []memoryHandler
 }
 
 // Init the gb memory to the post-boot values.
@@ -54,6 +62,9 @@ func (mem *Memory) Init(gameboy *Gameboy) {
 
 	// Set the default values
 	mem.HighRAM[0x04] = 0x1E
+	if model := gameboy.options.model; model == DMG || model == MGB {
+		mem.HighRAM[0x04] = 0xAB
+	}
 	mem.HighRAM[0x05] = 0x00
 	mem.HighRAM[0x06] = 0x00
 	mem.HighRAM[0x07] = 0xF8
@@ -81,6 +92,10 @@ func (mem *Memory) Init(gameboy *Gameboy) {
 	mem.HighRAM[0x42] = 0x00
 	mem.HighRAM[0x43] = 0x00
 	mem.HighRAM[0x45] = 0x00
+	mem.HighRAM[0x46] = 0x00
+	if gameboy.options.model != CGB {
+		mem.HighRAM[0x46] = 0xFF
+	}
 	mem.HighRAM[0x47] = 0xFC
 	mem.HighRAM[0x48] = 0xFF
 	mem.HighRAM[0x49] = 0xFF
@@ -89,6 +104,45 @@ func (mem *Memory) Init(gameboy *Gameboy) {
 	mem.HighRAM[0xFF] = 0x00
 
 	mem.WRAMBank = 1
+
+	if pattern := gameboy.options.memoryInitPattern; pattern != nil {
+		mem.fillPattern(pattern)
+	}
+}
+
+// Size of each bank of WRAM.
+const wramBankSize = 0x1000
+
+// WRAMBanks returns the number of banks of WRAM, which is 8 (32KB) in CGB mode
+// and 2 (8KB) otherwise. Bank 0 is always mapped at 0xC000. The bank at 0xD000
+// is bank 1, or in CGB mode the bank selected with SVBK (0xFF70), where
+// selecting bank 0 selects bank 1.
+func (mem *Memory) WRAMBanks() int {
+	if mem.gb.IsCGB() {
+		return 8
+	}
+	return 2
+}
+
+// Fill the RAM with the values from a pattern, which is called with the
+// address each byte is mapped to.
+func (mem *Memory) fillPattern(pattern func(address uint16) byte) {
+	for i := range mem.WRAM {
+		if i < 0x1000 {
+			mem.WRAM[i] = pattern(0xC000 + uint16(i))
+		} else {
+			mem.WRAM[i] = pattern(0xD000 + uint16(i%0x1000))
+		}
+	}
+	for i := range mem.VRAM {
+		mem.VRAM[i] = pattern(0x8000 + uint16(i%0x2000))
+	}
+	for i := 0; i < 0xA0; i++ {
+		mem.OAM[i] = pattern(0xFE00 + uint16(i))
+	}
+	for i := 0x80; i < 0xFF; i++ {
+		mem.HighRAM[i] = pattern(0xFF00 + uint16(i))
+	}
 }
 
 // LoadCart load a cart rom into memory.
@@ -116,28 +170,23 @@ func (mem *Memory) WriteHighRam(address uint16, value byte) {
 		// Writing to channel 3 waveform RAM.
 		mem.gb.Sound.WriteWaveform(address, value)
 
-	case address == 0xFF02:
+	case address == uint16(SC):
 		// Serial transfer control
-		if value == 0x81 {
-			f := mem.gb.options.transferFunction
-			if f != nil {
-				f(mem.ReadHighRam(0xFF01))
-			}
-		}
+		mem.gb.writeSerialControl(value)
 
-	case address == DIV:
+	case address == uint16(DIV):
 		// Trap divider register
 		mem.gb.setClockFreq()
 		mem.gb.CPU.Divider = 0
-		mem.HighRAM[DIV-0xFF00] = 0
+		mem.gb.setDivider(0)
 
-	case address == TIMA:
+	case address == uint16(TIMA):
 		mem.HighRAM[TIMA-0xFF00] = value
 
-	case address == TMA:
+	case address == uint16(TMA):
 		mem.HighRAM[TMA-0xFF00] = value
 
-	case address == TAC:
+	case address == uint16(TAC):
 		// Timer control
 		currentFreq := mem.gb.getClockFreq()
 		mem.HighRAM[TAC-0xFF00] = value | 0xF8
@@ -148,7 +197,9 @@ func (mem *Memory) WriteHighRam(address uint16, value byte) {
 		}
 
 	case address == 0xFF41:
-		mem.HighRAM[0x41] = value | 0x80
+		// LCD status
+		mem.gb.statWriteBug()
+		mem.writeIO(address, value)
 
 	case address == 0xFF44:
 		// Trap scanline register
@@ -200,27 +251,74 @@ func (mem *Memory) WriteHighRam(address uint16, value byte) {
 			mem.gb.SpritePalette.write(value)
 		}
 
+	case address == uint16(RP):
+		// Infrared port (CGB only)
+		if mem.gb.IsCGB() {
+			mem.gb.writeInfrared(value)
+		}
+
+	case address == 0xFF6C:
+		// Object priority mode (CGB only), only bit 0 is writable
+		if mem.gb.IsCGB() {
+			mem.HighRAM[0x6C] = value & 0x1
+		}
+
 	case address == 0xFF70:
 		// WRAM1 bank (CGB mode)
 		if mem.gb.IsCGB() {
-			mem.WRAMBank = value & 0x7
+			mem.WRAMBank = value & byte(mem.WRAMBanks()-1)
 			if mem.WRAMBank == 0 {
 				mem.WRAMBank = 1
 			}
 		}
 
 	case address >= 0xFF72 && address <= 0xFF77:
-		log.Print("write to ", address)
+		mem.gb.logf("write to %v\n", address)
 
 	default:
-		mem.HighRAM[address-0xFF00] = value
+		mem.writeIO(address, value)
 	}
 }
 
+// Write to a register in HighRAM, only changing the bits which can be written
+// to by the CPU.
+func (mem *Memory) writeIO(address uint16, value byte) {
+	mask := ioWriteMask(address)
+	current := mem.HighRAM[address-0xFF00]
+	mem.HighRAM[address-0xFF00] = current&^mask | value&mask
+}
+
+// Get the bits of a register which can be written to by the CPU. The other
+// bits are read only and controlled by the hardware, so they keep their
+// current value when the register is written to.
+func ioWriteMask(address uint16) byte {
+	switch address {
+	case 0xFF00:
+		// P1: only the button and direction select lines
+		return 0x30
+	case 0xFF0F:
+		// IF: only the five interrupt flags
+		return 0x1F
+	case 0xFF41:
+		// STAT: the mode and coincidence flags are set by the PPU and
+		// bit 7 is always set
+		return 0x78
+	}
+	return 0xFF
+}
+
 // Write a value at an address to the relevant location based on the
 // current state of the gameboy. This handles banking and side effects
 // of writing to certain addresses.
 func (mem *Memory) Write(address uint16, value byte) {
+	mem.written = true
+	if len(mem.handlers) > 0 {
+		if h := mem.handler(address); h != nil && h.write != nil {
+			h.write(address, value)
+			return
+		}
+	}
+
 	switch {
 	case address < 0x8000:
 		// Write to the cartridge ROM (banking)
@@ -241,13 +339,11 @@ func (mem *Memory) Write(address uint16, value byte) {
 
 	case address < 0xE000:
 		// Internal RAM Bank 1-7
-		mem.WRAM[(address-0xC000)+(uint16(mem.WRAMBank)*0x1000)] = value
+		mem.WRAM[(address-0xC000)+(uint16(mem.WRAMBank)*wramBankSize)] = value
 
 	case address < 0xFE00:
-		// Echo RAM
-		// TODO: re-enable echo RAM?
-		//mem.Data[address] = value
-		//mem.Write(address-0x2000, value)
+		// Echo RAM, mirror of 0xC000-0xDDFF
+		mem.Write(address-0x2000, value)
 
 	case address < 0xFEA0:
 		// Object Attribute Memory
@@ -266,6 +362,12 @@ func (mem *Memory) Write(address uint16, value byte) {
 // Read from memory. Will go and read from cartridge memory if the
 // requested address is mapped to that space.
 func (mem *Memory) Read(address uint16) byte {
+	if len(mem.handlers) > 0 {
+		if h := mem.handler(address); h != nil && h.read != nil {
+			return h.read(address)
+		}
+	}
+
 	switch {
 	case address < 0x8000:
 		// Cartridge ROM
@@ -287,14 +389,11 @@ func (mem *Memory) Read(address uint16) byte {
 
 	case address < 0xE000:
 		// Internal RAM Bank 1-7
-		return mem.WRAM[(address-0xC000)+(uint16(mem.WRAMBank)*0x1000)]
+		return mem.WRAM[(address-0xC000)+(uint16(mem.WRAMBank)*wramBankSize)]
 
 	case address < 0xFE00:
-		// Echo RAM
-		// TODO: re-enable echo RAM?
-		//mem.Data[address] = value
-		//mem.Write(address-0x2000, value)
-		return 0xFF
+		// Echo RAM, mirror of 0xC000-0xDDFF
+		return mem.Read(address - 0x2000)
 
 	case address < 0xFEA0:
 		// Object Attribute Memory
@@ -302,13 +401,65 @@ func (mem *Memory) Read(address uint16) byte {
 
 	case address < 0xFF00:
 		// Unusable memory
-		return 0xFF
+		return mem.readUnusable(address)
 
 	default:
 		return mem.ReadHighRam(address)
 	}
 }
 
+// Read from the unusable memory region 0xFEA0-0xFEFF. On the DMG this region
+// reads as 0x00, and on the CGB (revision E) it returns the upper nibble of the
+// lower address byte repeated, for example 0xFEC3 will read as 0xCC. This
+// depends on the hardware model rather than if the game is in CGB mode.
+func (mem *Memory) readUnusable(address uint16) byte {
+	if mem.gb.Model() != CGB {
+		return 0x00
+	}
+	nibble := byte(address) & 0xF0
+	return nibble | nibble>>4
+}
+
+// Returns if an address in the range 0xFF00-0xFF7F is not mapped to any hardware
+// register, in which case reads will return 0xFF. The unmapped ranges are:
+//
+//	0xFF03         Unused
+//	0xFF08-0xFF0E  Unused
+//	0xFF27-0xFF2F  Unused
+//	0xFF4C         CGB boot ROM mode
+//	0xFF4E         Unused
+//	0xFF50         Boot ROM disable (write only)
+//	0xFF57-0xFF67  Unused
+//	0xFF6D-0xFF6F  Unused
+//	0xFF71         Unused
+//	0xFF78-0xFF7F  Unused
+//
+// When not running in CGB mode, the CGB only registers 0xFF4D, 0xFF4F,
+// 0xFF51-0xFF56, 0xFF68-0xFF6C and 0xFF70 are also unmapped.
+func (mem *Memory) isUnmappedRegister(address uint16) bool {
+	switch {
+	case address == 0xFF03,
+		address >= 0xFF08 && address <= 0xFF0E,
+		address >= 0xFF27 && address <= 0xFF2F,
+		address == 0xFF4C,
+		address == 0xFF4E,
+		address == 0xFF50,
+		address >= 0xFF57 && address <= 0xFF67,
+		address >= 0xFF6D && address <= 0xFF6F,
+		address == 0xFF71,
+		address >= 0xFF78 && address <= 0xFF7F:
+		return true
+
+	case address == 0xFF4D,
+		address == 0xFF4F,
+		address >= 0xFF51 && address <= 0xFF56,
+		address >= 0xFF68 && address <= 0xFF6C,
+		address == 0xFF70:
+		return !mem.gb.IsCGB()
+	}
+	return false
+}
+
 // ReadHighRam reads from 0xFF00-0xFFFF in the memory address space. The range
 // includes both HRAM and the hardware registers.
 func (mem *Memory) ReadHighRam(address uint16) byte {
@@ -317,6 +468,9 @@ func (mem *Memory) ReadHighRam(address uint16) byte {
 	case address == 0xFF00:
 		return mem.gb.joypadValue(mem.HighRAM[0x00])
 
+	case mem.isUnmappedRegister(address):
+		return 0xFF
+
 	case address >= 0xFF10 && address <= 0xFF26:
 		return mem.gb.Sound.Read(address)
 
@@ -327,6 +481,12 @@ func (mem *Memory) ReadHighRam(address uint16) byte {
 	case address == 0xFF0F:
 		return mem.HighRAM[0x0F] | 0xE0
 
+	case address == uint16(SC):
+		return mem.gb.readSerialControl()
+
+	case address == uint16(RP):
+		return mem.gb.readInfrared()
+
 	case address >= 0xFF72 && address <= 0xFF77:
 		//log.Print("read from ", address)
 		return 0
@@ -359,9 +519,13 @@ func (mem *Memory) ReadHighRam(address uint16) byte {
 		}
 		return 0
 
+	case address == 0xFF6C:
+		// Object priority mode, the unused bits are always set
+		return 0xFE | mem.HighRAM[0x6C]
+
 	case address == 0xFF4D:
-		// Speed switch data
-		return mem.gb.currentSpeed<<7 | bits.B(mem.gb.prepareSpeed)
+		// Speed switch data, the unused bits are always set
+		return 0x7E | mem.gb.currentSpeed<<7 | bits.B(mem.gb.prepareSpeed)
 
 	case address == 0xFF4F:
 		return mem.VRAMBank
@@ -374,8 +538,11 @@ func (mem *Memory) ReadHighRam(address uint16) byte {
 	}
 }
 
-// Perform a DMA transfer.
+// Perform a DMA transfer. The source page is kept in the DMA register so it
+// reads back as the last value written.
 func (mem *Memory) doDMATransfer(value byte) {
+	mem.HighRAM[0x46] = value
+
 	// TODO: This may need to be done instead of CPU ticks
 	address := uint16(value) << 8 // (data * 100)
 
@@ -482,25 +649,25 @@ func (mem *Memory) SaveState(writer io.Writer) error {
 
 func (mem *Memory) LoadState(reader io.Reader) error {
 	// Read high ram
-	_, err := reader.Read(mem.HighRAM[:])
+	_, err := io.ReadFull(reader, mem.HighRAM[:])
 	if err != nil {
 		return err
 	}
 
 	// Read VRAM
-	_, err = reader.Read(mem.VRAM[:])
+	_, err = io.ReadFull(reader, mem.VRAM[:])
 	if err != nil {
 		return err
 	}
 
 	// Read WRAM
-	_, err = reader.Read(mem.WRAM[:])
+	_, err = io.ReadFull(reader, mem.WRAM[:])
 	if err != nil {
 		return err
 	}
 
 	// Read OAM
-	_, err = reader.Read(mem.OAM[:])
+	_, err = io.ReadFull(reader, mem.OAM[:])
 	if err != nil {
 		return err
 	}
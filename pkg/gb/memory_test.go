@@ -0,0 +1,162 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_UnmappedReads(t *testing.T) {
+	tests := []struct {
+		address uint16
+		dmg     byte
+		cgb     byte
+	}{
+		{0xFEA0, 0x00, 0xAA},
+		{0xFEC3, 0x00, 0xCC},
+		{0xFEFF, 0x00, 0xFF},
+		{0xFF03, 0xFF, 0xFF},
+		{0xFF08, 0xFF, 0xFF},
+		{0xFF27, 0xFF, 0xFF},
+		{0xFF4C, 0xFF, 0xFF},
+		{0xFF50, 0xFF, 0xFF},
+		{0xFF60, 0xFF, 0xFF},
+		{0xFF7F, 0xFF, 0xFF},
+		{0xFF4F, 0xFF, 0x00}, // VRAM bank
+		{0xFF70, 0xFF, 0x01}, // WRAM bank
+	}
+
+	dmg, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	cgb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled())
+	require.NoError(t, err, "error in init gb %v", err)
+	require.True(t, cgb.IsCGB())
+
+	for _, test := range tests {
+		assert.Equal(t, test.dmg, dmg.Memory.Read(test.address), "DMG read from %#04x", test.address)
+		assert.Equal(t, test.cgb, cgb.Memory.Read(test.address), "CGB read from %#04x", test.address)
+	}
+}
+
+func TestMemory_EchoRAM(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled())
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.Memory.Write(0xC000, 0x12)
+	assert.Equal(t, byte(0x12), gb.Memory.Read(0xE000))
+
+	gb.Memory.Write(0xFDFF, 0x34)
+	assert.Equal(t, byte(0x34), gb.Memory.Read(0xDDFF))
+
+	// The upper half should mirror the currently selected WRAM bank.
+	gb.Memory.Write(0xFF70, 2)
+	gb.Memory.Write(0xF000, 0x56)
+	assert.Equal(t, byte(0x56), gb.Memory.Read(0xD000))
+	gb.Memory.Write(0xFF70, 3)
+	assert.NotEqual(t, byte(0x56), gb.Memory.Read(0xF000))
+	gb.Memory.Write(0xFF70, 2)
+	assert.Equal(t, byte(0x56), gb.Memory.Read(0xF000))
+}
+
+func TestMemory_WRAMBanks(t *testing.T) {
+	dmg, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	cgb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled())
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.Equal(t, 2, dmg.Memory.WRAMBanks())
+	assert.Equal(t, 8, cgb.Memory.WRAMBanks())
+
+	// Each CGB bank is separate, and bank 0 at 0xC000 is not switched.
+	cgb.Memory.Write(0xC000, 0xAA)
+	for bank := byte(1); bank < 8; bank++ {
+		cgb.Memory.Write(0xFF70, bank)
+		cgb.Memory.Write(0xD000, bank)
+	}
+	for bank := byte(1); bank < 8; bank++ {
+		cgb.Memory.Write(0xFF70, bank)
+		assert.Equal(t, bank, cgb.Memory.Read(0xD000), "incorrect value in bank %v", bank)
+		assert.Equal(t, byte(0xAA), cgb.Memory.Read(0xC000))
+	}
+
+	// Selecting bank 0 selects bank 1.
+	cgb.Memory.Write(0xFF70, 0)
+	assert.Equal(t, byte(1), cgb.Memory.Read(0xD000))
+	cgb.Memory.Write(0xFF70, 0x0A)
+	assert.Equal(t, byte(2), cgb.Memory.Read(0xD000), "only the low bits should select the bank")
+
+	// The DMG can not switch banks.
+	dmg.Memory.Write(0xD000, 0x12)
+	dmg.Memory.Write(0xFF70, 2)
+	assert.Equal(t, byte(0x12), dmg.Memory.Read(0xD000))
+}
+
+func TestMemory_ReadOnlyIOBits(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// Writing to STAT should not change the mode or coincidence flags.
+	gb.Memory.HighRAM[0x41] = 0x86
+	gb.Memory.Write(0xFF41, 0x47)
+	assert.Equal(t, byte(0xC6), gb.Memory.Read(0xFF41))
+	gb.Memory.Write(0xFF41, 0x00)
+	assert.Equal(t, byte(0x86), gb.Memory.Read(0xFF41))
+
+	// Only the select lines of P1 can be written to.
+	gb.Memory.Write(0xFF00, 0x1F)
+	assert.Equal(t, byte(0x10), gb.Memory.HighRAM[0x00])
+	assert.Equal(t, byte(0xDF), gb.Memory.Read(0xFF00))
+
+	// The upper bits of IF are unused.
+	gb.Memory.Write(0xFF0F, 0xFF)
+	assert.Equal(t, byte(0xFF), gb.Memory.Read(0xFF0F))
+	gb.Memory.Write(0xFF0F, 0x00)
+	assert.Equal(t, byte(0xE0), gb.Memory.Read(0xFF0F))
+
+	// Registers without read only bits are written as normal.
+	gb.Memory.Write(0xFF42, 0xAB)
+	assert.Equal(t, byte(0xAB), gb.Memory.Read(0xFF42))
+}
+
+func TestMemory_DMARegister(t *testing.T) {
+	dmg, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	sgb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithModel(SGB))
+	require.NoError(t, err, "error in init gb %v", err)
+	cgb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithModel(CGB))
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.Equal(t, byte(0xFF), dmg.Memory.Read(0xFF46))
+	assert.Equal(t, byte(0xFF), sgb.Memory.Read(0xFF46))
+	assert.Equal(t, byte(0x00), cgb.Memory.Read(0xFF46))
+
+	dmg.Memory.Write(0xC000, 0x42)
+	dmg.Memory.Write(0xFF46, 0xC0)
+	assert.Equal(t, byte(0xC0), dmg.Memory.Read(0xFF46), "should read the last source page")
+	assert.Equal(t, byte(0x42), dmg.Memory.OAM[0])
+}
+
+func TestMemory_InitPattern(t *testing.T) {
+	pattern := func(address uint16) byte {
+		return byte(address) ^ byte(address>>8)
+	}
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb",
+		WithCGBEnabled(), WithMemoryInitPattern(pattern))
+	require.NoError(t, err, "error in init gb %v", err)
+
+	for _, address := range []uint16{0x8000, 0x9FFF, 0xC000, 0xCFFF, 0xD123, 0xFE00, 0xFE9F, 0xFF80, 0xFFFE} {
+		assert.Equal(t, pattern(address), gb.Memory.Read(address), "unexpected value at %#04x", address)
+	}
+	assert.Equal(t, pattern(0xC456), gb.Memory.Read(0xE456), "echo RAM should mirror WRAM")
+
+	// Each bank should start with the pattern for its addresses
+	gb.Memory.Write(0xFF70, 5)
+	assert.Equal(t, pattern(0xD123), gb.Memory.Read(0xD123))
+	gb.Memory.Write(0xFF4F, 1)
+	assert.Equal(t, pattern(0x8456), gb.Memory.Read(0x8456))
+	assert.Equal(t, byte(0x00), gb.Memory.Read(0xFFFF), "IE should not be set by the pattern")
+
+	// Without a pattern the RAM is cleared
+	gb, err = NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	assert.Equal(t, byte(0x00), gb.Memory.Read(0xC123))
+}
@@ -0,0 +1,49 @@
+package gb
+
+import "github.com/Humpheh/goboy/pkg/apu"
+
+// Model is a model of Gameboy hardware. Some behaviour of the emulator, such
+// as the initial register values and the values read from unusable memory,
+// depends on the model being emulated.
+type Model int
+
+const (
+	// DMG is the original Gameboy.
+	DMG Model = iota
+	// MGB is the Gameboy Pocket.
+	MGB
+	// SGB is the Super Gameboy.
+	SGB
+	// CGB is the Gameboy Color. Games which support the Gameboy Color will
+	// run in CGB mode on this model.
+	CGB
+)
+
+// String returns the name of the model.
+func (m Model) String() string {
+	switch m {
+	case DMG:
+		return "DMG"
+	case MGB:
+		return "MGB"
+	case SGB:
+		return "SGB"
+	case CGB:
+		return "CGB"
+	default:
+		return "Unknown"
+	}
+}
+
+// Get the charge factor of the high-pass filter on the sound output.
+func (m Model) chargeFactor() float64 {
+	if m == MGB || m == CGB {
+		return apu.ChargeFactorCGB
+	}
+	return apu.ChargeFactorDMG
+}
+
+// Model returns the model of Gameboy hardware being emulated.
+func (gb *Gameboy) Model() Model {
+	return gb.options.model
+}
@@ -0,0 +1,91 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModel(t *testing.T) {
+	tests := []struct {
+		options []GameboyOption
+		model   Model
+		cgb     bool
+	}{
+		{nil, DMG, false},
+		{[]GameboyOption{WithModel(MGB)}, MGB, false},
+		{[]GameboyOption{WithModel(SGB)}, SGB, false},
+		{[]GameboyOption{WithModel(CGB)}, CGB, true},
+		{[]GameboyOption{WithCGBEnabled()}, CGB, true},
+	}
+	for _, test := range tests {
+		t.Run(test.model.String(), func(t *testing.T) {
+			gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", test.options...)
+			require.NoError(t, err, "error in init gb %v", err)
+			assert.Equal(t, test.model, gb.Model())
+			assert.Equal(t, test.cgb, gb.IsCGB())
+		})
+	}
+}
+
+func TestModel_DMGGameOnCGB(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/mooneye/runnable/sprite_priority.gb", WithModel(CGB))
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// A DMG only game does not run in CGB mode, however the hardware is
+	// still a CGB.
+	assert.False(t, gb.IsCGB())
+	assert.Equal(t, byte(0x11), gb.CPU.AF.Hi())
+	assert.Equal(t, byte(0xAA), gb.Memory.Read(0xFEA0))
+}
+
+func TestModel_PostBootRegisters(t *testing.T) {
+	tests := []struct {
+		model          Model
+		af, bc, de, hl uint16
+		div            byte
+	}{
+		{DMG, 0x01B0, 0x0013, 0x00D8, 0x014D, 0xAB},
+		{MGB, 0xFFB0, 0x0013, 0x00D8, 0x014D, 0xAB},
+		{SGB, 0x0100, 0x0014, 0x0000, 0xC060, 0x1E},
+		{CGB, 0x1180, 0x0000, 0xFF56, 0x000D, 0x1E},
+	}
+	for _, test := range tests {
+		t.Run(test.model.String(), func(t *testing.T) {
+			gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithModel(test.model))
+			require.NoError(t, err, "error in init gb %v", err)
+			assert.Equal(t, test.af, gb.CPU.AF.HiLo(), "AF")
+			assert.Equal(t, test.bc, gb.CPU.BC.HiLo(), "BC")
+			assert.Equal(t, test.de, gb.CPU.DE.HiLo(), "DE")
+			assert.Equal(t, test.hl, gb.CPU.HL.HiLo(), "HL")
+			assert.Equal(t, uint16(0xFFFE), gb.CPU.SP.HiLo(), "SP")
+			assert.Equal(t, uint16(0x100), gb.CPU.PC, "PC")
+			assert.Equal(t, test.div, gb.ReadIO(DIV), "DIV")
+			assert.Equal(t, byte(0x91), gb.Memory.Read(0xFF40), "LCDC")
+			assert.Equal(t, byte(0xFC), gb.Memory.Read(0xFF47), "BGP")
+			assert.Equal(t, byte(0xCF), gb.Memory.Read(0xFF00), "P1")
+		})
+	}
+}
+
+func TestModel_BootRegsROM(t *testing.T) {
+	tests := []struct {
+		rom   string
+		model Model
+	}{
+		{"boot_regs-dmgABC", DMG},
+		{"boot_regs-mgb", MGB},
+		{"boot_regs-sgb", SGB},
+	}
+	for _, test := range tests {
+		t.Run(test.rom, func(t *testing.T) {
+			gb, err := NewGameboy(romPath+"/"+test.rom+".gb", WithModel(test.model))
+			require.NoError(t, err, "error in init gb %v", err)
+			for i := 0; i < 100 && !inFinishLoop(gb); i++ {
+				gb.Update()
+			}
+			require.True(t, passedTest(gb), "registers do not match expected")
+		})
+	}
+}
@@ -1,17 +1,69 @@
 package gb
 
-import "io"
+import (
+	"image"
+	"io"
+)
 
 // GameboyOption is an option for the Gameboy execution.
 type GameboyOption func(o *gameboyOptions)
 
 type gameboyOptions struct {
-	sound   bool
-	cgbMode bool
-	saver   io.ReadWriter // Save location
+	sound bool
+	model Model
+
+	// Number of sound samples produced each second, or 0 for apu.SampleRate
+	sampleRate int
+
+	// Adjust the sample rate to keep the sound output buffer level steady
+	dynamicAudioSync bool
+
+	// Locations the battery backed save data is loaded from and saved to
+	sramLoader io.Reader
+	sramSaver  io.Writer
 
 	// Callback when the serial port is written to
 	transferFunction func(byte)
+
+	// Link partner which returns the byte received in a serial transfer
+	serialExchange func(out byte) (in byte)
+
+	// Callback when the V-Blank interrupt is requested
+	vblankCallback func(*Gameboy)
+
+	// Source of the images seen by the Gameboy Camera
+	cameraSource func() image.Image
+
+	// Callback to decide if a button press resumes the CPU from STOP
+	stopWakeCallback func(button Button, wake bool) bool
+
+	// Pacer used by Run to run frames in real time
+	pacer *FramePacer
+
+	// Save the cartridge RAM when the game disables it
+	autoSave bool
+
+	// Logger for debug output, which is stdout if it is nil
+	logger Logger
+
+	// Initial values of the RAM, which is zero if it is nil
+	memoryInitPattern func(address uint16) byte
+
+	// Record which opcodes are executed
+	opcodeCoverage bool
+
+	// Codec the body of save states is wrapped with, which is not used if
+	// they are nil
+	stateEncoder func(io.Writer) io.WriteCloser
+	stateDecoder func(io.Reader) io.Reader
+
+	// Device connected to the CGB infrared port
+	infraredPeer io.ReadWriter
+
+	// Callback for when the game is stuck, and the number of frames it must
+	// be stuck for
+	stallCallback func()
+	stallFrames   int
 }
 
 // DebugFlags are flags which can be set to alter the execution of the Gameboy.
@@ -40,10 +92,18 @@ func (flags *DebugFlags) toggleOutputOpCode() {
 	flags.OutputOpcodes = !flags.OutputOpcodes
 }
 
-// WithCGBEnabled runs the Gameboy with cgb mode enabled.
+// WithCGBEnabled runs the Gameboy with cgb mode enabled. This is the same as
+// running with the CGB model.
 func WithCGBEnabled() GameboyOption {
+	return WithModel(CGB)
+}
+
+// WithModel sets the model of Gameboy hardware to emulate. By default the DMG
+// is emulated. CGB mode is enabled when the model is CGB and the game supports
+// the Gameboy Color.
+func WithModel(model Model) GameboyOption {
 	return func(o *gameboyOptions) {
-		o.cgbMode = true
+		o.model = model
 	}
 }
 
@@ -54,9 +114,48 @@ func WithSound() GameboyOption {
 	}
 }
 
+// WithSampleRate sets the number of sound samples produced for each second of
+// emulation, which is the rate the sound output device is opened at and the
+// rate of the samples from ReadAudio. By default this is apu.SampleRate.
+func WithSampleRate(sampleRate int) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.sampleRate = sampleRate
+	}
+}
+
+// WithDynamicAudioSync adjusts the rate sound samples are produced at by a
+// small amount to keep the sound output from running out of or building up
+// samples. This should be used when the frames are not run at exactly the
+// Gameboy frame rate, such as when a frame is run on each refresh of the
+// display, to avoid the sound crackling.
+func WithDynamicAudioSync() GameboyOption {
+	return func(o *gameboyOptions) {
+		o.dynamicAudioSync = true
+	}
+}
+
+// WithSaveFile sets the location the battery backed save data of the game is
+// loaded from and saved to.
 func WithSaveFile(saver io.ReadWriter) GameboyOption {
 	return func(o *gameboyOptions) {
-		o.saver = saver
+		o.sramLoader = saver
+		o.sramSaver = saver
+	}
+}
+
+// WithSRAMLoader sets the location the battery backed save data of the game is
+// loaded from, which can be different to where it is saved to.
+func WithSRAMLoader(loader io.Reader) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.sramLoader = loader
+	}
+}
+
+// WithSRAMSaver sets the location the battery backed save data of the game is
+// saved to, which can be different to where it is loaded from.
+func WithSRAMSaver(saver io.Writer) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.sramSaver = saver
 	}
 }
 
@@ -67,3 +166,138 @@ func WithTransferFunction(transfer func(byte)) GameboyOption {
 		o.transferFunction = transfer
 	}
 }
+
+// WithSerialExchange provides a function which is called when a serial transfer
+// is started with the internal clock, with the byte being sent. The function
+// returns the byte the link partner sends back in the same transfer, which is
+// shifted into the serial data register (0xFF01) as the transfer runs, and is
+// in the register once the transfer completes and the serial interrupt is
+// requested. This can be used to emulate a device connected to the link port.
+// Without a link partner the Gameboy receives 0xFF. This can be used with
+// WithTransferFunction, which is called first.
+func WithSerialExchange(exchange func(out byte) (in byte)) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.serialExchange = exchange
+	}
+}
+
+// WithVBlankCallback provides a function to callback on when the V-Blank interrupt
+// is requested, which happens when the PPU reaches scanline 144.
+func WithVBlankCallback(callback func(*Gameboy)) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.vblankCallback = callback
+	}
+}
+
+// WithCameraSource provides a function which returns the image seen by the
+// Gameboy Camera when the game takes a picture. This is only used if the game
+// is a Gameboy Camera cartridge.
+func WithCameraSource(source func() image.Image) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.cameraSource = source
+	}
+}
+
+// WithStopWakeCallback provides a function which is called when a button is
+// pressed while the CPU is stopped. The wake argument is true if the joypad line
+// of the button is selected, which is when the hardware would resume the CPU.
+// The CPU resumes if the callback returns true, so it can be used to observe
+// the wake condition or to override which buttons resume the CPU.
+func WithStopWakeCallback(callback func(button Button, wake bool) bool) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.stopWakeCallback = callback
+	}
+}
+
+// WithFramePacer sets a pacer which Run waits on before each frame, so that the
+// frames are run in real time instead of as fast as possible. The speed can be
+// changed while running with FramePacer.SetMultiplier.
+func WithFramePacer(pacer *FramePacer) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.pacer = pacer
+	}
+}
+
+// WithAutoSave saves the battery backed save data whenever the game disables
+// the cartridge RAM after writing to it. Games disable the RAM once they have
+// finished writing their save data, so this saves each time the game saves
+// without saving partially written data. The save is made at the end of the
+// frame about half a second after the RAM is disabled. The data is saved
+// to the location set with the WithSaveFile or WithSRAMSaver options, which
+// should be an io.WriterAt such as a file so each save replaces the last.
+func WithAutoSave() GameboyOption {
+	return func(o *gameboyOptions) {
+		o.autoSave = true
+	}
+}
+
+// WithLogger sets the logger which the debug output of the Gameboy is written
+// to, such as the opcode logging and background map. By default this is
+// written to stdout.
+func WithLogger(logger Logger) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.logger = logger
+	}
+}
+
+// WithMemoryInitPattern sets the values the RAM starts with, instead of being
+// cleared to zero. The pattern is called with the address of each byte of WRAM,
+// VRAM, OAM and HRAM when the Gameboy is created or reset. The banks of WRAM
+// and VRAM are at the same addresses, so each bank starts with the same values.
+// Real hardware starts with random values, which some games depend on, and a
+// seeded pattern makes this reproducible, such as for fuzzing.
+func WithMemoryInitPattern(pattern func(address uint16) byte) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.memoryInitPattern = pattern
+	}
+}
+
+// WithOpcodeCoverage records which opcodes are executed, which can be read
+// with OpcodeCoverage. This can be used to find which instructions a test rom
+// exercises.
+func WithOpcodeCoverage() GameboyOption {
+	return func(o *gameboyOptions) {
+		o.opcodeCoverage = true
+	}
+}
+
+// WithStateCodec wraps the save states written by SaveState with enc and
+// those read by LoadState with dec, such as to compress or encrypt them. The
+// writer returned by enc is closed once the state has been written. The
+// identifier of the game, the CGB mode and the format at the start of the
+// state are not wrapped, so a state for a different game or mode is still
+// detected before it is decoded. The format records if the state was encoded,
+// so LoadState returns ErrSaveStateFormat for an encoded state when no codec
+// is set, or a state which is not encoded when a codec is set.
+func WithStateCodec(enc func(io.Writer) io.WriteCloser, dec func(io.Reader) io.Reader) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.stateEncoder = enc
+		o.stateDecoder = dec
+	}
+}
+
+// WithInfraredPeer connects the CGB infrared port (RP, 0xFF56) to another
+// device, such as another Gameboy. A byte is written to the peer each time the
+// LED is turned on or off, which is 1 for on and 0 for off, and the peer
+// should send bytes in the same way. The peer is read from in a separate
+// goroutine until it returns an error. Without a peer the port never
+// receives a signal.
+func WithInfraredPeer(peer io.ReadWriter) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.infraredPeer = peer
+	}
+}
+
+// WithStallCallback calls the callback when the game appears to be stuck. This
+// is when the PC has stayed within a small range of addresses for a number of
+// frames without an interrupt being serviced or any memory being written to,
+// such as a jr $fe loop waiting for an interrupt which is disabled. The
+// callback is called once each time the game gets stuck, from the goroutine
+// running the emulator. This can be used by automated runners to stop a game
+// which has crashed.
+func WithStallCallback(frames int, callback func()) GameboyOption {
+	return func(o *gameboyOptions) {
+		o.stallFrames = frames
+		o.stallCallback = callback
+	}
+}
@@ -0,0 +1,98 @@
+package gb
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// FrameRate is the number of frames run each second in real time. This is
+// based on the CyclesFrame cycles run by each Update, so the pacer keeps the
+// emulator running at the clock speed.
+const FrameRate = float64(ClockSpeed) / CyclesFrame
+
+// DefaultMaxCatchUp is the number of frames a FramePacer catches up on by
+// default, which is about 100ms.
+const DefaultMaxCatchUp = 6
+
+// FramePacer limits how fast frames are run so that the emulator runs in real
+// time. The time each frame is due is accumulated from the start using the
+// monotonic clock, so any oversleeping or slow frames are made up for on the
+// following frames instead of drifting.
+type FramePacer struct {
+	multiplier atomic.Uint64
+	maxCatchUp atomic.Int64
+
+	started bool
+	due     time.Time
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewFramePacer returns a new FramePacer running at normal speed.
+func NewFramePacer() *FramePacer {
+	pacer := &FramePacer{
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+	pacer.SetMultiplier(1)
+	pacer.SetMaxCatchUp(DefaultMaxCatchUp)
+	return pacer
+}
+
+// SetMultiplier sets the speed the frames are paced at, where 1 is normal speed,
+// 2 is double speed and 0.5 is half speed. A multiplier of 0 or less turns off
+// pacing so frames are run as fast as possible. This is safe to call from a
+// different goroutine to the one waiting on the pacer.
+func (p *FramePacer) SetMultiplier(multiplier float64) {
+	p.multiplier.Store(math.Float64bits(multiplier))
+}
+
+// Multiplier returns the speed the frames are paced at.
+func (p *FramePacer) Multiplier() float64 {
+	return math.Float64frombits(p.multiplier.Load())
+}
+
+// SetMaxCatchUp sets the number of frames the pacer can fall behind by and
+// still catch up on, by running frames without waiting. If it falls further
+// behind, such as when the host is too slow to run in real time, the backlog
+// is dropped and the timing restarts from the current frame. This stops a slow
+// host from falling further behind each time it tries to catch up. With 0 the
+// pacer never catches up. This is safe to call from a different goroutine to
+// the one waiting on the pacer.
+func (p *FramePacer) SetMaxCatchUp(frames int) {
+	p.maxCatchUp.Store(int64(max(frames, 0)))
+}
+
+// MaxCatchUp returns the number of frames the pacer can catch up on.
+func (p *FramePacer) MaxCatchUp() int {
+	return int(p.maxCatchUp.Load())
+}
+
+// Wait blocks until the next frame is due to be run. The first call returns
+// immediately and starts the timing of the frames.
+func (p *FramePacer) Wait() {
+	now := p.now()
+	multiplier := p.Multiplier()
+	if !p.started || multiplier <= 0 {
+		p.started = true
+		p.due = now
+		return
+	}
+
+	frame := time.Duration(float64(time.Second) / (FrameRate * multiplier))
+	p.due = p.due.Add(frame)
+	delay := p.due.Sub(now)
+	if delay > 0 {
+		p.sleep(delay)
+	} else if delay < -frame*time.Duration(p.MaxCatchUp()) {
+		p.due = now
+	}
+}
+
+// Reset restarts the timing of the frames, so the next call to Wait returns
+// immediately. This should be called after the emulator has been paused.
+func (p *FramePacer) Reset() {
+	p.started = false
+}
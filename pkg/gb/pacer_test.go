@@ -0,0 +1,104 @@
+package gb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Create a pacer using a fake clock which only moves forward when the pacer
+// sleeps or the returned function is called.
+func fakePacer() (*FramePacer, *time.Duration, func(time.Duration)) {
+	start := time.Now()
+	var elapsed, slept time.Duration
+	pacer := NewFramePacer()
+	pacer.now = func() time.Time {
+		return start.Add(elapsed)
+	}
+	pacer.sleep = func(d time.Duration) {
+		elapsed += d
+		slept += d
+	}
+	return pacer, &slept, func(d time.Duration) {
+		elapsed += d
+	}
+}
+
+func TestFramePacer(t *testing.T) {
+	pacer, slept, _ := fakePacer()
+	for i := 0; i <= 60; i++ {
+		pacer.Wait()
+	}
+	// Each frame should take as long as the cycles run by Update.
+	expected := 60 * CyclesFrame / float64(ClockSpeed) * float64(time.Second)
+	assert.InDelta(t, expected, *slept, float64(time.Microsecond))
+}
+
+func TestFramePacer_Multiplier(t *testing.T) {
+	pacer, slept, _ := fakePacer()
+	pacer.SetMultiplier(2)
+	assert.Equal(t, 2.0, pacer.Multiplier())
+	for i := 0; i <= 60; i++ {
+		pacer.Wait()
+	}
+	expected := 30 / FrameRate * float64(time.Second)
+	assert.InDelta(t, expected, *slept, float64(time.Microsecond))
+
+	// Turning off pacing should never sleep.
+	pacer.SetMultiplier(0)
+	*slept = 0
+	for i := 0; i < 10; i++ {
+		pacer.Wait()
+	}
+	assert.Equal(t, time.Duration(0), *slept)
+}
+
+func TestFramePacer_Drift(t *testing.T) {
+	pacer, slept, advance := fakePacer()
+	frame := time.Second * CyclesFrame / ClockSpeed
+
+	// A slow frame is made up for by sleeping less on the next frame.
+	pacer.Wait()
+	advance(frame + frame/2)
+	pacer.Wait()
+	assert.Equal(t, time.Duration(0), *slept)
+	pacer.Wait()
+	assert.InDelta(t, frame/2, *slept, float64(time.Microsecond))
+
+	// Falling far behind does not try to catch up.
+	advance(time.Second)
+	pacer.Wait()
+	*slept = 0
+	pacer.Wait()
+	assert.InDelta(t, frame, *slept, float64(time.Microsecond))
+}
+
+func TestFramePacer_MaxCatchUp(t *testing.T) {
+	pacer, slept, advance := fakePacer()
+	frame := time.Second * CyclesFrame / ClockSpeed
+	assert.Equal(t, DefaultMaxCatchUp, pacer.MaxCatchUp())
+
+	// Count the frames run without sleeping after a stall of 20 frames.
+	catchUp := func() int {
+		pacer.Reset()
+		pacer.Wait()
+		advance(20 * frame)
+		*slept = 0
+		frames := 0
+		for *slept == 0 {
+			pacer.Wait()
+			frames++
+		}
+		return frames - 1
+	}
+
+	pacer.SetMaxCatchUp(30)
+	assert.Equal(t, 20, catchUp(), "all of the frames should be caught up on")
+	pacer.SetMaxCatchUp(10)
+	assert.Equal(t, 1, catchUp(), "the backlog should be dropped")
+	pacer.SetMaxCatchUp(0)
+	assert.Equal(t, 1, catchUp(), "the backlog should be dropped")
+	pacer.SetMaxCatchUp(-1)
+	assert.Equal(t, 0, pacer.MaxCatchUp())
+}
@@ -0,0 +1,47 @@
+package gb
+
+import "fmt"
+
+// A handler for reads and writes to a range of the memory address space,
+// used to map custom peripherals into memory.
+type memoryHandler struct {
+	start, end uint16
+	read       func(uint16) byte
+	write      func(uint16, byte)
+}
+
+// RegisterMemoryHandler maps a custom peripheral into the address range
+// [start, end]. Reads and writes to addresses in the range will call r and w
+// instead of the default memory behaviour. If either function is nil then the
+// default behaviour is kept for that type of access.
+//
+// An error is returned if the range overlaps with a range that has already
+// been registered. Handlers are kept when a new ROM is loaded.
+func (gb *Gameboy) RegisterMemoryHandler(start, end uint16, r func(uint16) byte, w func(uint16, byte)) error {
+	if start > end {
+		return fmt.Errorf("invalid memory handler range %#04x-%#04x", start, end)
+	}
+	for _, h := range gb.Memory.handlers {
+		if start <= h.end && end >= h.start {
+			return fmt.Errorf("memory handler range %#04x-%#04x overlaps with %#04x-%#04x",
+				start, end, h.start, h.end)
+		}
+	}
+	gb.Memory.handlers = append(gb.Memory.handlers, memoryHandler{
+		start: start,
+		end:   end,
+		read:  r,
+		write: w,
+	})
+	return nil
+}
+
+// Get the registered handler for an address, or nil if there is none.
+func (mem *Memory) handler(address uint16) *memoryHandler {
+	for i := range mem.handlers {
+		if address >= mem.handlers[i].start && address <= mem.handlers[i].end {
+			return &mem.handlers[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGameboy_RegisterMemoryHandler(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	var written []byte
+	err = gb.RegisterMemoryHandler(0xA000, 0xA0FF,
+		func(address uint16) byte { return byte(address) },
+		func(_ uint16, value byte) { written = append(written, value) },
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, byte(0x12), gb.Memory.Read(0xA012))
+	gb.Memory.Write(0xA000, 0x34)
+	assert.Equal(t, []byte{0x34}, written)
+
+	// Addresses outside of the range use the default behaviour
+	gb.Memory.Write(0xC000, 0x56)
+	assert.Equal(t, byte(0x56), gb.Memory.Read(0xC000))
+	assert.Equal(t, []byte{0x34}, written)
+
+	// Handlers are kept when loading a new ROM
+	require.NoError(t, gb.LoadROM("./../../roms/blargg/cpu_instrs.gb"))
+	assert.Equal(t, byte(0x12), gb.Memory.Read(0xA012))
+}
+
+func TestGameboy_RegisterMemoryHandlerNil(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	var written byte
+	err = gb.RegisterMemoryHandler(0xC000, 0xC000, nil, func(_ uint16, value byte) { written = value })
+	require.NoError(t, err)
+
+	gb.Memory.Write(0xC000, 0x12)
+	assert.Equal(t, byte(0x12), written)
+	assert.Equal(t, byte(0x00), gb.Memory.Read(0xC000), "read should use the default behaviour")
+}
+
+func TestGameboy_RegisterMemoryHandlerOverlap(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	require.NoError(t, gb.RegisterMemoryHandler(0xA000, 0xA0FF, nil, nil))
+	assert.Error(t, gb.RegisterMemoryHandler(0xA0FF, 0xA1FF, nil, nil))
+	assert.Error(t, gb.RegisterMemoryHandler(0x9000, 0xA000, nil, nil))
+	assert.Error(t, gb.RegisterMemoryHandler(0xA010, 0xA020, nil, nil))
+	assert.Error(t, gb.RegisterMemoryHandler(0xB000, 0xA000, nil, nil))
+	assert.NoError(t, gb.RegisterMemoryHandler(0xA100, 0xA1FF, nil, nil))
+}
@@ -1,6 +1,9 @@
 package gb
 
 import (
+	"fmt"
+	"image"
+
 	"github.com/Humpheh/goboy/pkg/bits"
 )
 
@@ -12,7 +15,7 @@ const (
 	ScreenHeight = 144
 
 	// LCDC is the main LCD Control register.
-	LCDC = 0xFF40
+	LCDC IORegister = 0xFF40
 )
 
 // Update the state of the graphics.
@@ -27,7 +30,9 @@ func (gb *Gameboy) updateGraphics(cycles int) {
 	if gb.scanlineCounter <= 0 {
 		gb.Memory.HighRAM[0x44]++
 		if gb.Memory.HighRAM[0x44] > 153 {
-			gb.PreparedData = gb.screenData
+			if !gb.skipRender {
+				gb.PreparedData = gb.screenData
+			}
 			gb.screenData = [ScreenWidth][ScreenHeight][3]uint8{}
 			gb.bgPriority = [ScreenWidth][ScreenHeight]bool{}
 			gb.Memory.HighRAM[0x44] = 0
@@ -38,6 +43,9 @@ func (gb *Gameboy) updateGraphics(cycles int) {
 
 		if currentLine == ScreenHeight {
 			gb.requestInterrupt(0)
+			if gb.options.vblankCallback != nil {
+				gb.options.vblankCallback(gb)
+			}
 		}
 	}
 }
@@ -62,7 +70,7 @@ func (gb *Gameboy) setLCDStatus() {
 		// We aren't in a mode so reset the values
 		status = bits.Reset(status, 0)
 		status = bits.Reset(status, 1)
-		gb.Memory.Write(0xFF41, status)
+		gb.Memory.HighRAM[0x41] = status
 		return
 	}
 	gb.screenCleared = false
@@ -88,7 +96,7 @@ func (gb *Gameboy) setLCDStatus() {
 		mode = 3
 		status = bits.Set(status, 0)
 		status = bits.Set(status, 1)
-		if mode != currentMode {
+		if mode != currentMode && !gb.skipRender {
 			// Draw the scanline when we start mode 3. In the real GameBoy
 			// this would be done throughout mode 3 by reading OAM and VRAM
 			// to generate the picture.
@@ -119,7 +127,20 @@ func (gb *Gameboy) setLCDStatus() {
 		status = bits.Reset(status, 2)
 	}
 
-	gb.Memory.Write(0xFF41, status)
+	gb.Memory.HighRAM[0x41] = status
+}
+
+// Emulate the bug on the DMG where writing to STAT briefly enables all of the
+// STAT interrupt sources, which requests an interrupt if the LCD is in HBlank or
+// VBlank or LY is equal to LYC. This was fixed on the CGB.
+func (gb *Gameboy) statWriteBug() {
+	if gb.options.model == CGB || !gb.isLCDEnabled() {
+		return
+	}
+	status := gb.Memory.HighRAM[0x41]
+	if mode := status & 0x3; mode == 0 || mode == 1 || bits.Test(status, 2) {
+		gb.requestInterrupt(1)
+	}
 }
 
 // Checks if the LCD is enabled by examining 0xFF40.
@@ -127,9 +148,63 @@ func (gb *Gameboy) isLCDEnabled() bool {
 	return bits.Test(gb.Memory.HighRAM[0x40], 7)
 }
 
-// Draw a single scanline to the graphics output.
+// LCDEnabled returns if the LCD is currently enabled by the game. While the
+// LCD is disabled nothing is drawn and the screen is blank.
+func (gb *Gameboy) LCDEnabled() bool {
+	return gb.isLCDEnabled()
+}
+
+// GetFrame returns the last frame which has been fully rendered. If the LCD is
+// disabled then a blank frame is returned instead.
+func (gb *Gameboy) GetFrame() *[ScreenWidth][ScreenHeight][3]uint8 {
+	if !gb.isLCDEnabled() {
+		gb.clearScreen()
+	}
+	return &gb.PreparedData
+}
+
+// CurrentScanline returns the scanline the PPU is currently on (the LY register).
+// Lines 0-143 are drawn to the screen and lines 144-153 are the V-Blank period.
+func (gb *Gameboy) CurrentScanline() int {
+	return int(gb.Memory.HighRAM[0x44])
+}
+
+// CurrentPPUMode returns the current mode of the PPU, derived from the current
+// scanline and the position within the scanline:
+//
+//	0  H-Blank
+//	1  V-Blank
+//	2  OAM search
+//	3  Pixel transfer
+//
+// When the LCD is disabled the mode will be 0.
+func (gb *Gameboy) CurrentPPUMode() int {
+	switch {
+	case !gb.isLCDEnabled():
+		return 0
+	case gb.CurrentScanline() >= ScreenHeight:
+		return 1
+	case gb.scanlineCounter >= lcdMode2Bounds:
+		return 2
+	case gb.scanlineCounter >= lcdMode3Bounds:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Draw a single scanline to the graphics output. Each scanline is drawn all at
+// once at the start of mode 3, so the LCDC register is read again for every
+// scanline and all of the bits which affect drawing are latched at that point
+// for the whole line. This is the BG and window enable or priority (bit 0),
+// sprite enable (bit 1), sprite size (bit 2), BG map (bit 3), tile data (bit
+// 4), window enable (bit 5) and window map (bit 6). Changes made during mode 3
+// take effect from the next scanline, and the LCD enable (bit 7) takes effect
+// immediately. Games which change LCDC in the H-Blank or LY=LYC interrupt for
+// raster effects are drawn correctly, but changes part way through a line are
+// not.
 func (gb *Gameboy) drawScanline(scanline byte) {
-	control := gb.Memory.ReadHighRam(LCDC)
+	control := gb.Memory.ReadHighRam(uint16(LCDC))
 
 	// LCDC bit 0 clears tiles on DMG but controls priority on CGB.
 	if (gb.IsCGB() || bits.Test(control, 0)) && !gb.Debug.HideBackground {
@@ -148,8 +223,6 @@ func (gb *Gameboy) getTileSettings(lcdControl byte, windowY byte) (
 	tileData uint16,
 	backgroundMemory uint16,
 ) {
-	tileData = uint16(0x8800)
-
 	if bits.Test(lcdControl, 5) {
 		// Is current scanline we're drawing within windows Y position?
 		if windowY <= gb.Memory.ReadHighRam(0xFF44) {
@@ -157,24 +230,64 @@ func (gb *Gameboy) getTileSettings(lcdControl byte, windowY byte) (
 		}
 	}
 
-	// Test if we're using unsigned bytes
-	if bits.Test(lcdControl, 4) {
-		tileData = 0x8000
-		unsigned = true
-	}
+	tileData, unsigned = tileDataBase(lcdControl)
 
 	// Work out where to look in background memory.
-	var testBit byte = 3
+	backgroundMemory = bgTileMapBase(lcdControl)
 	if usingWindow {
-		testBit = 6
-	}
-	backgroundMemory = uint16(0x9800)
-	if bits.Test(lcdControl, testBit) {
-		backgroundMemory = 0x9C00
+		backgroundMemory = windowTileMapBase(lcdControl)
 	}
 	return
 }
 
+// Get the address of the tile data selected by bit 4 of the lcdControl
+// register, and if the tile numbers are unsigned.
+func tileDataBase(lcdControl byte) (uint16, bool) {
+	if bits.Test(lcdControl, 4) {
+		return 0x8000, true
+	}
+	return 0x8800, false
+}
+
+// Get the address of the background tile map selected by bit 3 of the
+// lcdControl register.
+func bgTileMapBase(lcdControl byte) uint16 {
+	if bits.Test(lcdControl, 3) {
+		return 0x9C00
+	}
+	return 0x9800
+}
+
+// Get the address of the window tile map selected by bit 6 of the lcdControl
+// register.
+func windowTileMapBase(lcdControl byte) uint16 {
+	if bits.Test(lcdControl, 6) {
+		return 0x9C00
+	}
+	return 0x9800
+}
+
+// BGTileDataBase returns the address of the tile data used by the background
+// and window, which is selected by bit 4 of LCDC. This is either 0x8000, where
+// the tile numbers are unsigned, or 0x8800, where the tile numbers are signed
+// and tile 0 is at 0x9000.
+func (gb *Gameboy) BGTileDataBase() uint16 {
+	base, _ := tileDataBase(gb.Memory.ReadHighRam(uint16(LCDC)))
+	return base
+}
+
+// BGTileMapBase returns the address of the tile map used by the background,
+// which is selected by bit 3 of LCDC. This is either 0x9800 or 0x9C00.
+func (gb *Gameboy) BGTileMapBase() uint16 {
+	return bgTileMapBase(gb.Memory.ReadHighRam(uint16(LCDC)))
+}
+
+// WindowTileMapBase returns the address of the tile map used by the window,
+// which is selected by bit 6 of LCDC. This is either 0x9800 or 0x9C00.
+func (gb *Gameboy) WindowTileMapBase() uint16 {
+	return windowTileMapBase(gb.Memory.ReadHighRam(uint16(LCDC)))
+}
+
 // Render a scanline of the tile map to the graphics output based
 // on the state of the lcdControl register.
 func (gb *Gameboy) renderTiles(lcdControl byte, scanline byte) {
@@ -216,14 +329,7 @@ func (gb *Gameboy) renderTiles(lcdControl byte, scanline byte) {
 		tileAddress := backgroundMemory + tileRow + tileCol
 
 		// Deduce where this tile id is in memory
-		tileLocation := tileData
-		if unsigned {
-			tileNum := int16(gb.Memory.VRAM[tileAddress-0x8000])
-			tileLocation = tileLocation + uint16(tileNum*16)
-		} else {
-			tileNum := int16(int8(gb.Memory.VRAM[tileAddress-0x8000]))
-			tileLocation = uint16(int32(tileLocation) + int32((tileNum+128)*16))
-		}
+		tileLocation := tileDataAddress(tileData, unsigned, gb.Memory.VRAM[tileAddress-0x8000])
 
 		bankOffset := uint16(0x8000)
 
@@ -267,17 +373,34 @@ func (gb *Gameboy) setTilePixel(x, y, tileAttr, colourNum, palette byte, priorit
 	if gb.IsCGB() {
 		cgbPalette := tileAttr & 0x7
 		red, green, blue := gb.BGPalette.get(cgbPalette, colourNum)
-		gb.setPixel(x, y, red, green, blue, true)
+		gb.setPixel(x, y, red, green, blue)
 		gb.bgPriority[x][y] = priority
 	} else {
 		red, green, blue := gb.getColour(colourNum, palette)
-		gb.setPixel(x, y, red, green, blue, true)
+		gb.setPixel(x, y, red, green, blue)
 	}
 
 	// Store for the current scanline so sprite priority can be managed
 	gb.tileScanline[x] = colourNum
 }
 
+// Get the address of the data for a tile number in the tile map. If unsigned is
+// false the tile number is signed, and the data is addressed from 0x9000.
+func tileDataAddress(tileData uint16, unsigned bool, tileNum byte) uint16 {
+	if unsigned {
+		return tileData + uint16(tileNum)*16
+	}
+	return uint16(int32(tileData) + (int32(int8(tileNum))+128)*16)
+}
+
+// Get the height of the sprites from the lcdControl register.
+func spriteHeight(lcdControl byte) int32 {
+	if bits.Test(lcdControl, 2) {
+		return 16
+	}
+	return 8
+}
+
 // Get the RGB colour value for a colour num at an address using the current palette.
 func (gb *Gameboy) getColour(colourNum byte, palette byte) (uint8, uint8, uint8) {
 	hi := colourNum<<1 | 1
@@ -290,10 +413,7 @@ const spritePriorityOffset = 100
 
 // Render the sprites to the screen on the current scanline using the lcdControl register.
 func (gb *Gameboy) renderSprites(lcdControl byte, scanline int32) {
-	var ySize int32 = 8
-	if bits.Test(lcdControl, 2) {
-		ySize = 16
-	}
+	ySize := spriteHeight(lcdControl)
 
 	// Load the two palettes which sprites can be drawn in
 	var palette1 = gb.Memory.ReadHighRam(0xFF48)
@@ -321,17 +441,22 @@ func (gb *Gameboy) renderSprites(lcdControl byte, scanline int32) {
 		tileLocation := gb.Memory.Read(uint16(0xFE00 + index + 2))
 		attributes := gb.Memory.Read(uint16(0xFE00 + index + 3))
 
+		// In 8x16 mode the sprite is drawn from an even tile and the odd tile
+		// after it, so the low bit of the tile index is ignored.
+		if ySize == 16 {
+			tileLocation &= 0xFE
+		}
+
 		yFlip := bits.Test(attributes, 6)
 		xFlip := bits.Test(attributes, 5)
-		priority := !bits.Test(attributes, 7)
-
 		// Bank the sprite data in is (CGB only)
 		var bank uint16 = 0
 		if gb.IsCGB() && bits.Test(attributes, 3) {
 			bank = 1
 		}
 
-		// Set the line to draw based on if the sprite is flipped on the y
+		// Set the line to draw based on if the sprite is flipped on the y. For
+		// 8x16 sprites this also swaps the top and bottom tiles.
 		line := scanline - yPos
 		if yFlip {
 			line = ySize - line - 1
@@ -352,9 +477,10 @@ func (gb *Gameboy) renderSprites(lcdControl byte, scanline int32) {
 			// Check if the pixel has priority.
 			//  - In DMG this is determined by the sprite with the smallest X coordinate,
 			//    then the first sprite in the OAM.
-			//  - In CGB this is determined by the first sprite appearing in the OAM.
+			//  - In CGB this is determined by the first sprite appearing in the OAM,
+			//    unless OPRI selects the DMG behaviour.
 			// We add a fixed 100 to the xPos so we can use the 0 value as the absence of a sprite.
-			if minx[pixel] != 0 && (gb.IsCGB() || minx[pixel] <= xPos+spritePriorityOffset) {
+			if minx[pixel] != 0 && (gb.oamSpritePriority() || minx[pixel] <= xPos+spritePriorityOffset) {
 				continue
 			}
 
@@ -371,10 +497,18 @@ func (gb *Gameboy) renderSprites(lcdControl byte, scanline int32) {
 				continue
 			}
 
+			// Store the xpos of the sprite for this pixel for priority resolution.
+			// This is done even if the pixel is hidden by the background, so
+			// lower priority sprites are not drawn over it.
+			minx[pixel] = xPos + spritePriorityOffset
+			if !gb.spriteHasPriority(lcdControl, attributes, byte(pixel), byte(scanline)) {
+				continue
+			}
+
 			if gb.IsCGB() {
 				cgbPalette := attributes & 0x7
 				red, green, blue := gb.SpritePalette.get(cgbPalette, colourNum)
-				gb.setPixel(byte(pixel), byte(scanline), red, green, blue, priority)
+				gb.setPixel(byte(pixel), byte(scanline), red, green, blue)
 			} else {
 				// Determine the colour palette to use
 				var palette = palette1
@@ -382,23 +516,102 @@ func (gb *Gameboy) renderSprites(lcdControl byte, scanline int32) {
 					palette = palette2
 				}
 				red, green, blue := gb.getColour(colourNum, palette)
-				gb.setPixel(byte(pixel), byte(scanline), red, green, blue, priority)
+				gb.setPixel(byte(pixel), byte(scanline), red, green, blue)
 			}
+		}
+	}
+}
 
-			// Store the xpos of the sprite for this pixel for priority resolution
-			minx[pixel] = xPos + spritePriorityOffset
+// Returns if a sprite pixel with the attributes is drawn over the background
+// at a pixel on the current scanline. Sprites are always drawn over background
+// colour 0. Otherwise:
+//   - On CGB, if LCDC bit 0 is reset sprites are always drawn on top.
+//   - On CGB, if bit 7 of the BG tile attributes is set the background is on top.
+//   - If bit 7 of the sprite attributes is set the background is on top.
+func (gb *Gameboy) spriteHasPriority(lcdControl, attributes, x, y byte) bool {
+	if gb.tileScanline[x] == 0 {
+		return true
+	}
+	if gb.IsCGB() {
+		if !bits.Test(lcdControl, 0) {
+			return true
+		}
+		if gb.bgPriority[x][y] {
+			return false
 		}
 	}
+	return !bits.Test(attributes, 7)
 }
 
 // Set a pixel in the graphics screen data.
-func (gb *Gameboy) setPixel(x byte, y byte, r uint8, g uint8, b uint8, priority bool) {
-	// If priority is false then sprite pixel is only set if tile colour is 0
-	if (priority && !gb.bgPriority[x][y]) || gb.tileScanline[x] == 0 {
-		gb.screenData[x][y][0] = r
-		gb.screenData[x][y][1] = g
-		gb.screenData[x][y][2] = b
+func (gb *Gameboy) setPixel(x byte, y byte, r uint8, g uint8, b uint8) {
+	gb.screenData[x][y][0] = r
+	gb.screenData[x][y][1] = g
+	gb.screenData[x][y][2] = b
+}
+
+// GetFrameScaled returns the frame from GetFrame upscaled by an integer factor
+// using nearest-neighbour scaling. The image is reused between calls, so it
+// will be overwritten by the next call. An error is returned if the factor is
+// not positive.
+func (gb *Gameboy) GetFrameScaled(factor int) (*image.RGBA, error) {
+	if factor <= 0 {
+		return nil, fmt.Errorf("invalid scale factor %v, must be positive", factor)
+	}
+	bounds := image.Rect(0, 0, ScreenWidth*factor, ScreenHeight*factor)
+	if gb.scaledFrame == nil || gb.scaledFrame.Rect != bounds {
+		gb.scaledFrame = image.NewRGBA(bounds)
+	}
+
+	frame := gb.GetFrame()
+	img := gb.scaledFrame
+	for y := 0; y < ScreenHeight; y++ {
+		// Write the first row of the scaled pixels and copy it to the others
+		row := img.Pix[y*factor*img.Stride : (y*factor+1)*img.Stride]
+		for x := 0; x < ScreenWidth; x++ {
+			col := frame[x][y]
+			for i := x * factor * 4; i < (x+1)*factor*4; i += 4 {
+				row[i], row[i+1], row[i+2], row[i+3] = col[0], col[1], col[2], 0xFF
+			}
+		}
+		for i := 1; i < factor; i++ {
+			copy(img.Pix[(y*factor+i)*img.Stride:], row)
+		}
+	}
+	return img, nil
+}
+
+// GetFrameRegion returns a region of the frame from GetFrame. The bounds of the
+// image are the region, so pixels are at the same coordinates as on the screen.
+// The image is reused between calls, so it will be overwritten by the next call.
+// An error is returned if the region is empty or not within the screen.
+func (gb *Gameboy) GetFrameRegion(region image.Rectangle) (*image.RGBA, error) {
+	screen := image.Rect(0, 0, ScreenWidth, ScreenHeight)
+	if region.Empty() || !region.In(screen) {
+		return nil, fmt.Errorf("invalid frame region %v, must be within %v", region, screen)
+	}
+	if gb.regionFrame == nil || gb.regionFrame.Rect != region {
+		gb.regionFrame = image.NewRGBA(region)
 	}
+
+	frame := gb.GetFrame()
+	img := gb.regionFrame
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		i := img.PixOffset(region.Min.X, y)
+		for x := region.Min.X; x < region.Max.X; x++ {
+			col := frame[x][y]
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = col[0], col[1], col[2], 0xFF
+			i += 4
+		}
+	}
+	return img, nil
+}
+
+// ClearScreen sets every pixel of the screen to white, which removes the last
+// frame from PreparedData until the next frame has been rendered.
+func (gb *Gameboy) ClearScreen() {
+	gb.screenCleared = false
+	gb.clearScreen()
 }
 
 // Clear the screen by setting every pixel to white.
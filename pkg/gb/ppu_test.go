@@ -8,6 +8,8 @@ import (
 	"os"
 	"testing"
 
+	"github.com/Humpheh/goboy/pkg/bits"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -70,3 +72,422 @@ func loadImage(filename string) (image.Image, error) {
 	}
 	return img, nil
 }
+
+// TestUpdateAllocations asserts that once the emulator is running there are no
+// allocations made while updating each frame.
+func TestUpdateAllocations(t *testing.T) {
+	for _, cgb := range []bool{false, true} {
+		t.Run(fmt.Sprintf("CGB %v", cgb), func(t *testing.T) {
+			var options []GameboyOption
+			if cgb {
+				options = append(options, WithCGBEnabled())
+			}
+			gb, err := NewGameboy("./../../roms/mooneye/runnable/sprite_priority.gb", options...)
+			require.NoError(t, err, "error in init gb %v", err)
+			gb.RunFrames(10)
+
+			allocs := testing.AllocsPerRun(20, func() { gb.Update() })
+			require.Zero(t, allocs)
+		})
+	}
+}
+
+func TestCurrentScanlineAndMode(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/mooneye/runnable/sprite_priority.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.RunFrames(10)
+
+	seen := map[int]bool{}
+	for cycles := 0; cycles < CyclesFrame; {
+		cyclesOp := gb.ExecuteNextOpcode()
+		cycles += cyclesOp
+		gb.updateGraphics(cyclesOp)
+		gb.updateTimers(cyclesOp)
+		cycles += gb.doInterrupts()
+
+		mode := gb.CurrentPPUMode()
+		seen[mode] = true
+
+		line := gb.CurrentScanline()
+		require.True(t, line >= 0 && line <= 153, "invalid scanline %v", line)
+		if mode == 1 {
+			require.True(t, line >= ScreenHeight, "V-Blank on line %v", line)
+		}
+	}
+	require.Len(t, seen, 4, "all modes should be seen in a frame")
+}
+
+func TestVBlankCallback(t *testing.T) {
+	calls := 0
+	gb, err := NewGameboy("./../../roms/mooneye/runnable/sprite_priority.gb", WithVBlankCallback(func(gb *Gameboy) {
+		assert.Equal(t, ScreenHeight, gb.CurrentScanline())
+		calls++
+	}))
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// Wait for the rom to turn on the LCD.
+	gb.RunFrames(10)
+	calls = 0
+	gb.RunFrames(10)
+	assert.InDelta(t, 10, calls, 1)
+}
+
+// Returns if every pixel in a frame is white.
+func isBlankFrame(frame *[ScreenWidth][ScreenHeight][3]uint8) bool {
+	for x := range frame {
+		for y := range frame[x] {
+			if frame[x][y] != [3]uint8{255, 255, 255} {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestGetFrame(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/mooneye/runnable/sprite_priority.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.RunFrames(20)
+
+	assert.True(t, gb.LCDEnabled())
+	assert.False(t, isBlankFrame(gb.GetFrame()), "expected a rendered frame")
+
+	gb.Memory.HighRAM[0x40] &^= 0x80
+	assert.False(t, gb.LCDEnabled())
+	assert.True(t, isBlankFrame(gb.GetFrame()), "expected a blank frame when the LCD is off")
+}
+
+func TestClearScreen(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/mooneye/runnable/sprite_priority.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.RunFrames(20)
+	require.False(t, isBlankFrame(&gb.PreparedData))
+
+	gb.ClearScreen()
+	assert.True(t, isBlankFrame(&gb.PreparedData))
+
+	gb.RunFrames(2)
+	require.False(t, isBlankFrame(&gb.PreparedData))
+	require.NoError(t, gb.LoadROM("./../../roms/mooneye/runnable/sprite_priority.gb"))
+	assert.True(t, isBlankFrame(&gb.PreparedData), "screen should be cleared when loading a ROM")
+}
+
+func TestGetFrameScaled(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/mooneye/runnable/sprite_priority.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.RunFrames(20)
+
+	_, err = gb.GetFrameScaled(0)
+	assert.Error(t, err)
+	_, err = gb.GetFrameScaled(-1)
+	assert.Error(t, err)
+
+	img, err := gb.GetFrameScaled(3)
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, ScreenWidth*3, ScreenHeight*3), img.Bounds())
+	for _, p := range []image.Point{{0, 0}, {50, 20}, {159, 143}, {80, 100}} {
+		col := gb.PreparedData[p.X][p.Y]
+		expected := color.RGBA{R: col[0], G: col[1], B: col[2], A: 0xFF}
+		for dx := 0; dx < 3; dx++ {
+			for dy := 0; dy < 3; dy++ {
+				assert.Equal(t, expected, img.RGBAAt(p.X*3+dx, p.Y*3+dy), "unexpected colour for pixel %v", p)
+			}
+		}
+	}
+
+	// The image should be reused between calls with the same factor
+	again, err := gb.GetFrameScaled(3)
+	require.NoError(t, err)
+	assert.Same(t, img, again)
+	assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() {
+		gb.GetFrameScaled(3)
+	}))
+}
+
+func TestGetFrameRegion(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/mooneye/runnable/sprite_priority.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.RunFrames(20)
+
+	for _, region := range []image.Rectangle{
+		image.Rect(0, 0, 0, 0),
+		image.Rect(-1, 0, 10, 10),
+		image.Rect(150, 130, 161, 144),
+		image.Rect(0, 0, ScreenWidth, ScreenHeight+1),
+	} {
+		_, err = gb.GetFrameRegion(region)
+		assert.Error(t, err, "region %v should be invalid", region)
+	}
+
+	region := image.Rect(40, 10, 120, 130)
+	img, err := gb.GetFrameRegion(region)
+	require.NoError(t, err)
+	require.Equal(t, region, img.Bounds())
+	for _, p := range []image.Point{{40, 10}, {50, 20}, {119, 129}, {80, 100}} {
+		col := gb.PreparedData[p.X][p.Y]
+		expected := color.RGBA{R: col[0], G: col[1], B: col[2], A: 0xFF}
+		assert.Equal(t, expected, img.RGBAAt(p.X, p.Y), "unexpected colour for pixel %v", p)
+	}
+
+	// The whole screen should match the scaled frame at its native size
+	full, err := gb.GetFrameRegion(image.Rect(0, 0, ScreenWidth, ScreenHeight))
+	require.NoError(t, err)
+	scaled, err := gb.GetFrameScaled(1)
+	require.NoError(t, err)
+	assert.Equal(t, scaled.Pix, full.Pix)
+
+	// The image should be reused between calls with the same region
+	again, err := gb.GetFrameRegion(image.Rect(0, 0, ScreenWidth, ScreenHeight))
+	require.NoError(t, err)
+	assert.Same(t, full, again)
+	assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() {
+		gb.GetFrameRegion(image.Rect(0, 0, ScreenWidth, ScreenHeight))
+	}))
+}
+
+// Fill a tile in VRAM bank 0 with a single colour.
+func fillTile(gb *Gameboy, tile int, colourNum byte) {
+	var data1, data2 byte
+	if bits.Test(colourNum, 0) {
+		data1 = 0xFF
+	}
+	if bits.Test(colourNum, 1) {
+		data2 = 0xFF
+	}
+	for i := 0; i < 16; i += 2 {
+		gb.Memory.VRAM[tile*16+i] = data1
+		gb.Memory.VRAM[tile*16+i+1] = data2
+	}
+}
+
+func TestMidFrameLCDC(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// The BG map uses tile 0, which has colour 3 in the 0x8000 tile data and
+	// colour 1 in the 0x8800 tile data.
+	gb.Memory.VRAM = [0x4000]byte{}
+	fillTile(gb, 0, 3)
+	fillTile(gb, 256, 1)
+	gb.Memory.HighRAM[0xFF47-0xFF00] = 0xE4
+	gb.Memory.HighRAM[0xFF42-0xFF00] = 0
+	gb.Memory.HighRAM[0xFF43-0xFF00] = 0
+	gb.Memory.HighRAM[LCDC-0xFF00] = 0x91
+	gb.Memory.HighRAM[0x44] = 0
+	gb.scanlineCounter = 456
+
+	// Switch the tile data half way down the screen for each line, such as in
+	// an LY=LYC interrupt, and switch back before the next frame.
+	const switchLine = 72
+	for frameDone := false; !frameDone; {
+		line := gb.CurrentScanline()
+		gb.updateGraphics(4)
+		if gb.CurrentScanline() == switchLine && line != switchLine {
+			gb.Memory.HighRAM[LCDC-0xFF00] = 0x81
+		}
+		frameDone = line == 153 && gb.CurrentScanline() == 0
+	}
+
+	r, g, b := gb.getColour(3, 0xE4)
+	top := [3]uint8{r, g, b}
+	r, g, b = gb.getColour(1, 0xE4)
+	bottom := [3]uint8{r, g, b}
+	for y := 0; y < ScreenHeight; y++ {
+		expected := top
+		if y >= switchLine {
+			expected = bottom
+		}
+		require.Equal(t, expected, gb.PreparedData[0][y], "incorrect pixel on line %v", y)
+		require.Equal(t, expected, gb.PreparedData[ScreenWidth-1][y], "incorrect pixel on line %v", y)
+	}
+}
+
+func TestTileBases(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	tests := []struct {
+		lcdc                    byte
+		tileData, bgMap, winMap uint16
+	}{
+		{0x80, 0x8800, 0x9800, 0x9800},
+		{0x88, 0x8800, 0x9C00, 0x9800},
+		{0x90, 0x8000, 0x9800, 0x9800},
+		{0xC0, 0x8800, 0x9800, 0x9C00},
+		{0xFF, 0x8000, 0x9C00, 0x9C00},
+	}
+	for _, test := range tests {
+		gb.Memory.HighRAM[LCDC-0xFF00] = test.lcdc
+		assert.Equal(t, test.tileData, gb.BGTileDataBase(), "incorrect tile data for LCDC %#x", test.lcdc)
+		assert.Equal(t, test.bgMap, gb.BGTileMapBase(), "incorrect BG map for LCDC %#x", test.lcdc)
+		assert.Equal(t, test.winMap, gb.WindowTileMapBase(), "incorrect window map for LCDC %#x", test.lcdc)
+	}
+}
+
+func TestRenderSprites_8x16(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	fillTile(gb, 2, 3)
+	fillTile(gb, 3, 1)
+	fillTile(gb, 4, 2)
+	gb.Memory.HighRAM[0xFF48-0xFF00] = 0xE4
+
+	// An odd tile index should draw from tiles 2 and 3.
+	gb.Memory.OAM[0] = 16
+	gb.Memory.OAM[1] = 8
+	gb.Memory.OAM[2] = 3
+
+	tests := []struct {
+		name        string
+		attributes  byte
+		top, bottom byte
+	}{
+		{name: "normal", attributes: 0x00, top: 3, bottom: 1},
+		{name: "vertical flip", attributes: 0x40, top: 1, bottom: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gb.Memory.OAM[3] = test.attributes
+			gb.tileScanline = [ScreenWidth]uint8{}
+			for line := int32(0); line < 16; line++ {
+				gb.renderSprites(0x86, line)
+			}
+			top := gb.screenData[0][0]
+			bottom := gb.screenData[0][15]
+
+			red, green, blue := gb.getColour(test.top, 0xE4)
+			assert.Equal(t, [3]uint8{red, green, blue}, top, "incorrect top half")
+			red, green, blue = gb.getColour(test.bottom, 0xE4)
+			assert.Equal(t, [3]uint8{red, green, blue}, bottom, "incorrect bottom half")
+			assert.Equal(t, gb.screenData[0][7], top, "top tile should fill the first 8 lines")
+			assert.Equal(t, gb.screenData[0][8], bottom, "bottom tile should fill the last 8 lines")
+		})
+	}
+}
+
+func TestObjectPriorityMode(t *testing.T) {
+	dmg, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled())
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// OPRI is not mapped on DMG and only bit 0 can be written on CGB
+	dmg.Memory.Write(0xFF6C, 0x01)
+	assert.Equal(t, byte(0xFF), dmg.Memory.Read(0xFF6C))
+	assert.Equal(t, byte(0xFE), gb.Memory.Read(0xFF6C))
+	gb.Memory.Write(0xFF6C, 0xFF)
+	assert.Equal(t, byte(0xFF), gb.Memory.Read(0xFF6C))
+
+	fillTile(gb, 1, 1)
+	fillTile(gb, 2, 2)
+	// Give the two sprites different colours, red and blue
+	gb.SpritePalette.Palette[0*8+1*2] = 0x1F
+	gb.SpritePalette.Palette[0*8+1*2+1] = 0x00
+	gb.SpritePalette.Palette[1*8+2*2] = 0x00
+	gb.SpritePalette.Palette[1*8+2*2+1] = 0x7C
+
+	// Sprite 0 is to the right of sprite 1, and they overlap at X 4 to 7.
+	copy(gb.Memory.OAM[:], []byte{
+		16, 12, 1, 0x00,
+		16, 8, 2, 0x01,
+	})
+
+	tests := []struct {
+		name     string
+		opri     byte
+		expected int
+	}{
+		{name: "CGB priority", opri: 0x00, expected: 0},
+		{name: "DMG priority", opri: 0x01, expected: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gb.Memory.Write(0xFF6C, test.opri)
+			gb.tileScanline = [ScreenWidth]uint8{}
+			gb.renderSprites(0x82, 0)
+
+			red, green, blue := gb.SpritePalette.get(byte(test.expected), byte(test.expected+1))
+			assert.Equal(t, [3]uint8{red, green, blue}, gb.screenData[5][0])
+
+			index, ok := gb.SpriteAt(5, 0)
+			require.True(t, ok)
+			assert.Equal(t, test.expected, index)
+		})
+	}
+}
+
+func TestSpriteHasPriority(t *testing.T) {
+	dmg, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	cgb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithModel(CGB))
+	require.NoError(t, err, "error in init gb %v", err)
+	require.True(t, cgb.IsCGB())
+
+	tests := []struct {
+		gb         *Gameboy
+		bgColour   byte
+		lcdControl byte
+		bgPriority bool
+		attributes byte
+		expected   bool
+	}{
+		// DMG only uses the sprite priority bit
+		{gb: dmg, bgColour: 0, lcdControl: 0x01, attributes: 0x80, expected: true},
+		{gb: dmg, bgColour: 1, lcdControl: 0x01, attributes: 0x00, expected: true},
+		{gb: dmg, bgColour: 1, lcdControl: 0x01, attributes: 0x80, expected: false},
+		{gb: dmg, bgColour: 1, lcdControl: 0x01, bgPriority: true, attributes: 0x00, expected: true},
+
+		// CGB with LCDC bit 0 reset gives sprites priority
+		{gb: cgb, bgColour: 1, lcdControl: 0x00, bgPriority: true, attributes: 0x80, expected: true},
+		{gb: cgb, bgColour: 1, lcdControl: 0x00, bgPriority: false, attributes: 0x00, expected: true},
+
+		// CGB with LCDC bit 0 set uses the BG and sprite priority bits
+		{gb: cgb, bgColour: 1, lcdControl: 0x01, bgPriority: false, attributes: 0x00, expected: true},
+		{gb: cgb, bgColour: 1, lcdControl: 0x01, bgPriority: false, attributes: 0x80, expected: false},
+		{gb: cgb, bgColour: 1, lcdControl: 0x01, bgPriority: true, attributes: 0x00, expected: false},
+		{gb: cgb, bgColour: 1, lcdControl: 0x01, bgPriority: true, attributes: 0x80, expected: false},
+
+		// Background colour 0 is always behind sprites
+		{gb: cgb, bgColour: 0, lcdControl: 0x01, bgPriority: true, attributes: 0x80, expected: true},
+	}
+	for i, test := range tests {
+		test.gb.tileScanline[5] = test.bgColour
+		test.gb.bgPriority[5][3] = test.bgPriority
+		actual := test.gb.spriteHasPriority(test.lcdControl, test.attributes, 5, 3)
+		assert.Equal(t, test.expected, actual, "incorrect priority for case %v", i)
+	}
+}
+
+func TestSTATWriteBug(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     Model
+		lcdc      byte
+		status    byte
+		interrupt bool
+	}{
+		{"hblank", DMG, 0x91, 0x80, true},
+		{"vblank", DMG, 0x91, 0x81, true},
+		{"oam scan", DMG, 0x91, 0x82, false},
+		{"drawing", DMG, 0x91, 0x83, false},
+		{"coincidence", DMG, 0x91, 0x87, true},
+		{"lcd off", DMG, 0x11, 0x80, false},
+		{"mgb", MGB, 0x91, 0x80, true},
+		{"cgb", CGB, 0x91, 0x80, false},
+		{"cgb coincidence", CGB, 0x91, 0x87, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithModel(test.model))
+			require.NoError(t, err, "error in init gb %v", err)
+			gb.Memory.HighRAM[0x40] = test.lcdc
+			gb.Memory.HighRAM[0x41] = test.status
+			gb.Memory.Write(0xFF0F, 0)
+
+			gb.Memory.Write(0xFF41, 0x00)
+			assert.Equal(t, test.interrupt, gb.Memory.Read(0xFF0F)&0x2 != 0)
+			assert.Equal(t, test.status, gb.Memory.Read(0xFF41), "mode and coincidence should not change")
+		})
+	}
+}
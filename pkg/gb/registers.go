@@ -0,0 +1,105 @@
+package gb
+
+import "fmt"
+
+// IORegister is the address of a hardware register in 0xFF00-0xFFFF. The
+// register constants, such as STAT and IE, can be used with ReadIO and WriteIO
+// instead of their addresses, and print their names.
+type IORegister uint16
+
+const (
+	// P1 is the joypad register, which selects the buttons or directions to
+	// read.
+	P1 IORegister = 0xFF00
+	// IF is the interrupt flag register, with a bit for each requested
+	// interrupt.
+	IF IORegister = 0xFF0F
+
+	// NR50 is the master volume register.
+	NR50 IORegister = 0xFF24
+	// NR51 is the sound panning register, which selects the channels output
+	// on the left and right.
+	NR51 IORegister = 0xFF25
+	// NR52 is the sound on/off register.
+	NR52 IORegister = 0xFF26
+
+	// STAT is the LCD status register.
+	STAT IORegister = 0xFF41
+	// SCY is the vertical scroll of the background.
+	SCY IORegister = 0xFF42
+	// SCX is the horizontal scroll of the background.
+	SCX IORegister = 0xFF43
+	// LY is the scanline the PPU is currently on, which is read only.
+	LY IORegister = 0xFF44
+	// LYC is compared with LY to request the STAT interrupt.
+	LYC IORegister = 0xFF45
+	// DMA starts an OAM DMA transfer from the page written to it.
+	DMA IORegister = 0xFF46
+	// BGP is the DMG background palette.
+	BGP IORegister = 0xFF47
+	// OBP0 is the first DMG sprite palette.
+	OBP0 IORegister = 0xFF48
+	// OBP1 is the second DMG sprite palette.
+	OBP1 IORegister = 0xFF49
+	// WY is the Y position of the window.
+	WY IORegister = 0xFF4A
+	// WX is the X position of the window plus 7.
+	WX IORegister = 0xFF4B
+
+	// KEY1 prepares a CGB speed switch.
+	KEY1 IORegister = 0xFF4D
+	// VBK selects the CGB VRAM bank.
+	VBK IORegister = 0xFF4F
+	// HDMA5 starts a CGB VRAM DMA transfer.
+	HDMA5 IORegister = 0xFF55
+	// BCPS is the CGB background palette index.
+	BCPS IORegister = 0xFF68
+	// BCPD is the CGB background palette data.
+	BCPD IORegister = 0xFF69
+	// OCPS is the CGB sprite palette index.
+	OCPS IORegister = 0xFF6A
+	// OCPD is the CGB sprite palette data.
+	OCPD IORegister = 0xFF6B
+	// OPRI is the CGB object priority mode.
+	OPRI IORegister = 0xFF6C
+	// SVBK selects the CGB WRAM bank.
+	SVBK IORegister = 0xFF70
+
+	// IE is the interrupt enable register, with a bit for each interrupt
+	// which can be serviced.
+	IE IORegister = 0xFFFF
+)
+
+// Names of the registers with constants.
+var ioRegisterNames = map[IORegister]string{
+	P1: "P1", SB: "SB", SC: "SC", DIV: "DIV", TIMA: "TIMA", TMA: "TMA", TAC: "TAC", IF: "IF",
+	NR50: "NR50", NR51: "NR51", NR52: "NR52",
+	LCDC: "LCDC", STAT: "STAT", SCY: "SCY", SCX: "SCX", LY: "LY", LYC: "LYC", DMA: "DMA",
+	BGP: "BGP", OBP0: "OBP0", OBP1: "OBP1", WY: "WY", WX: "WX",
+	KEY1: "KEY1", VBK: "VBK", HDMA5: "HDMA5", RP: "RP",
+	BCPS: "BCPS", BCPD: "BCPD", OCPS: "OCPS", OCPD: "OCPD", OPRI: "OPRI", SVBK: "SVBK",
+	IE: "IE",
+}
+
+// String returns the name of the register, or its address if it does not have
+// a name.
+func (reg IORegister) String() string {
+	if name, ok := ioRegisterNames[reg]; ok {
+		return name
+	}
+	return fmt.Sprintf("%#04x", uint16(reg))
+}
+
+// ReadIO reads the value of a hardware register. This is the same as reading
+// its address with Memory.Read, so unused bits and unmapped registers read
+// the same as they do for the CPU.
+func (gb *Gameboy) ReadIO(reg IORegister) byte {
+	return gb.Memory.Read(uint16(reg))
+}
+
+// WriteIO writes a value to a hardware register. This is the same as writing
+// to its address with Memory.Write, so writes have the same effects as when
+// they are made by the CPU, such as writing to DMA starting a transfer.
+func (gb *Gameboy) WriteIO(reg IORegister, value byte) {
+	gb.Memory.Write(uint16(reg), value)
+}
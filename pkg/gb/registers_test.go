@@ -0,0 +1,31 @@
+package gb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIORegisters(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.WriteIO(SCX, 0x12)
+	assert.Equal(t, byte(0x12), gb.Memory.Read(0xFF43))
+	assert.Equal(t, byte(0x12), gb.ReadIO(SCX))
+
+	// Writes have the same effects as from the CPU.
+	gb.WriteIO(LY, 0x40)
+	assert.Equal(t, byte(0x00), gb.ReadIO(LY), "LY should be reset when written")
+	gb.WriteIO(IF, 0x00)
+	assert.Equal(t, byte(0xE0), gb.ReadIO(IF), "unused bits should read as 1")
+	assert.Equal(t, byte(0xFF), gb.ReadIO(SVBK), "CGB registers are not mapped on DMG")
+
+	assert.Equal(t, "LCDC", LCDC.String())
+	assert.Equal(t, "IE", IE.String())
+	assert.Equal(t, "STAT", fmt.Sprint(STAT))
+	assert.Equal(t, "SC", fmt.Sprint(SC))
+	assert.Equal(t, "0xff03", IORegister(0xFF03).String())
+}
@@ -0,0 +1,77 @@
+package gb
+
+import "github.com/Humpheh/goboy/pkg/bits"
+
+const (
+	// SB is the serial transfer data register.
+	SB IORegister = 0xFF01
+	// SC is the serial transfer control register.
+	SC IORegister = 0xFF02
+)
+
+// Number of cycles to shift each bit of a serial transfer using the internal
+// clock, which runs at 8192Hz, or 262144Hz with the CGB fast clock.
+const (
+	serialBitCycles     = 512
+	serialFastBitCycles = 16
+)
+
+// Write to the serial control register. Setting bit 7 starts a transfer, which
+// is only clocked if bit 0 selects the internal clock. With the external clock
+// the transfer waits for a link partner, so never completes.
+func (gb *Gameboy) writeSerialControl(value byte) {
+	gb.Memory.HighRAM[SC-0xFF00] = value
+	gb.serialBits = 0
+	if !bits.Test(value, 7) || !bits.Test(value, 0) {
+		return
+	}
+
+	out := gb.Memory.HighRAM[SB-0xFF00]
+	if f := gb.options.transferFunction; f != nil {
+		f(out)
+	}
+	gb.serialIn = 0xFF
+	if f := gb.options.serialExchange; f != nil {
+		gb.serialIn = f(out)
+	}
+	gb.serialBits = 8
+	gb.serialCounter = serialBitCycles
+	if gb.IsCGB() && bits.Test(value, 1) {
+		gb.serialCounter = serialFastBitCycles
+	}
+}
+
+// Read the serial control register, where the unused bits read as 1.
+func (gb *Gameboy) readSerialControl() byte {
+	if gb.IsCGB() {
+		return gb.Memory.HighRAM[SC-0xFF00] | 0x7C
+	}
+	return gb.Memory.HighRAM[SC-0xFF00] | 0x7E
+}
+
+// Clock a serial transfer using the internal clock. Each bit period the data
+// register is shifted left, shifting in the next bit received from the link
+// partner, which is all 1s if there is no partner. Once all 8 bits are shifted,
+// bit 7 of the control register is reset and the serial interrupt is requested.
+func (gb *Gameboy) updateSerial(cycles int) {
+	if gb.serialBits == 0 {
+		return
+	}
+	gb.serialCounter -= cycles
+	for gb.serialCounter <= 0 && gb.serialBits > 0 {
+		sb := &gb.Memory.HighRAM[SB-0xFF00]
+		gb.serialBits--
+		*sb = *sb<<1 | bits.Val(gb.serialIn, byte(gb.serialBits))
+
+		if gb.serialBits == 0 {
+			gb.Memory.HighRAM[SC-0xFF00] = bits.Reset(gb.Memory.HighRAM[SC-0xFF00], 7)
+			gb.requestInterrupt(3)
+			return
+		}
+		if gb.IsCGB() && bits.Test(gb.Memory.HighRAM[SC-0xFF00], 1) {
+			gb.serialCounter += serialFastBitCycles
+		} else {
+			gb.serialCounter += serialBitCycles
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/Humpheh/goboy/pkg/bits"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerialTransfer(t *testing.T) {
+	var sent []byte
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithTransferFunction(func(val byte) {
+		sent = append(sent, val)
+	}))
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.Memory.Write(0xFF0F, 0)
+
+	gb.WriteIO(SB, 0x42)
+	gb.WriteIO(SC, 0x81)
+	assert.Equal(t, []byte{0x42}, sent)
+	assert.Equal(t, byte(0xFF), gb.ReadIO(SC), "transfer should be in progress")
+
+	gb.updateSerial(serialBitCycles - 4)
+	assert.Equal(t, byte(0x42), gb.ReadIO(SB))
+	gb.updateSerial(4)
+	assert.Equal(t, byte(0x85), gb.ReadIO(SB), "first bit should be shifted out")
+	assert.Equal(t, byte(0xFF), gb.ReadIO(SC))
+	assert.False(t, bits.Test(gb.Memory.Read(0xFF0F), 3))
+
+	gb.updateSerial(7 * serialBitCycles)
+	assert.Equal(t, byte(0xFF), gb.ReadIO(SB), "no link partner should receive 0xFF")
+	assert.Equal(t, byte(0x7F), gb.ReadIO(SC), "transfer should be complete")
+	assert.True(t, bits.Test(gb.Memory.Read(0xFF0F), 3), "serial interrupt should be requested")
+}
+
+func TestSerialTransfer_Exchange(t *testing.T) {
+	var sent, exchanged []byte
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb",
+		WithTransferFunction(func(val byte) {
+			sent = append(sent, val)
+		}),
+		WithSerialExchange(func(out byte) byte {
+			exchanged = append(exchanged, out)
+			return ^out
+		}))
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.Memory.Write(0xFF0F, 0)
+
+	gb.WriteIO(SB, 0x42)
+	gb.WriteIO(SC, 0x81)
+	assert.Equal(t, []byte{0x42}, sent)
+	assert.Equal(t, []byte{0x42}, exchanged)
+
+	gb.updateSerial(serialBitCycles)
+	assert.Equal(t, byte(0x85), gb.ReadIO(SB), "first received bit should be shifted in")
+	gb.updateSerial(7 * serialBitCycles)
+	assert.Equal(t, byte(0xBD), gb.ReadIO(SB), "received byte should be in the data register")
+	assert.Equal(t, byte(0x7F), gb.ReadIO(SC), "transfer should be complete")
+	assert.True(t, bits.Test(gb.Memory.Read(0xFF0F), 3), "serial interrupt should be requested")
+
+	// The external clock is not driven by the link partner
+	gb.WriteIO(SC, 0x80)
+	assert.Equal(t, []byte{0x42}, exchanged)
+}
+
+func TestSerialTransfer_ExternalClock(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.WriteIO(SB, 0x42)
+	gb.WriteIO(SC, 0x80)
+	gb.updateSerial(100 * serialBitCycles)
+	assert.Equal(t, byte(0x42), gb.ReadIO(SB))
+	assert.Equal(t, byte(0xFE), gb.ReadIO(SC), "transfer should wait for the external clock")
+}
+
+func TestSerialTransfer_CGBFastClock(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithCGBEnabled())
+	require.NoError(t, err, "error in init gb %v", err)
+
+	gb.WriteIO(SC, 0x83)
+	assert.Equal(t, byte(0xFF), gb.ReadIO(SC))
+	gb.updateSerial(8 * serialFastBitCycles)
+	assert.Equal(t, byte(0x7F), gb.ReadIO(SC))
+}
@@ -0,0 +1,271 @@
+package gb
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+
+	"github.com/Humpheh/goboy/pkg/apu"
+	"github.com/Humpheh/goboy/pkg/cart"
+)
+
+var stateHashTable = crc64.MakeTable(crc64.ECMA)
+
+// StateHash returns a hash of the state of the emulator, made up of the CPU
+// registers, all of the memory including the cartridge RAM, the selected
+// cartridge banks, the sound registers, and the timer and interrupt state. Two emulators which are run with the same inputs will have
+// the same hash after each frame, so this can be used to find the frame at
+// which they have diverged. The hash is fast enough to be calculated on every
+// frame.
+func (gb *Gameboy) StateHash() uint64 {
+	var state [registerStateSize]byte
+	gb.packRegisterState(state[:])
+
+	hash := crc64.Update(0, stateHashTable, state[:])
+	hash = crc64.Update(hash, stateHashTable, gb.Memory.HighRAM[:])
+	hash = crc64.Update(hash, stateHashTable, gb.Memory.VRAM[:])
+	hash = crc64.Update(hash, stateHashTable, gb.Memory.WRAM[:])
+	hash = crc64.Update(hash, stateHashTable, gb.Memory.OAM[:])
+	hash = crc64.Update(hash, stateHashTable, gb.BGPalette.Palette)
+	hash = crc64.Update(hash, stateHashTable, gb.SpritePalette.Palette)
+
+	var sound [apu.PackedStateSize]byte
+	gb.Sound.PackState(sound[:])
+	hash = crc64.Update(hash, stateHashTable, sound[:])
+
+	if gb.IsGameLoaded() {
+		var banks [bankStateSize]byte
+		packBankState(banks[:], gb.Memory.Cart.BankState())
+		hash = crc64.Update(hash, stateHashTable, banks[:])
+		hash = crc64.Update(hash, stateHashTable, gb.Memory.Cart.RAM())
+	}
+	return hash
+}
+
+// Size of the cartridge banking state packed by packBankState.
+const bankStateSize = 16
+
+// Pack the banking state of a cartridge into a buffer.
+func packBankState(state []byte, banks cart.BankState) {
+	binary.LittleEndian.PutUint32(state[0:], banks.RomBank0)
+	binary.LittleEndian.PutUint32(state[4:], banks.RomBank)
+	binary.LittleEndian.PutUint32(state[8:], banks.RamBank)
+	state[12] = boolByte(banks.RamEnabled)
+	state[13] = banks.BankingMode
+	state[14] = boolByte(banks.RTCLatched)
+	state[15] = boolByte(banks.CameraMode)
+}
+
+// Size of the CPU, timer and interrupt state packed by packRegisterState.
+const registerStateSize = 28
+
+// Pack the CPU registers and the timer and interrupt state into a buffer.
+func (gb *Gameboy) packRegisterState(state []byte) {
+	binary.LittleEndian.PutUint16(state[0:], gb.CPU.AF.HiLo())
+	binary.LittleEndian.PutUint16(state[2:], gb.CPU.BC.HiLo())
+	binary.LittleEndian.PutUint16(state[4:], gb.CPU.DE.HiLo())
+	binary.LittleEndian.PutUint16(state[6:], gb.CPU.HL.HiLo())
+	binary.LittleEndian.PutUint16(state[8:], gb.CPU.PC)
+	binary.LittleEndian.PutUint16(state[10:], gb.CPU.SP.HiLo())
+	binary.LittleEndian.PutUint32(state[12:], uint32(gb.CPU.Divider))
+	binary.LittleEndian.PutUint32(state[16:], uint32(gb.timerCounter))
+	binary.LittleEndian.PutUint32(state[20:], uint32(gb.scanlineCounter))
+	state[24] = gb.interruptFlags()
+	state[25] = gb.Memory.VRAMBank
+	state[26] = gb.Memory.WRAMBank
+	state[27] = gb.currentSpeed
+}
+
+// Unpack the state packed by packRegisterState.
+func (gb *Gameboy) unpackRegisterState(state []byte) {
+	gb.CPU.AF.Set(binary.LittleEndian.Uint16(state[0:]))
+	gb.CPU.BC.Set(binary.LittleEndian.Uint16(state[2:]))
+	gb.CPU.DE.Set(binary.LittleEndian.Uint16(state[4:]))
+	gb.CPU.HL.Set(binary.LittleEndian.Uint16(state[6:]))
+	gb.CPU.PC = binary.LittleEndian.Uint16(state[8:])
+	gb.CPU.SP.Set(binary.LittleEndian.Uint16(state[10:]))
+	gb.CPU.Divider = int(int32(binary.LittleEndian.Uint32(state[12:])))
+	gb.timerCounter = int(int32(binary.LittleEndian.Uint32(state[16:])))
+	gb.scanlineCounter = int(int32(binary.LittleEndian.Uint32(state[20:])))
+	gb.setInterruptFlags(state[24])
+	gb.Memory.VRAMBank = state[25]
+	gb.Memory.WRAMBank = state[26]
+	gb.currentSpeed = state[27]
+}
+
+// Get the interrupt and CPU power state packed into a byte.
+func (gb *Gameboy) interruptFlags() byte {
+	flags := byte(0)
+	if gb.interruptsEnabling {
+		flags |= 1
+	}
+	if gb.interruptsOn {
+		flags |= 2
+	}
+	if gb.halted {
+		flags |= 4
+	}
+	if gb.stopped {
+		flags |= 8
+	}
+	if gb.interruptsJustEnabled {
+		flags |= 16
+	}
+	if gb.haltBug {
+		flags |= 32
+	}
+	return flags
+}
+
+// Set the interrupt and CPU power state from the flags from interruptFlags.
+func (gb *Gameboy) setInterruptFlags(flags byte) {
+	gb.interruptsEnabling = flags&1 != 0
+	gb.interruptsOn = flags&2 != 0
+	gb.halted = flags&4 != 0
+	gb.stopped = flags&8 != 0
+	gb.interruptsJustEnabled = flags&16 != 0
+	gb.haltBug = flags&32 != 0
+}
+
+// Size of the state which is not part of the memory regions in a fast state.
+const fastStateHeaderSize = registerStateSize + 24
+
+// ErrFastStateSize is returned when loading a fast save state which is not
+// the size of the state of the loaded game.
+var ErrFastStateSize = errors.New("fast save state has the wrong size")
+
+// FastStateSize returns the size of the buffer needed to save the state with
+// SaveStateFast. This depends on the cartridge of the loaded game.
+func (gb *Gameboy) FastStateSize() int {
+	var stateSize countWriter
+	gb.Memory.Cart.SaveState(&stateSize)
+	gb.Sound.SaveState(&stateSize)
+	return fastStateHeaderSize + len(gb.Memory.HighRAM) + len(gb.Memory.VRAM) +
+		len(gb.Memory.WRAM) + len(gb.Memory.OAM) + len(gb.BGPalette.Palette) +
+		len(gb.SpritePalette.Palette) + int(stateSize)
+}
+
+// SaveStateFast saves the state of the emulator into a buffer and returns the
+// number of bytes written. This is much faster than SaveState so can be used
+// for rollback and rewinding, however the format is not portable and should
+// only be loaded with LoadStateFast by the same build of the emulator. The
+// buffer can be reused between calls to avoid allocating. If the buffer is
+// smaller than FastStateSize then nothing is saved and 0 is returned.
+//
+// The screen is not part of the state, so the frame is not correct until the
+// next frame has been rendered.
+func (gb *Gameboy) SaveStateFast(buf []byte) int {
+	if len(buf) < gb.FastStateSize() {
+		return 0
+	}
+
+	gb.packRegisterState(buf)
+	header := buf[registerStateSize:fastStateHeaderSize]
+	binary.LittleEndian.PutUint32(header[0:], uint32(gb.thisCpuTicks))
+	binary.LittleEndian.PutUint32(header[4:], uint32(gb.cycleOverflow))
+	header[8] = gb.Memory.hdmaLength
+	header[9] = boolByte(gb.Memory.hdmaActive)
+	header[10] = boolByte(gb.prepareSpeed)
+	header[11] = gb.BGPalette.Index
+	header[12] = boolByte(gb.BGPalette.Inc)
+	header[13] = gb.SpritePalette.Index
+	header[14] = boolByte(gb.SpritePalette.Inc)
+	binary.LittleEndian.PutUint32(header[15:], uint32(gb.serialBits))
+	binary.LittleEndian.PutUint32(header[19:], uint32(gb.serialCounter))
+	header[23] = gb.serialIn
+
+	n := fastStateHeaderSize
+	n += copy(buf[n:], gb.Memory.HighRAM[:])
+	n += copy(buf[n:], gb.Memory.VRAM[:])
+	n += copy(buf[n:], gb.Memory.WRAM[:])
+	n += copy(buf[n:], gb.Memory.OAM[:])
+	n += copy(buf[n:], gb.BGPalette.Palette)
+	n += copy(buf[n:], gb.SpritePalette.Palette)
+
+	// The cartridge and sound state are written after the memory
+	state := stateBuffer{buf: buf[n:]}
+	if err := gb.Memory.Cart.SaveState(&state); err != nil {
+		return 0
+	}
+	if err := gb.Sound.SaveState(&state); err != nil {
+		return 0
+	}
+	return n + state.off
+}
+
+// LoadStateFast loads a state saved with SaveStateFast. ErrFastStateSize is
+// returned if the state is not the expected size for the loaded game.
+func (gb *Gameboy) LoadStateFast(data []byte) error {
+	if len(data) != gb.FastStateSize() {
+		return ErrFastStateSize
+	}
+
+	gb.unpackRegisterState(data)
+	header := data[registerStateSize:fastStateHeaderSize]
+	gb.thisCpuTicks = int(int32(binary.LittleEndian.Uint32(header[0:])))
+	gb.cycleOverflow = int(int32(binary.LittleEndian.Uint32(header[4:])))
+	gb.Memory.hdmaLength = header[8]
+	gb.Memory.hdmaActive = header[9] != 0
+	gb.prepareSpeed = header[10] != 0
+	gb.BGPalette.Index = header[11]
+	gb.BGPalette.Inc = header[12] != 0
+	gb.SpritePalette.Index = header[13]
+	gb.SpritePalette.Inc = header[14] != 0
+	gb.serialBits = int(int32(binary.LittleEndian.Uint32(header[15:])))
+	gb.serialCounter = int(int32(binary.LittleEndian.Uint32(header[19:])))
+	gb.serialIn = header[23]
+
+	n := fastStateHeaderSize
+	n += copy(gb.Memory.HighRAM[:], data[n:])
+	n += copy(gb.Memory.VRAM[:], data[n:])
+	n += copy(gb.Memory.WRAM[:], data[n:])
+	n += copy(gb.Memory.OAM[:], data[n:])
+	n += copy(gb.BGPalette.Palette, data[n:])
+	n += copy(gb.SpritePalette.Palette, data[n:])
+
+	state := &stateBuffer{buf: data[n:]}
+	if err := gb.Memory.Cart.LoadState(state); err != nil {
+		return err
+	}
+	return gb.Sound.LoadState(state)
+}
+
+// Convert a bool to a byte of 1 or 0.
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Writer which counts the number of bytes written to it.
+type countWriter int
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	*w += countWriter(len(p))
+	return len(p), nil
+}
+
+// Buffer which reads and writes to a fixed size byte slice.
+type stateBuffer struct {
+	buf []byte
+	off int
+}
+
+func (b *stateBuffer) Write(p []byte) (int, error) {
+	if len(p) > len(b.buf)-b.off {
+		return 0, io.ErrShortBuffer
+	}
+	b.off += copy(b.buf[b.off:], p)
+	return len(p), nil
+}
+
+func (b *stateBuffer) Read(p []byte) (int, error) {
+	if b.off >= len(b.buf) && len(p) > 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.buf[b.off:])
+	b.off += n
+	return n, nil
+}
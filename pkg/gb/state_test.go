@@ -0,0 +1,111 @@
+package gb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Humpheh/goboy/pkg/cart"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGameboy_StateHash(t *testing.T) {
+	gb1, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb2, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	previous := gb1.StateHash()
+	for i := 0; i < 10; i++ {
+		gb1.Update()
+		gb2.Update()
+		hash := gb1.StateHash()
+		assert.Equal(t, hash, gb2.StateHash(), "hashes differ on frame %v", i)
+		assert.NotEqual(t, previous, hash, "hash did not change on frame %v", i)
+		previous = hash
+	}
+
+	gb2.Memory.WRAM[0x1000]++
+	assert.NotEqual(t, gb1.StateHash(), gb2.StateHash())
+	gb2.Memory.WRAM[0x1000]--
+	assert.Equal(t, gb1.StateHash(), gb2.StateHash())
+
+	gb2.CPU.Divider++
+	assert.NotEqual(t, gb1.StateHash(), gb2.StateHash())
+	gb2.CPU.Divider--
+
+	// The selected banks and the sound registers are part of the state.
+	gb2.Memory.Write(0x2000, 0x02)
+	assert.NotEqual(t, gb1.StateHash(), gb2.StateHash())
+	gb2.Memory.Write(0x2000, byte(gb1.Memory.Cart.BankState().RomBank))
+	assert.Equal(t, gb1.StateHash(), gb2.StateHash())
+
+	gb2.Memory.Write(0xFF24, gb1.Memory.Read(0xFF24)^0x11)
+	assert.NotEqual(t, gb1.StateHash(), gb2.StateHash())
+}
+
+func BenchmarkGameboy_StateHash(b *testing.B) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(b, err, "error in init gb %v", err)
+	// Use a cartridge with 128KB of RAM, which is hashed on every call.
+	gb.Memory.Cart = &cart.Cart{BankingController: cart.NewMBC5(make([]byte, 0x8000))}
+
+	allocs := testing.AllocsPerRun(10, func() { gb.StateHash() })
+	require.Zero(b, allocs, "StateHash should not allocate")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gb.StateHash()
+	}
+}
+
+func TestGameboy_StateFast(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+	gb.RunFrames(10)
+
+	buf := make([]byte, gb.FastStateSize())
+	n := gb.SaveStateFast(buf)
+	require.Equal(t, len(buf), n)
+	expected := gb.StateHash()
+
+	gb.RunFrames(10)
+	hashAfter := gb.StateHash()
+	require.NotEqual(t, expected, hashAfter)
+
+	require.NoError(t, gb.LoadStateFast(buf[:n]))
+	assert.Equal(t, expected, gb.StateHash())
+
+	// The cartridge banks and sound state are restored.
+	regions := gb.MemoryRegions()
+	gb.Memory.Write(0x2000, byte(regions.ROMBank+1))
+	gb.Memory.Write(0xFF24, gb.Memory.Read(0xFF24)^0x11)
+	require.NoError(t, gb.LoadStateFast(buf[:n]))
+	assert.Equal(t, regions, gb.MemoryRegions())
+	assert.Equal(t, expected, gb.StateHash())
+
+	// Running from the loaded state should give the same result
+	gb.RunFrames(10)
+	assert.Equal(t, hashAfter, gb.StateHash())
+}
+
+func TestGameboy_StateFastErrors(t *testing.T) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(t, err, "error in init gb %v", err)
+
+	buf := make([]byte, gb.FastStateSize()-1)
+	assert.Equal(t, 0, gb.SaveStateFast(buf))
+	assert.True(t, errors.Is(gb.LoadStateFast(buf), ErrFastStateSize))
+}
+
+func BenchmarkGameboy_SaveStateFast(b *testing.B) {
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb")
+	require.NoError(b, err, "error in init gb %v", err)
+	buf := make([]byte, gb.FastStateSize())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gb.SaveStateFast(buf)
+	}
+}
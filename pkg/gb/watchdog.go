@@ -0,0 +1,50 @@
+package gb
+
+// Size of the range of addresses the PC must stay within for the CPU to be
+// considered stuck in a loop.
+const stallWindow = 0x10
+
+// stallDetector watches for the game getting stuck in a tight loop, such as
+// waiting for an interrupt which never comes. It is set up by
+// WithStallCallback.
+type stallDetector struct {
+	// Number of frames the game must be stuck for before the callback is
+	// called.
+	threshold int
+	callback  func()
+
+	// Range of addresses the PC has been within since the last activity.
+	minPC, maxPC uint16
+	// Number of frames since the last activity.
+	frames int
+}
+
+// Reset the detector after activity, with the PC at an address.
+func (s *stallDetector) reset(pc uint16) {
+	s.minPC, s.maxPC = pc, pc
+	s.frames = 0
+}
+
+// Check an instruction at an address which has been run, or the address the
+// CPU is halted at. The detector is reset if the instruction wrote to memory
+// or the PC has left the window.
+func (gb *Gameboy) watchStall(pc uint16, wrote bool) {
+	s := gb.stall
+	minPC, maxPC := min(s.minPC, pc), max(s.maxPC, pc)
+	if wrote || maxPC-minPC >= stallWindow {
+		s.reset(pc)
+		return
+	}
+	s.minPC, s.maxPC = minPC, maxPC
+}
+
+// Count a frame where the game may be stuck, and call the callback once the
+// game has been stuck for the threshold number of frames. The callback is only
+// called once until there is activity again.
+func (gb *Gameboy) countStallFrame() {
+	s := gb.stall
+	s.frames++
+	if s.frames == s.threshold {
+		s.callback()
+	}
+}
@@ -0,0 +1,67 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStallCallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		stalls  int
+	}{
+		// JR -2
+		{name: "jr loop", program: []byte{0x18, 0xFE}, stalls: 1},
+		// HALT with no interrupts enabled
+		{name: "halt", program: []byte{0x76, 0x18, 0xFD}, stalls: 1},
+		// LD (HL),A; INC A; JR -4
+		{name: "writing loop", program: []byte{0x77, 0x3C, 0x18, 0xFC}, stalls: 0},
+		// INC HL; LD A,(HL); JR -4, which reads from a wide range of addresses
+		// but the loop itself stays in the window.
+		{name: "reading loop", program: []byte{0x23, 0x7E, 0x18, 0xFC}, stalls: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stalls := 0
+			gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithStallCallback(10, func() {
+				stalls++
+			}))
+			require.NoError(t, err, "error in init gb %v", err)
+
+			for i, b := range test.program {
+				gb.Memory.Write(0xC000+uint16(i), b)
+			}
+			gb.CPU.PC = 0xC000
+			gb.CPU.HL.Set(0xC100)
+			gb.interruptsOn = false
+			gb.Memory.Write(0xFFFF, 0)
+
+			gb.RunFrames(9)
+			assert.Equal(t, 0, stalls, "should not be stuck before the threshold")
+			gb.RunFrames(20)
+			assert.Equal(t, test.stalls, stalls)
+		})
+	}
+}
+
+func TestStallCallback_Interrupts(t *testing.T) {
+	stalls := 0
+	gb, err := NewGameboy("./../../roms/blargg/cpu_instrs.gb", WithStallCallback(10, func() {
+		stalls++
+	}))
+	require.NoError(t, err, "error in init gb %v", err)
+
+	// A game waiting in a loop for the VBlank interrupt is not stuck. The
+	// handler in the rom does not enable interrupts, so the loop does.
+	// EI; JR -3
+	gb.Memory.Write(0xC000, 0xFB)
+	gb.Memory.Write(0xC001, 0x18)
+	gb.Memory.Write(0xC002, 0xFD)
+	gb.CPU.PC = 0xC000
+	gb.Memory.Write(0xFFFF, 0x01)
+	gb.RunFrames(30)
+	assert.Equal(t, 0, stalls)
+}